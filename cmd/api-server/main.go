@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/api"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
@@ -36,28 +36,182 @@ func mainLogic() int {
 	// something like this:
 	// logger.SetLevel(config.Options.LogLevel)
 
-	// Read credit cards and reason to fail from yaml file and populate edge cases struct
-	yamlContent, err := ioutil.ReadFile(config.Options.CreditCards.Filename)
+	// Read credit cards and reason to fail from yaml file and populate edge cases struct.
+	// The watcher loads it, so changes made from now on are reloaded once it's started.
+	creditCardFileChecker := repository.NewCreditCardFileChecker()
+	watcher := lifecycle.NewFileWatcher(logger, config.Options.CreditCards.Filename, creditCardFileChecker,
+		config.Options.CreditCards.ReloadInterval)
+	err := watcher.Load()
 	if err != nil {
 		logger.Error(err.Error(), log.Field("type", "setup"))
 		return 1
 	}
 
-	creditCardFileChecker := repository.NewCreditCardFileChecker()
-	err = creditCardFileChecker.Load(yamlContent)
-	if err != nil {
-		logger.Error(err.Error(), log.Field("type", "setup"))
-		return 1
+	// Write the credit cards changed through the admin API back to the file, if enabled
+	if config.Options.CreditCards.WriteBack {
+		creditCardFileChecker.WriteBackTo(config.Options.CreditCards.Filename)
+	}
+
+	// Seed the latencies and random failures, so runs can be reproduced
+	seed := config.Options.RandomFailures.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.Info("seeding random latencies and failures", log.Field("type", "setup"), log.Field("seed", seed))
+	creditCardFileChecker.SeedLatencies(seed)
+
+	// Layer random failures over the credit cards, if enabled
+	var creditCardChecker core.CreditCardChecker = creditCardFileChecker
+	if config.Options.RandomFailures.Enabled() {
+		logger.Info("injecting random failures", log.Field("type", "setup"))
+		creditCardChecker = repository.NewRandomFailureChecker(creditCardFileChecker,
+			config.Options.RandomFailures.DeclineRates, config.Options.RandomFailures.ErrorRates, seed)
+	}
+
+	// Init Authoriser
+	var authoriser interface {
+		core.Authoriser
+		core.Settler
+		core.Expirer
+	}
+	var snapshotFile *repository.SnapshotFile
+
+	switch config.Options.Authorisations.Storage {
+	case core.StorageFile:
+		logger.Info("opening authorisations database", log.Field("type", "setup"),
+			log.Field("filename", config.Options.Authorisations.Filename))
+		authStore, err := repository.NewAuthoriserBoltStore(config.Options.Authorisations.Filename,
+			config.Options.Authorisations.TTL)
+		if err != nil {
+			logger.Error(err.Error(), log.Field("type", "setup"))
+			return 1
+		}
+		authoriser = authStore
+	default:
+		authTracker := repository.NewAuthoriserInMemoryTracker(config.Options.Authorisations.TTL)
+
+		// Restore authorisations from the last snapshot
+		if config.Options.Authorisations.SnapshotFilename != "" {
+			snapshotFile = repository.NewSnapshotFile(config.Options.Authorisations.SnapshotFilename, authTracker)
+			ok, err := snapshotFile.Load()
+			if err != nil {
+				logger.Error(fmt.Sprintf("failed to restore snapshot: %s", err.Error()), log.Field("type", "setup"))
+				return 1
+			}
+			if ok {
+				logger.Info("authorisations restored from snapshot", log.Field("type", "setup"),
+					log.Field("filename", config.Options.Authorisations.SnapshotFilename))
+			}
+		}
+
+		authoriser = authTracker
+	}
+
+	// Components to shutdown after the server, in order
+	var components []core.ShutDowner
+
+	// Reload the credit cards file when it changes, or on SIGHUP
+	watcher.Start()
+	components = append(components, watcher)
+
+	// Start reaper to expire stale authorisations
+	reaper := lifecycle.NewReaper(logger, authoriser, config.Options.Authorisations.ReaperInterval)
+	reaper.Start()
+	components = append(components, reaper)
+
+	// Init idempotency keys store, expired keys are reaped as well, as often as authorisations
+	idempotencyStore := repository.NewIdempotencyInMemoryStore(config.Options.IdempotencyKeyTTL)
+	idempotencyReaper := lifecycle.NewReaper(logger, idempotencyStore, config.Options.Authorisations.ReaperInterval)
+	idempotencyReaper.Start()
+	components = append(components, idempotencyReaper)
+
+	// Deliver webhooks for captures, settlements, voids and refunds, if enabled.
+	// They are shut down after anything notifying them.
+	var notifier core.Notifier
+	var webhookComponents []core.ShutDowner
+	if config.Options.Webhooks.Enabled() {
+		var webhookStore interface {
+			core.WebhookStore
+			core.Expirer
+		}
+		if config.Options.Webhooks.QueueFilename != "" {
+			logger.Info("opening webhooks database", log.Field("type", "setup"),
+				log.Field("filename", config.Options.Webhooks.QueueFilename))
+			boltStore, err := repository.NewWebhookBoltStore(config.Options.Webhooks.QueueFilename,
+				config.Options.Webhooks.LogRetention)
+			if err != nil {
+				logger.Error(err.Error(), log.Field("type", "setup"))
+				return 1
+			}
+			webhookStore = boltStore
+		} else {
+			webhookStore = repository.NewWebhookInMemoryStore(config.Options.Webhooks.LogRetention)
+		}
+
+		webhookDispatcher := lifecycle.NewWebhookDispatcher(logger, webhookStore, config.Options.Webhooks.URLs,
+			config.Options.Webhooks.Secret, config.Options.Webhooks.MaxAttempts, config.Options.Webhooks.RetryBackoff)
+		webhookDispatcher.Start()
+		webhookComponents = append(webhookComponents, webhookDispatcher)
+		notifier = webhookDispatcher
+
+		// Old deliveries are dropped from the delivery log, as often as authorisations are reaped
+		webhookReaper := lifecycle.NewReaper(logger, webhookStore, config.Options.Authorisations.ReaperInterval)
+		webhookReaper.Start()
+		webhookComponents = append(webhookComponents, webhookReaper)
+
+		// Close the webhooks database once nothing else can write to it
+		if closer, ok := webhookStore.(core.ShutDowner); ok {
+			webhookComponents = append(webhookComponents, closer)
+		}
+	}
+
+	// Settle captures in the background, if they settle asynchronously
+	if config.Options.Settlement.Enabled() {
+		logger.Info("settling captures asynchronously", log.Field("type", "setup"),
+			log.Field("mode", config.Options.Settlement.Mode))
+		settlementScheduler := lifecycle.NewSettlementScheduler(logger, authoriser, creditCardChecker, notifier,
+			config.Options.Settlement.Interval)
+		settlementScheduler.Start()
+		components = append(components, settlementScheduler)
+	}
+
+	components = append(components, webhookComponents...)
+
+	// Save a snapshot of the authorisations once nothing else can change them
+	if snapshotFile != nil {
+		components = append(components, snapshotFile)
 	}
 
-	// Init Authtracker
-	authTracker := repository.NewAuthoriserInMemoryTracker()
+	// Close the authorisations database once nothing else can write to it
+	if closer, ok := authoriser.(core.ShutDowner); ok {
+		components = append(components, closer)
+	}
+
+	serverOptions := []api.ServerOption{
+		api.WithNotFoundHTTPStatus(config.Options.NotFoundHTTPStatus),
+		api.WithIdempotencyStore(idempotencyStore),
+		api.WithAcceptedCurrencies(config.Options.AcceptedCurrencies),
+		api.WithLatencyProvider(creditCardFileChecker),
+	}
+	if config.Options.CreditCards.AdminAPI {
+		serverOptions = append(serverOptions, api.WithCardFailuresStore(creditCardFileChecker))
+	}
+	if notifier != nil {
+		serverOptions = append(serverOptions, api.WithNotifier(notifier))
+	}
+	if config.Options.Settlement.Enabled() {
+		serverOptions = append(serverOptions, api.WithSettlement(authoriser, config.Options.Settlement.Schedule()))
+	}
 
 	server := api.NewServer(config.Webserver.Host, config.Webserver.Port, config.Options.DevMode,
-		logger, creditCardFileChecker, authTracker)
+		logger, creditCardChecker, authoriser, serverOptions...)
 
 	// Spawn SIGINT listener
-	go lifecycle.TerminateHandler(logger, server)
+	terminated := make(chan struct{})
+	go func() {
+		lifecycle.TerminateHandler(logger, server, components...)
+		close(terminated)
+	}()
 
 	// Listen for incoming requests -- app blocks here
 	logger.Info("listenning for incoming requests", log.Field("type", "setup"))
@@ -67,6 +221,9 @@ func mainLogic() int {
 		return 1
 	}
 
+	// Wait for the remaining components to shutdown
+	<-terminated
+
 	logger.Info("APP gracefully terminated")
 	return 0
 }
@@ -21,11 +21,101 @@ type Server struct {
 
 	Router     *gin.Engine
 	HTTPServer http.Server
+
+	// notFoundHTTPStatus is the HTTP status returned when the authorisation ID is unknown
+	notFoundHTTPStatus int
+	// idempotencyStore holds the responses to requests carrying an idempotency key, if enabled
+	idempotencyStore core.IdempotencyStore
+	// acceptedCurrencies are the currencies authorisations are accepted in, nil accepts all of them
+	acceptedCurrencies map[string]struct{}
+	// latencyProvider sets the latency of the payment endpoints, if any
+	latencyProvider core.LatencyProvider
+	// cardFailuresStore is managed through the admin API, which is only served if it's set
+	cardFailuresStore core.CardFailuresStore
+	// notifier is notified of the captures, voids and refunds, if set
+	notifier core.Notifier
+	// settler leaves captures pending settlement, to be settled later according to settlementSchedule, if set
+	settler            core.Settler
+	settlementSchedule core.SettlementSchedule
+}
+
+// ServerOption configures optional behaviour of the server.
+type ServerOption func(s *Server)
+
+// WithNotFoundHTTPStatus sets the HTTP status returned when the authorisation ID is unknown.
+// It defaults to 200, like any other result reported in the response body.
+func WithNotFoundHTTPStatus(status int) ServerOption {
+	return func(s *Server) {
+		s.notFoundHTTPStatus = status
+	}
+}
+
+// WithIdempotencyStore enables support for the Idempotency-Key header on all payment endpoints.
+func WithIdempotencyStore(store core.IdempotencyStore) ServerOption {
+	return func(s *Server) {
+		s.idempotencyStore = store
+	}
+}
+
+// WithAcceptedCurrencies restricts authorisations to the provided ISO 4217 currency codes.
+// Authorisations in any other currency are declined with ResultCode_CurrencyNotAccepted.
+// An empty list accepts all currencies.
+func WithAcceptedCurrencies(codes []string) ServerOption {
+	return func(s *Server) {
+		if len(codes) == 0 {
+			s.acceptedCurrencies = nil
+			return
+		}
+		s.acceptedCurrencies = make(map[string]struct{}, len(codes))
+		for _, code := range codes {
+			s.acceptedCurrencies[code] = struct{}{}
+		}
+	}
+}
+
+// WithLatencyProvider delays the responses of the payment endpoints by the latency set for them,
+// and draws the delays of the outcomes from it. Without it, outcomes are only delayed by their fixed delay.
+func WithLatencyProvider(provider core.LatencyProvider) ServerOption {
+	return func(s *Server) {
+		s.latencyProvider = provider
+	}
+}
+
+// WithCardFailuresStore enables the admin API, managing the operations failing on each credit card at runtime.
+func WithCardFailuresStore(store core.CardFailuresStore) ServerOption {
+	return func(s *Server) {
+		s.cardFailuresStore = store
+	}
 }
 
+// WithNotifier notifies the notifier of every capture, void and refund, e.g. to send webhooks.
+// The webhook delivery log is served too if the notifier keeps one.
+func WithNotifier(notifier core.Notifier) ServerOption {
+	return func(s *Server) {
+		s.notifier = notifier
+	}
+}
+
+// WithSettlement leaves captures pending settlement, to be settled later according to the schedule,
+// instead of captured straight away. The settler is usually the authoriser itself.
+func WithSettlement(settler core.Settler, schedule core.SettlementSchedule) ServerOption {
+	return func(s *Server) {
+		s.settler = settler
+		s.settlementSchedule = schedule
+	}
+}
+
+// writeTimeoutMargin is how long before the write timeout waiting for latency stops, so a response can still be written.
+const writeTimeoutMargin = 100 * time.Millisecond
+
 // NewServer creates a new server.
-func NewServer(addr string, port int, devMode bool, logger log.Logger, repo core.CreditCardChecker, authoriser core.Authoriser) *Server {
-	s := &Server{Logger: logger, Repo: repo, Authoriser: authoriser}
+func NewServer(addr string, port int, devMode bool, logger log.Logger, repo core.CreditCardChecker, authoriser core.Authoriser,
+	options ...ServerOption) *Server {
+	s := &Server{Logger: logger, Repo: repo, Authoriser: authoriser, notFoundHTTPStatus: 200}
+
+	for _, option := range options {
+		option(s)
+	}
 
 	if !devMode {
 		gin.SetMode(gin.ReleaseMode)
@@ -59,11 +149,31 @@ func (s *Server) setupRoutes(devMode bool) {
 	s.Router.NoRoute(NoRoute)
 	v1 := s.Router.Group("/api/v1")
 	v1.GET("/healthcheck", s.Healthcheck)
-
-	v1.POST("/authorise", s.AuthoriseTransaction)
-	v1.POST("/capture", s.CaptureTransaction)
-	v1.POST("/void", s.VoidTransaction)
-	v1.POST("/refund", s.RefundTransaction)
+	v1.GET("/magic-amounts", s.ListMagicAmounts)
+
+	payments := v1.Group("")
+	payments.Use(middleware.Deadline(s.HTTPServer.WriteTimeout - writeTimeoutMargin))
+	// Faults are injected into the responses as stored for idempotency keys, so retries get a clean response
+	payments.Use(middleware.FaultInjection())
+	if s.idempotencyStore != nil {
+		payments.Use(middleware.Idempotency(s.idempotencyStore))
+	}
+	payments.POST("/authorise", s.endpointLatency(core.CCFailReason_Authorise), s.AuthoriseTransaction)
+	payments.POST("/capture", s.endpointLatency(core.CCFailReason_Capture), s.CaptureTransaction)
+	payments.POST("/void", s.endpointLatency(core.CCFailReason_Void), s.VoidTransaction)
+	payments.POST("/refund", s.endpointLatency(core.CCFailReason_Refund), s.RefundTransaction)
+
+	v1.GET("/transactions", s.ListTransactions)
+	v1.GET("/transactions/:authorisation_id", s.GetTransaction)
+	v1.GET("/webhooks/deliveries", s.ListWebhookDeliveries)
+
+	if s.cardFailuresStore != nil {
+		admin := s.Router.Group("/admin/v1")
+		admin.GET("/cards", s.ListCards)
+		admin.GET("/cards/:number", s.GetCard)
+		admin.PUT("/cards/:number", s.PutCard)
+		admin.DELETE("/cards/:number", s.DeleteCard)
+	}
 
 	// Profiler
 	// URL: https://<IP>:<PORT>/debug/pprof/
@@ -73,6 +183,25 @@ func (s *Server) setupRoutes(devMode bool) {
 	}
 }
 
+// endpointLatency returns the middleware delaying the responses of the endpoint of the operation.
+func (s *Server) endpointLatency(operation core.CCFailReason) gin.HandlerFunc {
+	return middleware.Latency(func() time.Duration {
+		if s.latencyProvider == nil {
+			return 0
+		}
+		latency, _ := s.latencyProvider.EndpointLatency(operation)
+		return latency
+	})
+}
+
+// responseDelay returns how long to wait before responding with the outcome.
+func (s *Server) responseDelay(outcome core.Outcome) time.Duration {
+	if s.latencyProvider == nil {
+		return outcome.Delay
+	}
+	return s.latencyProvider.ResponseDelay(outcome)
+}
+
 // ListenAndServe listens and serves incoming requests.
 func (s *Server) ListenAndServe() error {
 	if err := s.HTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
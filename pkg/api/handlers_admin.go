@@ -0,0 +1,166 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// cardFailure is an operation failing on a credit card, as reported by the admin API.
+type cardFailure struct {
+	Operation string             `json:"operation"`
+	Reason    core.DeclineReason `json:"reason"`
+	Message   string             `json:"message"`
+}
+
+// card is a credit card and the operations failing on it, as reported by the admin API.
+type card struct {
+	Number   int64         `json:"number"`
+	Failures []cardFailure `json:"failures"`
+}
+
+// newCard returns the credit card as reported by the admin API.
+func newCard(number int64, failures core.CardFailures) card {
+	result := card{Number: number, Failures: make([]cardFailure, 0, len(failures))}
+	for _, failure := range failures {
+		result.Failures = append(result.Failures, cardFailure{
+			Operation: failure.Operation.Name(),
+			Reason:    failure.Reason,
+			Message:   failure.Reason.Message(),
+		})
+	}
+	return result
+}
+
+// ListCards lists the credit cards with operations failing on them, ordered by number.
+func (s *Server) ListCards(c *gin.Context) {
+	cardFailures := s.cardFailuresStore.ListCardFailures()
+
+	numbers := make([]int64, 0, len(cardFailures))
+	for number := range cardFailures {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	responseBody := struct {
+		Cards []card `json:"cards"`
+	}{Cards: make([]card, 0, len(numbers))}
+
+	for _, number := range numbers {
+		responseBody.Cards = append(responseBody.Cards, newCard(number, cardFailures[number]))
+	}
+
+	c.JSON(200, responseBody)
+}
+
+// GetCard reports the operations failing on a credit card.
+func (s *Server) GetCard(c *gin.Context) {
+	number, ok := parseCardNumber(c)
+	if !ok {
+		return
+	}
+
+	failures, ok := s.cardFailuresStore.GetCardFailures(number)
+	if !ok {
+		RespondWithError(c, 404, "card not found")
+		return
+	}
+
+	c.JSON(200, newCard(number, failures))
+}
+
+// PutCard sets the operations failing on a credit card, replacing the ones set before.
+func (s *Server) PutCard(c *gin.Context) {
+	number, ok := parseCardNumber(c)
+	if !ok {
+		return
+	}
+	// Only cards that can be authorised are added, unlike the ones loaded from the file
+	if !core.ValidCardNumber(number) {
+		RespondWithError(c, 400, "invalid card number")
+		return
+	}
+
+	requestBody := struct {
+		Failures []struct {
+			Operation string `json:"operation" binding:"required"`
+			Reason    string `json:"reason"`
+		} `json:"failures" binding:"required,min=1"`
+	}{}
+
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		s.Logger.Info(fmt.Sprintf("error parsing body: %s", err.Error()))
+		RespondWithError(c, 400, "error parsing body")
+		return
+	}
+
+	failures := make(core.CardFailures, 0, len(requestBody.Failures))
+	for _, item := range requestBody.Failures {
+		failure := core.CardFailure{Reason: core.DeclineReason_DoNotHonour}
+		if err := failure.Operation.Load(item.Operation); err != nil {
+			RespondWithError(c, 400, fmt.Sprintf("invalid operation <%s>", item.Operation))
+			return
+		}
+		if item.Reason != "" {
+			if err := failure.Reason.Load(item.Reason); err != nil {
+				RespondWithError(c, 400, fmt.Sprintf("invalid reason <%s>", item.Reason))
+				return
+			}
+		}
+		failures = append(failures, failure)
+	}
+
+	if err := failures.Validate(); err != nil {
+		RespondWithError(c, 400, fmt.Sprintf("invalid failures: %s", err.Error()))
+		return
+	}
+
+	created, err := s.cardFailuresStore.PutCardFailures(number, failures)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("error storing card failures: %s", err.Error()))
+		RespondWithError(c, 500, "internal error")
+		return
+	}
+
+	status := 200
+	if created {
+		status = 201
+	}
+	c.JSON(status, newCard(number, failures))
+}
+
+// DeleteCard removes the operations failing on a credit card, so it's processed normally.
+func (s *Server) DeleteCard(c *gin.Context) {
+	number, ok := parseCardNumber(c)
+	if !ok {
+		return
+	}
+
+	ok, err := s.cardFailuresStore.DeleteCardFailures(number)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("error deleting card failures: %s", err.Error()))
+		RespondWithError(c, 500, "internal error")
+		return
+	}
+	if !ok {
+		RespondWithError(c, 404, "card not found")
+		return
+	}
+
+	c.Status(204)
+}
+
+// parseCardNumber parses the card number in the path, responding with a 400 if it's not a positive number.
+// The checksum isn't checked, as the credit cards file doesn't check it either.
+func parseCardNumber(c *gin.Context) (number int64, ok bool) {
+	number, err := strconv.ParseInt(c.Param("number"), 10, 64)
+	if err != nil || number <= 0 {
+		RespondWithError(c, 400, "invalid card number")
+		return 0, false
+	}
+	return number, true
+}
@@ -0,0 +1,251 @@
+package api_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/api"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminCards(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`creditCards: {4000000000000259: {operation: "capture fail", reason: "insufficient_funds"}}`))
+	require.NoError(t, err)
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at, api.WithCardFailuresStore(ccfc))
+	router := server.Router
+
+	authoriseBody := `{"credit_card": {"name": "customer1", "number": 4000000000000119, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+		`"currency": "EUR", "amount": 10.50}`
+
+	// Steps run in order, each one building on the previous ones
+	steps := []struct {
+		name                 string
+		method               string
+		path                 string
+		body                 string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		{
+			name:               "list",
+			method:             "GET",
+			path:               "/admin/v1/cards",
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"cards": [{"number": 4000000000000259, "failures": [
+				{"operation": "capture", "reason": "insufficient_funds", "message": "Insufficient funds"}]}]}`,
+		},
+		{
+			name:                 "authorise before the card fails",
+			method:               "POST",
+			path:                 "/api/v1/authorise",
+			body:                 authoriseBody,
+			expectedStatusCode:   200,
+			expectedResponseBody: "",
+		},
+		{
+			name:               "create",
+			method:             "PUT",
+			path:               "/admin/v1/cards/4000000000000119",
+			body:               `{"failures": [{"operation": "authorise", "reason": "lost_card"}, {"operation": "void fail"}]}`,
+			expectedStatusCode: 201,
+			expectedResponseBody: `{"number": 4000000000000119, "failures": [
+				{"operation": "authorise", "reason": "lost_card", "message": "Lost card"},
+				{"operation": "void", "reason": "do_not_honour", "message": "Do not honour"}]}`,
+		},
+		{
+			name:               "authorise once the card fails",
+			method:             "POST",
+			path:               "/api/v1/authorise",
+			body:               authoriseBody,
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"code": 2, "card_brand": "visa",
+				"decline_code": "lost_card", "decline_message": "Lost card"}`,
+		},
+		{
+			name:               "replace",
+			method:             "PUT",
+			path:               "/admin/v1/cards/4000000000000119",
+			body:               `{"failures": [{"operation": "refund"}]}`,
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"number": 4000000000000119, "failures": [
+				{"operation": "refund", "reason": "do_not_honour", "message": "Do not honour"}]}`,
+		},
+		{
+			name:               "get",
+			method:             "GET",
+			path:               "/admin/v1/cards/4000000000000119",
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"number": 4000000000000119, "failures": [
+				{"operation": "refund", "reason": "do_not_honour", "message": "Do not honour"}]}`,
+		},
+		{
+			name:               "delete",
+			method:             "DELETE",
+			path:               "/admin/v1/cards/4000000000000259",
+			expectedStatusCode: 204,
+		},
+		{
+			name:               "list after the changes",
+			method:             "GET",
+			path:               "/admin/v1/cards",
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"cards": [{"number": 4000000000000119, "failures": [
+				{"operation": "refund", "reason": "do_not_honour", "message": "Do not honour"}]}]}`,
+		},
+		{
+			name:                 "get unknown card",
+			method:               "GET",
+			path:                 "/admin/v1/cards/4000000000000259",
+			expectedStatusCode:   404,
+			expectedResponseBody: `{"message": "card not found"}`,
+		},
+		{
+			name:                 "delete unknown card",
+			method:               "DELETE",
+			path:                 "/admin/v1/cards/4000000000000259",
+			expectedStatusCode:   404,
+			expectedResponseBody: `{"message": "card not found"}`,
+		},
+		{
+			name:                 "invalid card number",
+			method:               "PUT",
+			path:                 "/admin/v1/cards/4000000000000118",
+			body:                 `{"failures": [{"operation": "refund"}]}`,
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid card number"}`,
+		},
+		{
+			name:                 "no failures",
+			method:               "PUT",
+			path:                 "/admin/v1/cards/4000000000000259",
+			body:                 `{"failures": []}`,
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "error parsing body"}`,
+		},
+		{
+			name:                 "unknown operation",
+			method:               "PUT",
+			path:                 "/admin/v1/cards/4000000000000259",
+			body:                 `{"failures": [{"operation": "explode"}]}`,
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid operation <explode>"}`,
+		},
+		{
+			name:                 "unknown reason",
+			method:               "PUT",
+			path:                 "/admin/v1/cards/4000000000000259",
+			body:                 `{"failures": [{"operation": "refund", "reason": "bad luck"}]}`,
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid reason <bad luck>"}`,
+		},
+		{
+			name:                 "operation listed twice",
+			method:               "PUT",
+			path:                 "/admin/v1/cards/4000000000000259",
+			body:                 `{"failures": [{"operation": "refund"}, {"operation": "refund fail"}]}`,
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid failures: operation <refund fail> listed more than once"}`,
+		},
+	}
+
+	for _, step := range steps {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(step.method, step.path, bytes.NewBufferString(step.body))
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, step.expectedStatusCode, w.Code, step.name)
+		if step.expectedResponseBody != "" {
+			assert.JSONEq(t, step.expectedResponseBody, w.Body.String(), step.name)
+		}
+	}
+}
+
+func TestAdminCardsLoadedFromFile(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := repository.NewCreditCardFileChecker()
+	// The file doesn't check the card numbers, 4000000000000500 fails the Luhn check
+	err := ccfc.Load([]byte(`creditCards: {4000000000000500: {operation: "void fail", reason: "stolen_card"}}`))
+	require.NoError(t, err)
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at, api.WithCardFailuresStore(ccfc))
+	router := server.Router
+
+	// Steps run in order, each one building on the previous ones
+	steps := []struct {
+		name                 string
+		method               string
+		path                 string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		{
+			name:               "get",
+			method:             "GET",
+			path:               "/admin/v1/cards/4000000000000500",
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"number": 4000000000000500, "failures": [
+				{"operation": "void", "reason": "stolen_card", "message": "Stolen card"}]}`,
+		},
+		{
+			name:               "delete",
+			method:             "DELETE",
+			path:               "/admin/v1/cards/4000000000000500",
+			expectedStatusCode: 204,
+		},
+		{
+			name:                 "get deleted card",
+			method:               "GET",
+			path:                 "/admin/v1/cards/4000000000000500",
+			expectedStatusCode:   404,
+			expectedResponseBody: `{"message": "card not found"}`,
+		},
+		{
+			name:                 "not a card number",
+			method:               "GET",
+			path:                 "/admin/v1/cards/-4000000000000500",
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid card number"}`,
+		},
+	}
+
+	for _, step := range steps {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(step.method, step.path, nil)
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, step.expectedStatusCode, w.Code, step.name)
+		if step.expectedResponseBody != "" {
+			assert.JSONEq(t, step.expectedResponseBody, w.Body.String(), step.name)
+		}
+	}
+}
+
+func TestAdminCardsDisabled(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/admin/v1/cards", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, 404, w.Code)
+}
@@ -1,7 +1,13 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
 	"github.com/gin-gonic/gin"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/api/middleware"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
 )
 
 // NoRoute provides a generic handler for unmatched routes.
@@ -14,6 +20,118 @@ func RespondWithError(c *gin.Context, httpCode int, message string) {
 	c.JSON(httpCode, gin.H{"message": message})
 }
 
+// respondAuthorisationNotFound reports that the authorisation ID is unknown.
+func (s *Server) respondAuthorisationNotFound(c *gin.Context) {
+	c.JSON(s.notFoundHTTPStatus, gin.H{"code": core.ResultCode_AuthorisationNotFound})
+}
+
+// getTransaction returns the transaction associated with the authorisation, responding with
+// the configured not found status if it's unknown, or with a 500 if it can't be read.
+// It returns false if the handler must not carry on, the response being already sent.
+func (s *Server) getTransaction(c *gin.Context, uid string) (tx core.Transaction, ok bool) {
+	tx, ok, err := s.Authoriser.GetTransaction(uid)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("error reading transaction: %s", err.Error()))
+		RespondWithError(c, 500, "internal error")
+		return core.Transaction{}, false
+	}
+	if !ok {
+		s.respondAuthorisationNotFound(c)
+		return core.Transaction{}, false
+	}
+	return tx, true
+}
+
+// notify notifies the notifier, if any, of the last operation that changed the transaction.
+func (s *Server) notify(uid string, tx core.Transaction) {
+	if s.notifier == nil || len(tx.Events) == 0 {
+		return
+	}
+	s.notifier.Notify(uid, tx, tx.Events[len(tx.Events)-1])
+}
+
+// decline holds the details reported along with ResultCode_Fail, when an operation is declined for the credit card.
+type decline struct {
+	DeclineCode    core.DeclineReason `json:"decline_code,omitempty"`
+	DeclineMessage string             `json:"decline_message,omitempty"`
+}
+
+// newDecline returns the details reported for the decline reason.
+func newDecline(reason core.DeclineReason) decline {
+	return decline{DeclineCode: reason, DeclineMessage: reason.Message()}
+}
+
+// evaluateOperation waits for the delay set by the outcome of the operation, and responds with an error if the outcome
+// is a simulated processor error or a fault without a body. Other faults are injected into the response.
+// It returns false if the handler must not carry on, the response being already sent or the client gone.
+func (s *Server) evaluateOperation(c *gin.Context, request core.OperationRequest) (outcome core.Outcome, ok bool) {
+	request.Headers = c.Request.Header
+	outcome, _ = s.Repo.Evaluate(request)
+
+	if !middleware.Wait(c, s.responseDelay(outcome)) {
+		s.Logger.Info(fmt.Sprintf("request cancelled while delaying %s: %s", request.Operation.Name(), c.Request.Context().Err()))
+		return core.Outcome{}, false
+	}
+
+	switch {
+	case outcome.Action == core.OutcomeAction_Error:
+		RespondWithError(c, outcome.HTTPStatus, "simulated processor error")
+		return core.Outcome{}, false
+	case outcome.Action == core.OutcomeAction_Fault && outcome.Fault == core.Fault_NoBody:
+		c.AbortWithStatus(outcome.HTTPStatus)
+		return core.Outcome{}, false
+	case outcome.Action == core.OutcomeAction_Fault:
+		// The operation is processed, and its response misbehaves
+		middleware.InjectFault(c, outcome.Fault)
+	}
+
+	return outcome, true
+}
+
+// errAmountNotPositive is returned when the amount of an operation is zero or negative.
+var errAmountNotPositive = errors.New("amount must be greater than zero")
+
+// parseAmount parses the amount of an operation in the provided currency.
+func parseAmount(amount json.Number, currency string) (core.Money, error) {
+	money, err := core.ParseMoney(amount.String(), currency)
+	if err != nil {
+		return core.Money{}, err
+	}
+	if !money.IsPositive() {
+		return core.Money{}, errAmountNotPositive
+	}
+	return money, nil
+}
+
+// cardCVV is the CVV of a card in a request. It's expected as a string, so leading zeros aren't lost,
+// and accepted as a number too for older clients, in which case leading zeros are lost.
+type cardCVV string
+
+// UnmarshalJSON unmarshals a CVV sent either as a string or as a number.
+func (cvv *cardCVV) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*cvv = cardCVV(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return errors.New("cvv must be a string or a number")
+	}
+	*cvv = cardCVV(n.String())
+	return nil
+}
+
+// isCurrencyAccepted returns true if authorisations are accepted in the currency.
+func (s *Server) isCurrencyAccepted(code string) bool {
+	if s.acceptedCurrencies == nil {
+		return true
+	}
+	_, ok := s.acceptedCurrencies[code]
+	return ok
+}
+
 // Healthcheck checks health of the service.
 func (s *Server) Healthcheck(c *gin.Context) {
 	c.JSON(200, gin.H{
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// ListMagicAmounts reports the amounts triggering an outcome whatever the card, so callers can discover them.
+func (s *Server) ListMagicAmounts(c *gin.Context) {
+	type magicAmount struct {
+		Amount     string             `json:"amount,omitempty"`
+		EndsWith   string             `json:"ends_with,omitempty"`
+		Operations []string           `json:"operations"`
+		Currencies []string           `json:"currencies,omitempty"`
+		Outcome    string             `json:"outcome"`
+		Reason     core.DeclineReason `json:"reason,omitempty"`
+		Message    string             `json:"message,omitempty"`
+		HTTPStatus int                `json:"http_status,omitempty"`
+		Fault      string             `json:"fault,omitempty"`
+		Delay      string             `json:"delay,omitempty"`
+		Latency    string             `json:"latency,omitempty"`
+	}
+
+	responseBody := struct {
+		MagicAmounts []magicAmount `json:"magic_amounts"`
+	}{MagicAmounts: []magicAmount{}}
+
+	lister, ok := s.Repo.(core.MagicAmountLister)
+	if ok {
+		for _, ma := range lister.MagicAmounts() {
+			item := magicAmount{
+				Amount:     ma.Amount,
+				EndsWith:   ma.EndsWith,
+				Currencies: ma.Currencies,
+				Outcome:    ma.Action.String(),
+				Reason:     ma.Reason,
+				HTTPStatus: ma.HTTPStatus,
+			}
+
+			// Magic amounts without operations apply to all the operations with an amount, except settlements
+			operations := ma.Operations
+			if len(operations) == 0 {
+				operations = []core.CCFailReason{core.CCFailReason_Authorise, core.CCFailReason_Capture, core.CCFailReason_Refund}
+			}
+			for _, operation := range operations {
+				item.Operations = append(item.Operations, operation.Name())
+			}
+
+			if ma.Reason != 0 {
+				item.Message = ma.Reason.Message()
+			}
+			if ma.Fault != 0 {
+				item.Fault = ma.Fault.String()
+			}
+			if ma.Delay > 0 {
+				item.Delay = ma.Delay.String()
+			}
+			if ma.Latency != nil {
+				item.Latency = ma.Latency.String()
+			}
+
+			responseBody.MagicAmounts = append(responseBody.MagicAmounts, item)
+		}
+	}
+
+	c.JSON(200, responseBody)
+}
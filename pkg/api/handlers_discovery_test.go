@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/api"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMagicAmounts(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`magicAmounts:
+  - amount: "100.51"
+    outcome: "error"
+    httpStatus: 503
+  - endsWith: ".05"
+    operations: ["capture", "refund"]
+    currencies: ["EUR"]
+    outcome: "decline"
+    reason: "insufficient_funds"
+    delay: "10ms"
+  - amount: "7.77"
+    operations: ["settle"]
+    outcome: "decline"
+    reason: "insufficient_funds"
+  - endsWith: ".99"
+    outcome: "fault"
+    fault: "invalid_json"
+    latency: {uniform: {min: "10ms", max: "20ms"}}`))
+	require.NoError(t, err)
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	uid1 := "53871001-f41a-4b87-9179-38d531bacece"
+	at.Set(uid1, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now(), time.Hour))
+	uid2 := "53871001-f41a-4b87-9179-38d531baaaaa"
+	at.Set(uid2, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(10051), time.Now(), time.Hour))
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	// Table driven testing
+	tests := map[string]struct {
+		method               string
+		path                 string
+		body                 string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		"discovery": {
+			method:             "GET",
+			path:               "/api/v1/magic-amounts",
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"magic_amounts": [
+				{"amount": "100.51", "operations": ["authorise", "capture", "refund"], "outcome": "error", "http_status": 503},
+				{"ends_with": ".05", "operations": ["capture", "refund"], "currencies": ["EUR"], "outcome": "decline",
+				 "reason": "insufficient_funds", "message": "Insufficient funds", "delay": "10ms"},
+				{"amount": "7.77", "operations": ["settle"], "outcome": "decline",
+				 "reason": "insufficient_funds", "message": "Insufficient funds"},
+				{"ends_with": ".99", "operations": ["authorise", "capture", "refund"], "outcome": "fault",
+				 "fault": "invalid_json", "latency": "uniform(10ms, 20ms)"}
+			]}`,
+		},
+		"authorise of an exact amount": {
+			method: "POST",
+			path:   "/api/v1/authorise",
+			body: `{"credit_card": {"name": "customer1", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+				`"currency": "EUR", "amount": 100.51}`,
+			expectedStatusCode:   503,
+			expectedResponseBody: `{"message": "simulated processor error"}`,
+		},
+		"capture of an amount ending with the magic digits": {
+			method:             "POST",
+			path:               "/api/v1/capture",
+			body:               `{"authorisation_id": "` + uid1 + `", "amount": 3.05}`,
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"code": 2, "remaining_balance": 10.50,
+				"decline_code": "insufficient_funds", "decline_message": "Insufficient funds"}`,
+		},
+		"void of an exact amount is not affected": {
+			method:               "POST",
+			path:                 "/api/v1/void",
+			body:                 `{"authorisation_id": "` + uid2 + `"}`,
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code": 1}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			assert.JSONEq(t, test.expectedResponseBody, w.Body.String())
+		})
+	}
+}
+
+func TestMagicAmountsEmpty(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/v1/magic-amounts", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"magic_amounts": []}`, w.Body.String())
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
@@ -11,14 +15,14 @@ import (
 func (s *Server) AuthoriseTransaction(c *gin.Context) {
 	requestBody := struct {
 		CreditCard struct {
-			Name        string `json:"name" binding:"required"`
-			Number      int64  `json:"number" binding:"required"`
-			ExpiryMonth int    `json:"expiry_month" binding:"required"`
-			ExpiryYear  int    `json:"expiry_year" binding:"required"`
-			CVV         int    `json:"cvv" binding:"required"`
+			Name        string  `json:"name" binding:"required"`
+			Number      int64   `json:"number" binding:"required"`
+			ExpiryMonth int     `json:"expiry_month" binding:"required,min=1,max=12"`
+			ExpiryYear  int     `json:"expiry_year" binding:"required"`
+			CVV         cardCVV `json:"cvv" binding:"required"`
 		} `json:"credit_card" binding:"required"`
-		Currency string  `json:"currency" binding:"required"`
-		Amount   float64 `json:"amount" binding:"required"`
+		Currency string      `json:"currency" binding:"required"`
+		Amount   json.Number `json:"amount" binding:"required"`
 	}{}
 
 	err := c.ShouldBindJSON(&requestBody)
@@ -28,17 +32,53 @@ func (s *Server) AuthoriseTransaction(c *gin.Context) {
 		return
 	}
 
+	if _, ok := core.LookupCurrency(requestBody.Currency); !ok {
+		s.Logger.Info(fmt.Sprintf("error parsing currency: unknown currency <%s>", requestBody.Currency))
+		RespondWithError(c, 400, "invalid currency: not an ISO 4217 currency code")
+		return
+	}
+
+	amount, err := parseAmount(requestBody.Amount, requestBody.Currency)
+	if err != nil {
+		s.Logger.Info(fmt.Sprintf("error parsing amount: %s", err.Error()))
+		RespondWithError(c, 400, fmt.Sprintf("invalid amount: %s", err.Error()))
+		return
+	}
+
 	responseBody := struct {
-		Code            uint   `json:"code"`
-		AuthorisationID string `json:"authorisation_id,omitempty"`
+		Code            core.ResultCode `json:"code"`
+		AuthorisationID string          `json:"authorisation_id,omitempty"`
+		CardBrand       core.CardBrand  `json:"card_brand"`
+		decline
 	}{}
+	responseBody.CardBrand = core.DetectCardBrand(requestBody.CreditCard.Number)
+
+	card := core.CreditCard{
+		Name:        requestBody.CreditCard.Name,
+		Number:      requestBody.CreditCard.Number,
+		ExpiryMonth: requestBody.CreditCard.ExpiryMonth,
+		ExpiryYear:  requestBody.CreditCard.ExpiryYear,
+		CVV:         string(requestBody.CreditCard.CVV),
+	}
 
 	// Check if we should fail
-	if ok := s.Repo.ShouldFail(requestBody.CreditCard.Number, core.CCFailReason_Authorise); ok {
-		responseBody.Code = 2
+	if err := card.Validate(time.Now()); err != nil {
+		responseBody.Code, _ = core.ResultCodeFromError(err)
+	} else if !s.isCurrencyAccepted(requestBody.Currency) {
+		responseBody.Code = core.ResultCode_CurrencyNotAccepted
+	} else if outcome, ok := s.evaluateOperation(c, core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: card, Amount: amount}); !ok {
+		return
+	} else if outcome.Action == core.OutcomeAction_Decline {
+		responseBody.Code = core.ResultCode_Fail
+		responseBody.decline = newDecline(outcome.Reason)
 	} else {
-		uid := s.Authoriser.Authorise(requestBody.CreditCard.Number)
-		responseBody.Code = 1
+		uid, err := s.Authoriser.Authorise(card, amount)
+		if err != nil {
+			s.Logger.Error(fmt.Sprintf("error storing authorisation: %s", err.Error()))
+			RespondWithError(c, 500, "internal error")
+			return
+		}
+		responseBody.Code = core.ResultCode_Success
 		responseBody.AuthorisationID = uid
 	}
 
@@ -48,8 +88,8 @@ func (s *Server) AuthoriseTransaction(c *gin.Context) {
 // CaptureTransaction handles capturing of transactions.
 func (s *Server) CaptureTransaction(c *gin.Context) {
 	requestBody := struct {
-		AuthorisationID string  `json:"authorisation_id" binding:"required"`
-		Amount          float64 `json:"amount" binding:"required"`
+		AuthorisationID string      `json:"authorisation_id" binding:"required"`
+		Amount          json.Number `json:"amount" binding:"required"`
 	}{}
 
 	err := c.ShouldBindJSON(&requestBody)
@@ -60,17 +100,62 @@ func (s *Server) CaptureTransaction(c *gin.Context) {
 	}
 
 	responseBody := struct {
-		Code uint `json:"code"`
+		Code             core.ResultCode `json:"code"`
+		RemainingBalance json.Number     `json:"remaining_balance"`
+		// State and SettleAt are only set when the capture is left pending settlement
+		State    core.TransactionState `json:"state,omitempty"`
+		SettleAt *time.Time            `json:"settle_at,omitempty"`
+		decline
 	}{}
 
-	responseBody.Code = 1
+	tx, ok := s.getTransaction(c, requestBody.AuthorisationID)
+	if !ok {
+		return
+	}
+
+	amount, err := parseAmount(requestBody.Amount, tx.Currency())
+	if err != nil {
+		s.Logger.Info(fmt.Sprintf("error parsing amount: %s", err.Error()))
+		RespondWithError(c, 400, fmt.Sprintf("invalid amount: %s", err.Error()))
+		return
+	}
 
 	// Check if we should fail
-	if ccNumber, ok := s.Authoriser.GetAssociatedCreditCard(requestBody.AuthorisationID); ok {
-		if ok := s.Repo.ShouldFail(ccNumber, core.CCFailReason_Capture); ok {
-			responseBody.Code = 2
+	outcome, ok := s.evaluateOperation(c, core.OperationRequest{Operation: core.CCFailReason_Capture, Card: tx.Card(), Amount: amount})
+	if !ok {
+		return
+	}
+	if outcome.Action == core.OutcomeAction_Decline {
+		responseBody.Code = core.ResultCode_Fail
+		responseBody.decline = newDecline(outcome.Reason)
+	} else {
+		if s.settler != nil {
+			settleAt := s.settlementSchedule.SettleAt(time.Now())
+			tx, err = s.settler.CaptureForSettlement(requestBody.AuthorisationID, amount, settleAt)
+		} else {
+			tx, err = s.Authoriser.Capture(requestBody.AuthorisationID, amount)
+		}
+		if errors.Is(err, core.ErrAuthorisationNotFound) {
+			s.respondAuthorisationNotFound(c)
+			return
+		}
+		code, known := core.ResultCodeFromError(err)
+		if !known {
+			s.Logger.Error(fmt.Sprintf("error capturing transaction: %s", err.Error()))
+			RespondWithError(c, 500, "internal error")
+			return
+		}
+		responseBody.Code = code
+		if err == nil {
+			s.notify(requestBody.AuthorisationID, tx)
+		}
+		if err == nil && tx.State == core.TransactionState_PendingSettlement {
+			settleAt := tx.SettleAt
+			responseBody.State = tx.State
+			responseBody.SettleAt = &settleAt
 		}
 	}
+	responseBody.RemainingBalance = json.Number(tx.RemainingBalance().String())
 
 	c.JSON(200, responseBody)
 }
@@ -89,15 +174,41 @@ func (s *Server) VoidTransaction(c *gin.Context) {
 	}
 
 	responseBody := struct {
-		Code uint `json:"code"`
+		Code core.ResultCode `json:"code"`
+		decline
 	}{}
 
-	responseBody.Code = 1
+	tx, ok := s.getTransaction(c, requestBody.AuthorisationID)
+	if !ok {
+		return
+	}
 
 	// Check if we should fail
-	if ccNumber, ok := s.Authoriser.GetAssociatedCreditCard(requestBody.AuthorisationID); ok {
-		if ok := s.Repo.ShouldFail(ccNumber, core.CCFailReason_Void); ok {
-			responseBody.Code = 2
+	outcome, ok := s.evaluateOperation(c, core.OperationRequest{Operation: core.CCFailReason_Void, Card: tx.Card(), Amount: tx.AuthorisedAmount})
+	if !ok {
+		return
+	}
+	if outcome.Action == core.OutcomeAction_Decline {
+		responseBody.Code = core.ResultCode_Fail
+		responseBody.decline = newDecline(outcome.Reason)
+	} else {
+		err := s.Authoriser.Void(requestBody.AuthorisationID)
+		if errors.Is(err, core.ErrAuthorisationNotFound) {
+			s.respondAuthorisationNotFound(c)
+			return
+		}
+		code, known := core.ResultCodeFromError(err)
+		if !known {
+			s.Logger.Error(fmt.Sprintf("error voiding transaction: %s", err.Error()))
+			RespondWithError(c, 500, "internal error")
+			return
+		}
+		responseBody.Code = code
+		if err == nil {
+			// Void doesn't return the transaction, read it back to notify the state it was left in
+			if tx, ok, err := s.Authoriser.GetTransaction(requestBody.AuthorisationID); err == nil && ok {
+				s.notify(requestBody.AuthorisationID, tx)
+			}
 		}
 	}
 
@@ -107,8 +218,8 @@ func (s *Server) VoidTransaction(c *gin.Context) {
 // RefundTransaction handles refunding of transactions.
 func (s *Server) RefundTransaction(c *gin.Context) {
 	requestBody := struct {
-		AuthorisationID string  `json:"authorisation_id" binding:"required"`
-		Amount          float64 `json:"amount" binding:"required"`
+		AuthorisationID string      `json:"authorisation_id" binding:"required"`
+		Amount          json.Number `json:"amount" binding:"required"`
 	}{}
 
 	err := c.ShouldBindJSON(&requestBody)
@@ -119,17 +230,260 @@ func (s *Server) RefundTransaction(c *gin.Context) {
 	}
 
 	responseBody := struct {
-		Code uint `json:"code"`
+		Code             core.ResultCode `json:"code"`
+		RemainingBalance json.Number     `json:"remaining_balance"`
+		decline
 	}{}
 
-	responseBody.Code = 1
+	tx, ok := s.getTransaction(c, requestBody.AuthorisationID)
+	if !ok {
+		return
+	}
+
+	amount, err := parseAmount(requestBody.Amount, tx.Currency())
+	if err != nil {
+		s.Logger.Info(fmt.Sprintf("error parsing amount: %s", err.Error()))
+		RespondWithError(c, 400, fmt.Sprintf("invalid amount: %s", err.Error()))
+		return
+	}
 
 	// Check if we should fail
-	if ccNumber, ok := s.Authoriser.GetAssociatedCreditCard(requestBody.AuthorisationID); ok {
-		if ok := s.Repo.ShouldFail(ccNumber, core.CCFailReason_Refund); ok {
-			responseBody.Code = 2
+	outcome, ok := s.evaluateOperation(c, core.OperationRequest{Operation: core.CCFailReason_Refund, Card: tx.Card(), Amount: amount})
+	if !ok {
+		return
+	}
+	if outcome.Action == core.OutcomeAction_Decline {
+		responseBody.Code = core.ResultCode_Fail
+		responseBody.decline = newDecline(outcome.Reason)
+	} else {
+		tx, err = s.Authoriser.Refund(requestBody.AuthorisationID, amount)
+		if errors.Is(err, core.ErrAuthorisationNotFound) {
+			s.respondAuthorisationNotFound(c)
+			return
+		}
+		code, known := core.ResultCodeFromError(err)
+		if !known {
+			s.Logger.Error(fmt.Sprintf("error refunding transaction: %s", err.Error()))
+			RespondWithError(c, 500, "internal error")
+			return
+		}
+		responseBody.Code = code
+		if err == nil {
+			s.notify(requestBody.AuthorisationID, tx)
+		}
+	}
+	responseBody.RemainingBalance = json.Number(tx.RemainingBalance().String())
+
+	c.JSON(200, responseBody)
+}
+
+// GetTransaction reports what the processor holds for an authorisation: the card, with its number masked,
+// the amounts, the state and the operations that changed it.
+func (s *Server) GetTransaction(c *gin.Context) {
+	uid := c.Param("authorisation_id")
+	tx, ok := s.getTransaction(c, uid)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, newTransaction(core.ListedTransaction{UID: uid, Transaction: tx}))
+}
+
+// Listing limits, the number of transactions returned by default and at most.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// ListTransactions lists the transactions matching the filters in the query string, newest first.
+// Pages are linked by the cursor returned with each page.
+func (s *Server) ListTransactions(c *gin.Context) {
+	lister, ok := s.Authoriser.(core.TransactionLister)
+	if !ok {
+		RespondWithError(c, 501, "listing transactions is not supported by the storage")
+		return
+	}
+
+	query := struct {
+		States      []string `form:"state"`
+		CardLast4   string   `form:"card_last4"`
+		Currency    string   `form:"currency"`
+		MinAmount   string   `form:"min_amount"`
+		MaxAmount   string   `form:"max_amount"`
+		CreatedFrom string   `form:"created_from"`
+		CreatedTo   string   `form:"created_to"`
+		Limit       int      `form:"limit"`
+		Cursor      string   `form:"cursor"`
+	}{Limit: defaultListLimit}
+
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		s.Logger.Info(fmt.Sprintf("error parsing query: %s", err.Error()))
+		RespondWithError(c, 400, "error parsing query")
+		return
+	}
+
+	filter, err := parseTransactionFilter(query.States, query.CardLast4, query.Currency, query.MinAmount, query.MaxAmount,
+		query.CreatedFrom, query.CreatedTo)
+	if err != nil {
+		RespondWithError(c, 400, err.Error())
+		return
+	}
+
+	if query.Limit < 1 || query.Limit > maxListLimit {
+		RespondWithError(c, 400, fmt.Sprintf("invalid limit: must be between 1 and %d", maxListLimit))
+		return
+	}
+
+	var after *core.TransactionCursor
+	if query.Cursor != "" {
+		cursor, err := core.ParseTransactionCursor(query.Cursor)
+		if err != nil {
+			RespondWithError(c, 400, err.Error())
+			return
 		}
+		after = &cursor
+	}
+
+	page, next, err := lister.ListTransactions(filter, after, query.Limit)
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("error listing transactions: %s", err.Error()))
+		RespondWithError(c, 500, "internal error")
+		return
+	}
+
+	responseBody := struct {
+		Transactions []transaction `json:"transactions"`
+		NextCursor   string        `json:"next_cursor,omitempty"`
+	}{Transactions: make([]transaction, 0, len(page))}
+
+	for _, tx := range page {
+		responseBody.Transactions = append(responseBody.Transactions, newTransaction(tx))
+	}
+	if next != nil {
+		responseBody.NextCursor = next.String()
 	}
 
 	c.JSON(200, responseBody)
 }
+
+// parseTransactionFilter parses the filters of a transaction listing.
+// States can be repeated, or separated by commas. Times are RFC 3339 and amounts are in major units.
+func parseTransactionFilter(states []string, cardLast4, currency, minAmount, maxAmount, createdFrom, createdTo string) (
+	filter core.TransactionFilter, err error) {
+	for _, list := range states {
+		for _, item := range strings.Split(list, ",") {
+			var state core.TransactionState
+			if err := state.Load(strings.TrimSpace(item)); err != nil {
+				return core.TransactionFilter{}, fmt.Errorf("invalid state: %s", err.Error())
+			}
+			filter.States = append(filter.States, state)
+		}
+	}
+
+	if cardLast4 != "" {
+		if len(cardLast4) != 4 || strings.Trim(cardLast4, "0123456789") != "" {
+			return core.TransactionFilter{}, errors.New("invalid card_last4: must be 4 digits")
+		}
+		filter.CardLast4 = cardLast4
+	}
+
+	if currency != "" {
+		if _, ok := core.LookupCurrency(currency); !ok {
+			return core.TransactionFilter{}, errors.New("invalid currency: not an ISO 4217 currency code")
+		}
+		filter.Currency = currency
+	}
+
+	if minAmount != "" || maxAmount != "" {
+		amountRange, err := core.NewAmountRange(minAmount, maxAmount)
+		if err != nil {
+			return core.TransactionFilter{}, err
+		}
+		filter.AuthorisedAmount = &amountRange
+	}
+
+	if createdFrom != "" {
+		if filter.CreatedFrom, err = time.Parse(time.RFC3339, createdFrom); err != nil {
+			return core.TransactionFilter{}, fmt.Errorf("invalid created_from: expected an RFC 3339 time <%s>", createdFrom)
+		}
+	}
+	if createdTo != "" {
+		if filter.CreatedTo, err = time.Parse(time.RFC3339, createdTo); err != nil {
+			return core.TransactionFilter{}, fmt.Errorf("invalid created_to: expected an RFC 3339 time <%s>", createdTo)
+		}
+	}
+
+	return filter, nil
+}
+
+// transaction is a transaction as reported by the inspection endpoints, with the card number masked.
+type transaction struct {
+	AuthorisationID string `json:"authorisation_id"`
+	CreditCard      struct {
+		Name        string         `json:"name,omitempty"`
+		Number      string         `json:"number"`
+		ExpiryMonth int            `json:"expiry_month,omitempty"`
+		ExpiryYear  int            `json:"expiry_year,omitempty"`
+		CardBrand   core.CardBrand `json:"card_brand"`
+	} `json:"credit_card"`
+	Currency         string                `json:"currency"`
+	State            core.TransactionState `json:"state"`
+	AuthorisedAmount json.Number           `json:"authorised_amount"`
+	CapturedAmount   json.Number           `json:"captured_amount"`
+	RefundedAmount   json.Number           `json:"refunded_amount"`
+	RemainingBalance json.Number           `json:"remaining_balance"`
+	CreatedAt        time.Time             `json:"created_at"`
+	ExpiresAt        *time.Time            `json:"expires_at,omitempty"`
+	SettleAt         *time.Time            `json:"settle_at,omitempty"`
+	Events           []transactionEvent    `json:"events"`
+}
+
+// transactionEvent is an operation that changed a transaction, as reported by the inspection endpoints.
+type transactionEvent struct {
+	Type        core.TransactionEventType `json:"type"`
+	Amount      json.Number               `json:"amount"`
+	CreatedAt   time.Time                 `json:"created_at"`
+	DeclineCode core.DeclineReason        `json:"decline_code,omitempty"`
+}
+
+// newTransaction returns the transaction as reported by the inspection endpoints.
+func newTransaction(tx core.ListedTransaction) transaction {
+	result := transaction{
+		AuthorisationID:  tx.UID,
+		Currency:         tx.Currency(),
+		State:            tx.State,
+		AuthorisedAmount: json.Number(tx.AuthorisedAmount.String()),
+		CapturedAmount:   json.Number(tx.CapturedAmount.String()),
+		RefundedAmount:   json.Number(tx.RefundedAmount.String()),
+		RemainingBalance: json.Number(tx.RemainingBalance().String()),
+		CreatedAt:        tx.CreatedAt,
+		Events:           make([]transactionEvent, 0, len(tx.Events)),
+	}
+
+	result.CreditCard.Name = tx.CardholderName
+	result.CreditCard.Number = core.MaskCardNumber(tx.CCNumber)
+	result.CreditCard.ExpiryMonth = tx.ExpiryMonth
+	result.CreditCard.ExpiryYear = tx.ExpiryYear
+	result.CreditCard.CardBrand = core.DetectCardBrand(tx.CCNumber)
+
+	if !tx.ExpiresAt.IsZero() {
+		expiresAt := tx.ExpiresAt
+		result.ExpiresAt = &expiresAt
+	}
+	if !tx.SettleAt.IsZero() {
+		settleAt := tx.SettleAt
+		result.SettleAt = &settleAt
+	}
+
+	for _, event := range tx.Events {
+		result.Events = append(result.Events, transactionEvent{
+			Type:        event.Type,
+			Amount:      json.Number(event.Amount.String()),
+			CreatedAt:   event.CreatedAt,
+			DeclineCode: event.Reason,
+		})
+	}
+
+	return result
+}
@@ -2,15 +2,22 @@ package api_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/api"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/lifecycle"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -18,11 +25,11 @@ import (
 func TestAuthoriseTransaction(t *testing.T) {
 
 	type CreditCard struct {
-		Name        string `json:"name"`
-		Number      int64  `json:"number"`
-		ExpiryMonth int    `json:"expiry_month"`
-		ExpiryYear  int    `json:"expiry_year"`
-		CVV         int    `json:"cvv"`
+		Name        string      `json:"name"`
+		Number      int64       `json:"number"`
+		ExpiryMonth int         `json:"expiry_month"`
+		ExpiryYear  int         `json:"expiry_year"`
+		CVV         interface{} `json:"cvv"`
 	}
 
 	type RequestBody struct {
@@ -34,13 +41,15 @@ func TestAuthoriseTransaction(t *testing.T) {
 	type ResponseBody struct {
 		Code            uint   `json:"code"`
 		AuthorisationID string `json:"authorisation_id,omitempty"`
+		CardBrand       string `json:"card_brand"`
+		DeclineCode     string `json:"decline_code,omitempty"`
 	}
 
 	// Setup
 	assert := assert.New(t)
 	logger := log.NullLogger{}
 	ccfc := createCreditCardFileChecker()
-	at := repository.NewAuthoriserInMemoryTracker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
 	server := api.NewServer("", 9999, false, logger, ccfc, at)
 	router := server.Router
 
@@ -62,12 +71,13 @@ func TestAuthoriseTransaction(t *testing.T) {
 					Name:        "customer1",
 					Number:      1111222233334444,
 					ExpiryMonth: 10,
-					ExpiryYear:  2025,
+					ExpiryYear:  expiryYear,
 					CVV:         123},
 			},
 			expectedStatusCode: 200,
 			expectedResponseBody: ResponseBody{
-				Code: 1,
+				Code:      1,
+				CardBrand: "unknown",
 			},
 		},
 		"failed request": {
@@ -78,14 +88,196 @@ func TestAuthoriseTransaction(t *testing.T) {
 					Name:        "customer1",
 					Number:      4000000000000119,
 					ExpiryMonth: 10,
-					ExpiryYear:  2025,
+					ExpiryYear:  expiryYear,
+					CVV:         123},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:        2,
+				CardBrand:   "visa",
+				DeclineCode: "do_not_honour",
+			},
+		},
+		"amount too precise for currency": {
+			RequestBody: RequestBody{
+				Currency: "JPY",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         123},
+			},
+			expectedStatusCode: 400,
+		},
+		"invalid card number": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      4000000000000078,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         123},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 12,
+			},
+		},
+		"expired card": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  2020,
 					CVV:         123},
 			},
 			expectedStatusCode: 200,
 			expectedResponseBody: ResponseBody{
-				Code: 2,
+				Code: 13,
 			},
 		},
+		"invalid CVV": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         12345},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 14,
+			},
+		},
+		"short CVV": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         "12"},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 14,
+			},
+		},
+		"CVV with leading zero": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         "012"},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:      1,
+				CardBrand: "unknown",
+			},
+		},
+		"CVV neither a string nor a number": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         true},
+			},
+			expectedStatusCode: 400,
+		},
+		"amex 3 digit CVV": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      378282246310005,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         "123"},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:      14,
+				CardBrand: "amex",
+			},
+		},
+		"amex CVV": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      378282246310005,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         1234},
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:      1,
+				CardBrand: "amex",
+			},
+		},
+		"invalid expiry month": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 13,
+					ExpiryYear:  expiryYear,
+					CVV:         123},
+			},
+			expectedStatusCode: 400,
+		},
+		"unknown currency": {
+			RequestBody: RequestBody{
+				Currency: "EURO",
+				Amount:   10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         123},
+			},
+			expectedStatusCode: 400,
+		},
+		"negative amount": {
+			RequestBody: RequestBody{
+				Currency: "EUR",
+				Amount:   -10.50,
+				CreditCard: CreditCard{
+					Name:        "customer1",
+					Number:      1111222233334444,
+					ExpiryMonth: 10,
+					ExpiryYear:  expiryYear,
+					CVV:         123},
+			},
+			expectedStatusCode: 400,
+		},
 	}
 
 	for name, test := range tests {
@@ -105,6 +297,10 @@ func TestAuthoriseTransaction(t *testing.T) {
 				err = json.Unmarshal(w.Body.Bytes(), &response)
 				require.NoError(t, err)
 				assert.Equal(test.expectedResponseBody.Code, response.Code)
+				if test.expectedResponseBody.CardBrand != "" {
+					assert.Equal(test.expectedResponseBody.CardBrand, response.CardBrand)
+				}
+				assert.Equal(test.expectedResponseBody.DeclineCode, response.DeclineCode)
 			}
 		})
 	}
@@ -118,18 +314,29 @@ func TestCaptureTransaction(t *testing.T) {
 	}
 
 	type ResponseBody struct {
-		Code uint `json:"code"`
+		Code             uint    `json:"code"`
+		RemainingBalance float64 `json:"remaining_balance"`
+		DeclineCode      string  `json:"decline_code,omitempty"`
+		DeclineMessage   string  `json:"decline_message,omitempty"`
 	}
 
 	// Setup
 	assert := assert.New(t)
 	logger := log.NullLogger{}
 	ccfc := createCreditCardFileChecker()
-	at := repository.NewAuthoriserInMemoryTracker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
 	uid1 := "53871001-f41a-4b87-9179-38d531bacece"
-	at.Authorisations[uid1] = 4000000000000001
+	at.Set(uid1, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now(), time.Hour))
 	uid2 := "53871001-f41a-4b87-9179-38d531baaaaa"
-	at.Authorisations[uid2] = 4000000000000259
+	at.Set(uid2, core.NewTransaction(core.CreditCard{Number: 4000000000000259}, eur(1050), time.Now(), time.Hour))
+	uid3 := "53871001-f41a-4b87-9179-38d531bbbbbb"
+	at.Set(uid3, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Voided, AuthorisedAmount: eur(1050)})
+	uid4 := "53871001-f41a-4b87-9179-38d531bccccc"
+	at.Set(uid4, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Captured, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050)})
+	uid5 := "53871001-f41a-4b87-9179-38d531bddddd"
+	at.Set(uid5, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now(), time.Hour))
+	uid6 := "53871001-f41a-4b87-9179-38d531beeeee"
+	at.Set(uid6, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now().Add(-2*time.Hour), time.Hour))
 
 	server := api.NewServer("", 9999, false, logger, ccfc, at)
 	router := server.Router
@@ -151,7 +358,8 @@ func TestCaptureTransaction(t *testing.T) {
 			},
 			expectedStatusCode: 200,
 			expectedResponseBody: ResponseBody{
-				Code: 1,
+				Code:             1,
+				RemainingBalance: 10.50,
 			},
 		},
 		"failed request": {
@@ -161,7 +369,59 @@ func TestCaptureTransaction(t *testing.T) {
 			},
 			expectedStatusCode: 200,
 			expectedResponseBody: ResponseBody{
-				Code: 2,
+				Code:             2,
+				RemainingBalance: 10.50,
+				DeclineCode:      "insufficient_funds",
+				DeclineMessage:   "Insufficient funds",
+			},
+		},
+		"voided transaction": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid3,
+				Amount:          10.50,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 3,
+			},
+		},
+		"already captured transaction": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid4,
+				Amount:          10.50,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:             4,
+				RemainingBalance: 10.50,
+			},
+		},
+		"amount exceeds authorised amount": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid5,
+				Amount:          20,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:             7,
+				RemainingBalance: 10.50,
+			},
+		},
+		"amount too precise for currency": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid5,
+				Amount:          10.505,
+			},
+			expectedStatusCode: 400,
+		},
+		"expired authorisation": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid6,
+				Amount:          10.50,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 9,
 			},
 		},
 	}
@@ -194,18 +454,24 @@ func TestVoidTransaction(t *testing.T) {
 	}
 
 	type ResponseBody struct {
-		Code uint `json:"code"`
+		Code           uint   `json:"code"`
+		DeclineCode    string `json:"decline_code,omitempty"`
+		DeclineMessage string `json:"decline_message,omitempty"`
 	}
 
 	// Setup
 	assert := assert.New(t)
 	logger := log.NullLogger{}
 	ccfc := createCreditCardFileChecker()
-	at := repository.NewAuthoriserInMemoryTracker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
 	uid1 := "53871001-f41a-4b87-9179-38d531bacece"
-	at.Authorisations[uid1] = 4000000000000001
+	at.Set(uid1, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now(), time.Hour))
 	uid2 := "53871001-f41a-4b87-9179-38d531baaaaa"
-	at.Authorisations[uid2] = 4000000000000500
+	at.Set(uid2, core.NewTransaction(core.CreditCard{Number: 4000000000000500}, eur(1050), time.Now(), time.Hour))
+	uid3 := "53871001-f41a-4b87-9179-38d531bbbbbb"
+	at.Set(uid3, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Voided, AuthorisedAmount: eur(1050)})
+	uid4 := "53871001-f41a-4b87-9179-38d531bccccc"
+	at.Set(uid4, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Captured, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050)})
 
 	server := api.NewServer("", 9999, false, logger, ccfc, at)
 	router := server.Router
@@ -235,7 +501,27 @@ func TestVoidTransaction(t *testing.T) {
 			},
 			expectedStatusCode: 200,
 			expectedResponseBody: ResponseBody{
-				Code: 2,
+				Code:           2,
+				DeclineCode:    "stolen_card",
+				DeclineMessage: "Stolen card",
+			},
+		},
+		"already voided transaction": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid3,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 3,
+			},
+		},
+		"captured transaction": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid4,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 4,
 			},
 		},
 	}
@@ -269,18 +555,29 @@ func TestRefundTransaction(t *testing.T) {
 	}
 
 	type ResponseBody struct {
-		Code uint `json:"code"`
+		Code             uint    `json:"code"`
+		RemainingBalance float64 `json:"remaining_balance"`
+		DeclineCode      string  `json:"decline_code,omitempty"`
+		DeclineMessage   string  `json:"decline_message,omitempty"`
 	}
 
 	// Setup
 	assert := assert.New(t)
 	logger := log.NullLogger{}
 	ccfc := createCreditCardFileChecker()
-	at := repository.NewAuthoriserInMemoryTracker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
 	uid1 := "53871001-f41a-4b87-9179-38d531bacece"
-	at.Authorisations[uid1] = 4000000000000001
+	at.Set(uid1, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Captured, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050)})
 	uid2 := "53871001-f41a-4b87-9179-38d531baaaaa"
-	at.Authorisations[uid2] = 4000000000003238
+	at.Set(uid2, core.Transaction{CCNumber: 4000000000003238, State: core.TransactionState_Captured, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050)})
+	uid3 := "53871001-f41a-4b87-9179-38d531bbbbbb"
+	at.Set(uid3, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now(), time.Hour))
+	uid4 := "53871001-f41a-4b87-9179-38d531bccccc"
+	at.Set(uid4, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Refunded, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050), RefundedAmount: eur(1050)})
+	uid5 := "53871001-f41a-4b87-9179-38d531bddddd"
+	at.Set(uid5, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Captured, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050)})
+	uid6 := "53871001-f41a-4b87-9179-38d531beeeee"
+	at.Set(uid6, core.Transaction{CCNumber: 4000000000000001, State: core.TransactionState_Captured, AuthorisedAmount: eur(1050), CapturedAmount: eur(1050)})
 
 	server := api.NewServer("", 9999, false, logger, ccfc, at)
 	router := server.Router
@@ -312,7 +609,53 @@ func TestRefundTransaction(t *testing.T) {
 			},
 			expectedStatusCode: 200,
 			expectedResponseBody: ResponseBody{
-				Code: 2,
+				Code:             2,
+				RemainingBalance: 10.50,
+				DeclineCode:      "suspected_fraud",
+				DeclineMessage:   "Suspected fraud",
+			},
+		},
+		"transaction not captured": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid3,
+				Amount:          10.50,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:             5,
+				RemainingBalance: 10.50,
+			},
+		},
+		"transaction fully refunded": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid4,
+				Amount:          10.50,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code: 6,
+			},
+		},
+		"partial refund": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid6,
+				Amount:          4,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:             1,
+				RemainingBalance: 6.50,
+			},
+		},
+		"amount exceeds captured amount": {
+			RequestBody: RequestBody{
+				AuthorisationID: uid5,
+				Amount:          20,
+			},
+			expectedStatusCode: 200,
+			expectedResponseBody: ResponseBody{
+				Code:             8,
+				RemainingBalance: 10.50,
 			},
 		},
 	}
@@ -338,13 +681,802 @@ func TestRefundTransaction(t *testing.T) {
 	}
 }
 
+func TestAuthorisationNotFound(t *testing.T) {
+
+	type RequestBody struct {
+		AuthorisationID string  `json:"authorisation_id"`
+		Amount          float64 `json:"amount,omitempty"`
+	}
+
+	// Table driven testing
+	tests := map[string]struct {
+		method             string
+		path               string
+		RequestBody        RequestBody
+		options            []api.ServerOption
+		expectedStatusCode int
+	}{
+		"capture": {
+			path:               "/api/v1/capture",
+			RequestBody:        RequestBody{AuthorisationID: "unknown", Amount: 10.50},
+			expectedStatusCode: 200,
+		},
+		"void": {
+			path:               "/api/v1/void",
+			RequestBody:        RequestBody{AuthorisationID: "unknown"},
+			expectedStatusCode: 200,
+		},
+		"refund": {
+			path:               "/api/v1/refund",
+			RequestBody:        RequestBody{AuthorisationID: "unknown", Amount: 10.50},
+			expectedStatusCode: 200,
+		},
+		"capture with custom status": {
+			path:               "/api/v1/capture",
+			RequestBody:        RequestBody{AuthorisationID: "unknown", Amount: 10.50},
+			options:            []api.ServerOption{api.WithNotFoundHTTPStatus(404)},
+			expectedStatusCode: 404,
+		},
+		"void with custom status": {
+			path:               "/api/v1/void",
+			RequestBody:        RequestBody{AuthorisationID: "unknown"},
+			options:            []api.ServerOption{api.WithNotFoundHTTPStatus(404)},
+			expectedStatusCode: 404,
+		},
+		"refund with custom status": {
+			path:               "/api/v1/refund",
+			RequestBody:        RequestBody{AuthorisationID: "unknown", Amount: 10.50},
+			options:            []api.ServerOption{api.WithNotFoundHTTPStatus(404)},
+			expectedStatusCode: 404,
+		},
+		"transaction": {
+			method:             "GET",
+			path:               "/api/v1/transactions/unknown",
+			expectedStatusCode: 200,
+		},
+		"transaction with custom status": {
+			method:             "GET",
+			path:               "/api/v1/transactions/unknown",
+			options:            []api.ServerOption{api.WithNotFoundHTTPStatus(404)},
+			expectedStatusCode: 404,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			logger := log.NullLogger{}
+			ccfc := createCreditCardFileChecker()
+			at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+			server := api.NewServer("", 9999, false, logger, ccfc, at, test.options...)
+			router := server.Router
+
+			requestBodyBytes, err := json.Marshal(test.RequestBody)
+			require.NoError(t, err)
+
+			method := test.method
+			if method == "" {
+				method = "POST"
+			}
+
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest(method, test.path, bytes.NewBuffer(requestBodyBytes))
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			assert.JSONEq(t, `{"code": 10}`, w.Body.String())
+		})
+	}
+}
+
+// failingAuthoriser is an authoriser whose storage fails to read transactions.
+type failingAuthoriser struct {
+	*repository.AuthoriserInMemoryTracker
+}
+
+func (fa failingAuthoriser) GetTransaction(uid string) (tx core.Transaction, ok bool, err error) {
+	return core.Transaction{}, false, errors.New("storage failure")
+}
+
+func TestStorageFailure(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := failingAuthoriser{repository.NewAuthoriserInMemoryTracker(time.Hour)}
+	server := api.NewServer("", 9999, false, logger, ccfc, at, api.WithNotFoundHTTPStatus(404))
+	router := server.Router
+
+	uid := "53871001-f41a-4b87-9179-38d531bacece"
+	requests := map[string]struct {
+		method string
+		path   string
+		body   string
+	}{
+		"capture":     {method: "POST", path: "/api/v1/capture", body: `{"authorisation_id": "` + uid + `", "amount": 10}`},
+		"void":        {method: "POST", path: "/api/v1/void", body: `{"authorisation_id": "` + uid + `"}`},
+		"refund":      {method: "POST", path: "/api/v1/refund", body: `{"authorisation_id": "` + uid + `", "amount": 10}`},
+		"transaction": {method: "GET", path: "/api/v1/transactions/" + uid},
+	}
+
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest(request.method, request.path, bytes.NewBufferString(request.body))
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			// Not mistaken for an unknown authorisation
+			assert.Equal(t, 500, w.Code)
+		})
+	}
+}
+
+func TestGetTransaction(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	createdAt := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+	card := core.CreditCard{Name: "customer1", Number: 4000000000000119, ExpiryMonth: 10, ExpiryYear: 2030, CVV: "123"}
+	tx := core.NewTransaction(card, eur(1050), createdAt, time.Hour)
+	require.NoError(t, tx.Capture(eur(1000), createdAt.Add(time.Minute)))
+	require.NoError(t, tx.Refund(eur(400), createdAt.Add(2*time.Minute)))
+	uid := "53871001-f41a-4b87-9179-38d531bacece"
+	at.Set(uid, tx)
+
+	voided := core.NewTransaction(card, eur(1050), createdAt, 0)
+	require.NoError(t, voided.Void(createdAt.Add(time.Minute)))
+	voidedUID := "53871001-f41a-4b87-9179-38d531baaaaa"
+	at.Set(voidedUID, voided)
+
+	// Table driven testing
+	tests := map[string]struct {
+		path                 string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		"partially refunded": {
+			path:               "/api/v1/transactions/" + uid,
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"authorisation_id": "` + uid + `",
+				"credit_card": {"name": "customer1", "number": "400000******0119", "expiry_month": 10, "expiry_year": 2030, "card_brand": "visa"},
+				"currency": "EUR", "state": "partially refunded",
+				"authorised_amount": 10.50, "captured_amount": 10.00, "refunded_amount": 4.00, "remaining_balance": 6.00,
+				"created_at": "2030-03-01T12:00:00Z", "expires_at": "2030-03-01T13:00:00Z",
+				"events": [
+					{"type": "authorised", "amount": 10.50, "created_at": "2030-03-01T12:00:00Z"},
+					{"type": "captured", "amount": 10.00, "created_at": "2030-03-01T12:01:00Z"},
+					{"type": "refunded", "amount": 4.00, "created_at": "2030-03-01T12:02:00Z"}
+				]}`,
+		},
+		"voided without expiry": {
+			path:               "/api/v1/transactions/" + voidedUID,
+			expectedStatusCode: 200,
+			expectedResponseBody: `{"authorisation_id": "` + voidedUID + `",
+				"credit_card": {"name": "customer1", "number": "400000******0119", "expiry_month": 10, "expiry_year": 2030, "card_brand": "visa"},
+				"currency": "EUR", "state": "voided",
+				"authorised_amount": 10.50, "captured_amount": 0.00, "refunded_amount": 0.00, "remaining_balance": 0.00,
+				"created_at": "2030-03-01T12:00:00Z",
+				"events": [
+					{"type": "authorised", "amount": 10.50, "created_at": "2030-03-01T12:00:00Z"},
+					{"type": "voided", "amount": 10.50, "created_at": "2030-03-01T12:01:00Z"}
+				]}`,
+		},
+		"unknown authorisation": {
+			path:                 "/api/v1/transactions/unknown",
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code": 10}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", test.path, nil)
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			assert.JSONEq(t, test.expectedResponseBody, w.Body.String())
+		})
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(0)
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	createdAt := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+	card := core.CreditCard{Name: "customer1", Number: 4000000000000119, ExpiryMonth: 10, ExpiryYear: 2030}
+	otherCard := core.CreditCard{Name: "customer2", Number: 4000000000000259, ExpiryMonth: 10, ExpiryYear: 2030}
+
+	at.Set("uid-0", core.NewTransaction(card, eur(1050), createdAt, 0))
+	captured := core.NewTransaction(card, eur(2000), createdAt.Add(time.Minute), 0)
+	require.NoError(t, captured.Capture(eur(2000), createdAt.Add(2*time.Minute)))
+	at.Set("uid-1", captured)
+	at.Set("uid-2", core.NewTransaction(otherCard, core.Money{MinorUnits: 500, Currency: "GBP"}, createdAt.Add(3*time.Minute), 0))
+	at.Set("uid-3", core.NewTransaction(card, eur(700), createdAt.Add(4*time.Minute), 0))
+
+	// Table driven testing
+	tests := map[string]struct {
+		query              string
+		expectedStatusCode int
+		expectedUIDs       []string
+		expectedMessage    string
+	}{
+		"all":                   {query: "", expectedStatusCode: 200, expectedUIDs: []string{"uid-3", "uid-2", "uid-1", "uid-0"}},
+		"state":                 {query: "state=captured", expectedStatusCode: 200, expectedUIDs: []string{"uid-1"}},
+		"several states":        {query: "state=captured,voided&state=authorised", expectedStatusCode: 200, expectedUIDs: []string{"uid-3", "uid-2", "uid-1", "uid-0"}},
+		"card last 4":           {query: "card_last4=0259", expectedStatusCode: 200, expectedUIDs: []string{"uid-2"}},
+		"currency":              {query: "currency=EUR", expectedStatusCode: 200, expectedUIDs: []string{"uid-3", "uid-1", "uid-0"}},
+		"amount range":          {query: "min_amount=7&max_amount=10.50", expectedStatusCode: 200, expectedUIDs: []string{"uid-3", "uid-0"}},
+		"created window":        {query: "created_from=2030-03-01T12:01:00Z&created_to=2030-03-01T12:04:00Z", expectedStatusCode: 200, expectedUIDs: []string{"uid-2", "uid-1"}},
+		"card in the last hour": {query: "card_last4=0119&created_from=2030-03-01T12:00:30Z", expectedStatusCode: 200, expectedUIDs: []string{"uid-3", "uid-1"}},
+		"nothing matching":      {query: "currency=JPY", expectedStatusCode: 200, expectedUIDs: []string{}},
+		"unknown state":         {query: "state=lost", expectedStatusCode: 400, expectedMessage: "invalid state: unknown transaction state <lost>"},
+		"invalid card last 4":   {query: "card_last4=119", expectedStatusCode: 400, expectedMessage: "invalid card_last4: must be 4 digits"},
+		"unknown currency":      {query: "currency=XYZ", expectedStatusCode: 400, expectedMessage: "invalid currency: not an ISO 4217 currency code"},
+		"invalid amount":        {query: "min_amount=ten", expectedStatusCode: 400, expectedMessage: "invalid amount <ten>"},
+		"fraction amount":       {query: "max_amount=1/3", expectedStatusCode: 400, expectedMessage: "invalid amount <1/3>"},
+		"invalid time":          {query: "created_from=yesterday", expectedStatusCode: 400, expectedMessage: "invalid created_from: expected an RFC 3339 time <yesterday>"},
+		"limit too large":       {query: "limit=501", expectedStatusCode: 400, expectedMessage: "invalid limit: must be between 1 and 500"},
+		"invalid cursor":        {query: "cursor=abc", expectedStatusCode: 400, expectedMessage: "invalid cursor"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/v1/transactions?"+test.query, nil)
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			if test.expectedStatusCode != 200 {
+				assert.JSONEq(t, `{"message": "`+test.expectedMessage+`"}`, w.Body.String())
+				return
+			}
+
+			var responseBody struct {
+				Transactions []struct {
+					AuthorisationID string `json:"authorisation_id"`
+				} `json:"transactions"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+
+			uids := []string{}
+			for _, tx := range responseBody.Transactions {
+				uids = append(uids, tx.AuthorisationID)
+			}
+			assert.Equal(t, test.expectedUIDs, uids)
+		})
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var uids []string
+		pages := 0
+		query := "/api/v1/transactions?limit=2&currency=EUR"
+		for {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", query, nil)
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+			require.Equal(t, 200, w.Code)
+
+			var responseBody struct {
+				Transactions []struct {
+					AuthorisationID string `json:"authorisation_id"`
+					CreditCard      struct {
+						Number string `json:"number"`
+					} `json:"credit_card"`
+				} `json:"transactions"`
+				NextCursor string `json:"next_cursor"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+			pages++
+
+			for _, tx := range responseBody.Transactions {
+				assert.Equal(t, "400000******0119", tx.CreditCard.Number)
+				uids = append(uids, tx.AuthorisationID)
+			}
+			if responseBody.NextCursor == "" {
+				break
+			}
+			query = "/api/v1/transactions?limit=2&currency=EUR&cursor=" + responseBody.NextCursor
+		}
+		assert.Equal(t, 2, pages)
+		assert.Equal(t, []string{"uid-3", "uid-1", "uid-0"}, uids)
+	})
+}
+
+func TestAcceptedCurrencies(t *testing.T) {
+	// Table driven testing
+	tests := map[string]struct {
+		currency     string
+		options      []api.ServerOption
+		expectedCode core.ResultCode
+	}{
+		"all currencies accepted by default": {
+			currency:     "JPY",
+			expectedCode: core.ResultCode_Success,
+		},
+		"accepted currency": {
+			currency:     "GBP",
+			options:      []api.ServerOption{api.WithAcceptedCurrencies([]string{"EUR", "GBP"})},
+			expectedCode: core.ResultCode_Success,
+		},
+		"currency not accepted": {
+			currency:     "JPY",
+			options:      []api.ServerOption{api.WithAcceptedCurrencies([]string{"EUR", "GBP"})},
+			expectedCode: core.ResultCode_CurrencyNotAccepted,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Setup
+			logger := log.NullLogger{}
+			ccfc := createCreditCardFileChecker()
+			at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+			server := api.NewServer("", 9999, false, logger, ccfc, at, test.options...)
+			router := server.Router
+
+			body := `{"credit_card": {"name": "customer1", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+				`"currency": "` + test.currency + `", "amount": 1050}`
+
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/authorise", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, 200, w.Code)
+			var response struct {
+				Code core.ResultCode `json:"code"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, test.expectedCode, response.Code)
+		})
+	}
+}
+
+func TestRuleOutcomes(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`rules:
+  - name: "test cardholders declined"
+    match: {cardholderName: "^TEST "}
+    outcome: "decline"
+    reason: "restricted_card"
+  - name: "large GBP amounts unavailable"
+    match: {currencies: ["GBP"], amountRange: {min: "1000"}}
+    outcome: "error"
+    httpStatus: 503
+  - name: "slow mastercard voids"
+    match: {operations: ["void"], binPrefixes: ["51", "55"]}
+    outcome: "delay"
+    delay: "20ms"`))
+	require.NoError(t, err)
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	uid1 := "53871001-f41a-4b87-9179-38d531bacece"
+	at.Set(uid1, core.NewTransaction(core.CreditCard{Number: 5555555555554444}, eur(1050), time.Now(), time.Hour))
+	uid2 := "6f9bb9b4-d79f-4e74-9b4b-2f4f3ab1c71c"
+	at.Set(uid2, core.NewTransaction(core.CreditCard{Name: "TEST customer", Number: 5555555555554444}, eur(1050), time.Now(), time.Hour))
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	// Table driven testing
+	tests := map[string]struct {
+		path                 string
+		body                 string
+		expectedStatusCode   int
+		expectedResponseBody string
+		expectedMinDuration  time.Duration
+	}{
+		"authorise declined by cardholder name": {
+			path: "/api/v1/authorise",
+			body: `{"credit_card": {"name": "TEST customer", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+				`"currency": "EUR", "amount": 10.50}`,
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code": 2, "card_brand": "unknown", "decline_code": "restricted_card", "decline_message": "Restricted card"}`,
+		},
+		"authorise error by amount and currency": {
+			path: "/api/v1/authorise",
+			body: `{"credit_card": {"name": "customer1", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+				`"currency": "GBP", "amount": 1000}`,
+			expectedStatusCode:   503,
+			expectedResponseBody: `{"message": "simulated processor error"}`,
+		},
+		"void delayed by BIN prefix": {
+			path:                 "/api/v1/void",
+			body:                 `{"authorisation_id": "` + uid1 + `"}`,
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code": 1}`,
+			expectedMinDuration:  20 * time.Millisecond,
+		},
+		"capture declined by cardholder name of the authorisation": {
+			path:                 "/api/v1/capture",
+			body:                 `{"authorisation_id": "` + uid2 + `", "amount": 5}`,
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code": 2, "remaining_balance": 10.50, "decline_code": "restricted_card", "decline_message": "Restricted card"}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", test.path, bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+
+			start := time.Now()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			assert.JSONEq(t, test.expectedResponseBody, w.Body.String())
+			assert.GreaterOrEqual(t, int64(time.Since(start)), int64(test.expectedMinDuration))
+		})
+	}
+}
+
+func TestLatency(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`latency:
+  authorise: {uniform: {min: "20ms", max: "30ms"}}
+  void: "1s"
+rules:
+  - name: "slow card"
+    match: {cardNumbers: [4000000000000259]}
+    outcome: "delay"
+    latency: {percentiles: {p0: "20ms", p50: "25ms", p100: "30ms"}}`))
+	require.NoError(t, err)
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	uid1 := "53871001-f41a-4b87-9179-38d531bacece"
+	at.Set(uid1, core.NewTransaction(core.CreditCard{Number: 4000000000000259}, eur(1050), time.Now(), time.Hour))
+	uid2 := "53871001-f41a-4b87-9179-38d531baaaaa"
+	at.Set(uid2, core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1050), time.Now(), time.Hour))
+	server := api.NewServer("", 9999, false, logger, ccfc, at, api.WithLatencyProvider(ccfc))
+	router := server.Router
+
+	// Table driven testing
+	tests := map[string]struct {
+		path                 string
+		body                 string
+		timeout              time.Duration
+		expectedStatusCode   int
+		expectedResponseBody string
+		expectedMinDuration  time.Duration
+	}{
+		"endpoint latency": {
+			path: "/api/v1/authorise",
+			body: `{"credit_card": {"name": "customer1", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+				`"currency": "EUR", "amount": 10.50}`,
+			expectedStatusCode:  200,
+			expectedMinDuration: 20 * time.Millisecond,
+		},
+		"card latency": {
+			path:                 "/api/v1/capture",
+			body:                 `{"authorisation_id": "` + uid1 + `", "amount": 5}`,
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code": 1, "remaining_balance": 5.00}`,
+			expectedMinDuration:  20 * time.Millisecond,
+		},
+		"deadline exceeded while waiting": {
+			path:                 "/api/v1/void",
+			body:                 `{"authorisation_id": "` + uid2 + `"}`,
+			timeout:              20 * time.Millisecond,
+			expectedStatusCode:   504,
+			expectedResponseBody: `{"message": "timed out before responding"}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", test.path, bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+			if test.timeout > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), test.timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+
+			start := time.Now()
+			router.ServeHTTP(w, req)
+			elapsed := time.Since(start)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			if test.expectedResponseBody != "" {
+				assert.JSONEq(t, test.expectedResponseBody, w.Body.String())
+			}
+			assert.GreaterOrEqual(t, int64(elapsed), int64(test.expectedMinDuration))
+			assert.Less(t, int64(elapsed), int64(time.Second))
+		})
+	}
+
+	// The authorisation is left untouched when the deadline is exceeded
+	tx, ok, err := at.GetTransaction(uid2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, core.TransactionState_Authorised, tx.State)
+}
+
+func TestFaults(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`rules:
+  - name: "no body"
+    match: {headers: {X-Scenario: "^no-body$"}}
+    outcome: "fault"
+    fault: "no_body"
+    httpStatus: 502
+  - name: "truncated body"
+    match: {cardNumbers: [4000000000000259]}
+    outcome: "fault"
+    fault: "truncated_body"
+  - name: "invalid json"
+    match: {amountRange: {min: "100", max: "100"}}
+    outcome: "fault"
+    fault: "invalid_json"
+  - name: "connection reset"
+    match: {headers: {X-Scenario: "^reset$"}}
+    outcome: "fault"
+    fault: "connection_reset"`))
+	require.NoError(t, err)
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at)
+	router := server.Router
+
+	authorise := func(number int64, amount string) string {
+		return `{"credit_card": {"name": "customer1", "number": ` + strconv.FormatInt(number, 10) + `, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, ` +
+			`"currency": "EUR", "amount": ` + amount + `}`
+	}
+
+	// Table driven testing
+	tests := map[string]struct {
+		body                 string
+		scenario             string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		"no body": {
+			body:                 authorise(1111222233334444, "10.50"),
+			scenario:             "no-body",
+			expectedStatusCode:   502,
+			expectedResponseBody: "",
+		},
+		"truncated body": {
+			body:                 authorise(4000000000000259, "10.50"),
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code":1,"authorisation_id":"`,
+		},
+		"invalid json": {
+			body:                 authorise(1111222233334444, "100"),
+			expectedStatusCode:   200,
+			expectedResponseBody: `{code:1,authorisation_id:`,
+		},
+		"other scenario": {
+			body:                 authorise(1111222233334444, "10.50"),
+			scenario:             "something else",
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"code":1,"authorisation_id":"`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/authorise", bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+			req.Header.Set("X-Scenario", test.scenario)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			assert.True(t, strings.HasPrefix(w.Body.String(), test.expectedResponseBody), w.Body.String())
+			assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+			if test.expectedResponseBody == "" {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("connection reset", func(t *testing.T) {
+		ts := httptest.NewServer(router)
+		defer ts.Close()
+
+		req, err := http.NewRequest("POST", ts.URL+"/api/v1/authorise", bytes.NewBufferString(authorise(1111222233334444, "10.50")))
+		require.NoError(t, err)
+		req.Header.Set("X-Scenario", "reset")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, 200, resp.StatusCode)
+		_, err = ioutil.ReadAll(resp.Body)
+		require.Error(t, err)
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+
+	type ResponseBody struct {
+		Code            uint   `json:"code"`
+		AuthorisationID string `json:"authorisation_id,omitempty"`
+		CardBrand       string `json:"card_brand"`
+	}
+
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	store := repository.NewIdempotencyInMemoryStore(time.Hour)
+	server := api.NewServer("", 9999, false, logger, ccfc, at, api.WithIdempotencyStore(store))
+	router := server.Router
+
+	authorise := func(idempotencyKey string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/authorise", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		if idempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"credit_card": {"name": "customer1", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, "currency": "EUR", "amount": 10.50}`
+	otherBody := `{"credit_card": {"name": "customer1", "number": 1111222233334444, "expiry_month": 10, "expiry_year": ` + strconv.Itoa(expiryYear) + `, "cvv": 123}, "currency": "EUR", "amount": 20}`
+
+	// First request is handled
+	w := authorise("key1", body)
+	require.Equal(t, 200, w.Code)
+	var first ResponseBody
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	require.Equal(t, uint(1), first.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	// Retry is replayed with the same authorisation ID
+	w = authorise("key1", body)
+	require.Equal(t, 200, w.Code)
+	var retry ResponseBody
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &retry))
+	assert.Equal(t, first, retry)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+	// Same key with a different body is rejected
+	w = authorise("key1", otherBody)
+	assert.Equal(t, 409, w.Code)
+
+	// Different keys and no key at all mint new authorisations
+	for _, key := range []string{"key2", ""} {
+		w = authorise(key, body)
+		require.Equal(t, 200, w.Code)
+		var other ResponseBody
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &other))
+		assert.NotEqual(t, first.AuthorisationID, other.AuthorisationID)
+	}
+}
+
+func TestSettlement(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	ccfc.CreditCards[4000000000005126] = core.CardFailures{{Operation: core.CCFailReason_Settle, Reason: core.DeclineReason_InsufficientFunds}}
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	scheduler := lifecycle.NewSettlementScheduler(logger, at, ccfc, nil, 10*time.Millisecond)
+	scheduler.Start()
+	defer scheduler.ShutDown(context.Background())
+	server := api.NewServer("", 9999, false, logger, ccfc, at,
+		api.WithSettlement(at, core.SettlementSchedule{Delay: 50 * time.Millisecond}))
+	router := server.Router
+
+	settledUID := "53871001-f41a-4b87-9179-38d531bacece"
+	at.Set(settledUID, core.NewTransaction(core.CreditCard{Number: 1111222233334444}, eur(1050), time.Now(), time.Hour))
+	failedUID := "53871001-f41a-4b87-9179-38d531baaaaa"
+	at.Set(failedUID, core.NewTransaction(core.CreditCard{Number: 4000000000005126}, eur(1050), time.Now(), time.Hour))
+
+	post := func(path string, body string) (code core.ResultCode, state core.TransactionState, settleAt *time.Time) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code, path)
+
+		var responseBody struct {
+			Code     core.ResultCode       `json:"code"`
+			State    core.TransactionState `json:"state"`
+			SettleAt *time.Time            `json:"settle_at"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+		return responseBody.Code, responseBody.State, responseBody.SettleAt
+	}
+
+	get := func(uid string) (state core.TransactionState, events []map[string]interface{}) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/transactions/"+uid, nil)
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code)
+
+		var responseBody struct {
+			State  core.TransactionState    `json:"state"`
+			Events []map[string]interface{} `json:"events"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+		return responseBody.State, responseBody.Events
+	}
+
+	// Captures are pending settlement until the scheduler settles them
+	code, state, settleAt := post("/api/v1/capture", `{"authorisation_id": "`+settledUID+`", "amount": 10}`)
+	require.Equal(t, core.ResultCode_Success, code)
+	assert.Equal(t, core.TransactionState_PendingSettlement, state)
+	require.NotNil(t, settleAt)
+	code, state, _ = post("/api/v1/capture", `{"authorisation_id": "`+failedUID+`", "amount": 10}`)
+	require.Equal(t, core.ResultCode_Success, code)
+	assert.Equal(t, core.TransactionState_PendingSettlement, state)
+
+	code, _, _ = post("/api/v1/refund", `{"authorisation_id": "`+settledUID+`", "amount": 4}`)
+	assert.Equal(t, core.ResultCode_TransactionNotSettled, code)
+
+	require.Eventually(t, func() bool {
+		settledState, _ := get(settledUID)
+		failedState, _ := get(failedUID)
+		return settledState == core.TransactionState_Captured && failedState == core.TransactionState_SettlementFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	code, _, _ = post("/api/v1/refund", `{"authorisation_id": "`+settledUID+`", "amount": 4}`)
+	assert.Equal(t, core.ResultCode_Success, code)
+	code, _, _ = post("/api/v1/refund", `{"authorisation_id": "`+failedUID+`", "amount": 4}`)
+	assert.Equal(t, core.ResultCode_SettlementFailed, code)
+
+	_, events := get(failedUID)
+	require.Len(t, events, 3)
+	assert.Equal(t, "settlement_failed", events[2]["type"])
+	assert.Equal(t, "insufficient_funds", events[2]["decline_code"])
+
+	// Failed settlements are filtered on and reported by the name of their state
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/v1/transactions?state=settlement_failed", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	var responseBody struct {
+		Transactions []struct {
+			AuthorisationID string `json:"authorisation_id"`
+			State           string `json:"state"`
+		} `json:"transactions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+	require.Len(t, responseBody.Transactions, 1)
+	assert.Equal(t, failedUID, responseBody.Transactions[0].AuthorisationID)
+	assert.Equal(t, "settlement_failed", responseBody.Transactions[0].State)
+}
+
 func createCreditCardFileChecker() *repository.CreditCardFileChecker {
 	ccfc := repository.NewCreditCardFileChecker()
 
-	ccfc.CreditCards[4000000000000119] = core.CCFailReason_Authorise
-	ccfc.CreditCards[4000000000000259] = core.CCFailReason_Capture
-	ccfc.CreditCards[4000000000000500] = core.CCFailReason_Void
-	ccfc.CreditCards[4000000000003238] = core.CCFailReason_Refund
+	ccfc.CreditCards[4000000000000119] = core.CardFailures{{Operation: core.CCFailReason_Authorise, Reason: core.DeclineReason_DoNotHonour}}
+	ccfc.CreditCards[4000000000000259] = core.CardFailures{{Operation: core.CCFailReason_Capture, Reason: core.DeclineReason_InsufficientFunds}}
+	ccfc.CreditCards[4000000000000500] = core.CardFailures{{Operation: core.CCFailReason_Void, Reason: core.DeclineReason_StolenCard}}
+	ccfc.CreditCards[4000000000003238] = core.CardFailures{{Operation: core.CCFailReason_Refund, Reason: core.DeclineReason_SuspectedFraud}}
 
 	return ccfc
 }
+
+// expiryYear is the expiry year of the cards authorised by the tests, far enough ahead for them never to expire.
+var expiryYear = time.Now().Year() + 5
+
+// eur returns an amount of euros in cents.
+func eur(cents int64) core.Money {
+	return core.Money{MinorUnits: cents, Currency: "EUR"}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// webhookDelivery is a webhook delivery as reported by the delivery log.
+type webhookDelivery struct {
+	ID            string                     `json:"id"`
+	EventID       string                     `json:"event_id"`
+	EventType     string                     `json:"event_type"`
+	URL           string                     `json:"url"`
+	Status        core.WebhookDeliveryStatus `json:"status"`
+	Attempts      []core.WebhookAttempt      `json:"attempts"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	NextAttemptAt *time.Time                 `json:"next_attempt_at,omitempty"`
+	Payload       json.RawMessage            `json:"payload"`
+}
+
+// ListWebhookDeliveries lists the webhook deliveries, newest first, optionally filtered by status.
+func (s *Server) ListWebhookDeliveries(c *gin.Context) {
+	lister, ok := s.notifier.(core.WebhookDeliveryLister)
+	if !ok {
+		RespondWithError(c, 501, "webhooks are not enabled")
+		return
+	}
+
+	query := struct {
+		Status string `form:"status"`
+		Limit  int    `form:"limit"`
+	}{Limit: defaultListLimit}
+
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		s.Logger.Info(fmt.Sprintf("error parsing query: %s", err.Error()))
+		RespondWithError(c, 400, "error parsing query")
+		return
+	}
+
+	var status core.WebhookDeliveryStatus
+	if query.Status != "" {
+		if err := status.Load(query.Status); err != nil {
+			RespondWithError(c, 400, fmt.Sprintf("invalid status <%s>", query.Status))
+			return
+		}
+	}
+
+	if query.Limit < 1 || query.Limit > maxListLimit {
+		RespondWithError(c, 400, fmt.Sprintf("invalid limit: must be between 1 and %d", maxListLimit))
+		return
+	}
+
+	deliveries, err := lister.ListDeliveries()
+	if err != nil {
+		s.Logger.Error(fmt.Sprintf("error listing webhook deliveries: %s", err.Error()))
+		RespondWithError(c, 500, "internal error")
+		return
+	}
+
+	responseBody := struct {
+		Deliveries []webhookDelivery `json:"deliveries"`
+	}{Deliveries: []webhookDelivery{}}
+
+	for _, delivery := range deliveries {
+		if status != 0 && delivery.Status != status {
+			continue
+		}
+		if len(responseBody.Deliveries) == query.Limit {
+			break
+		}
+		responseBody.Deliveries = append(responseBody.Deliveries, newWebhookDelivery(delivery))
+	}
+
+	c.JSON(200, responseBody)
+}
+
+// newWebhookDelivery returns the webhook delivery as reported by the delivery log.
+func newWebhookDelivery(delivery core.WebhookDelivery) webhookDelivery {
+	result := webhookDelivery{
+		ID:        delivery.ID,
+		EventID:   delivery.EventID,
+		EventType: delivery.EventType,
+		URL:       delivery.URL,
+		Status:    delivery.Status,
+		Attempts:  delivery.Attempts,
+		CreatedAt: delivery.CreatedAt,
+		Payload:   delivery.Payload,
+	}
+	if result.Attempts == nil {
+		result.Attempts = []core.WebhookAttempt{}
+	}
+	if delivery.Status == core.WebhookDeliveryStatus_Pending {
+		nextAttemptAt := delivery.NextAttemptAt
+		result.NextAttemptAt = &nextAttemptAt
+	}
+	return result
+}
@@ -0,0 +1,266 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/api"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/lifecycle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receivedWebhook is a webhook as received by the test endpoint.
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhooks(t *testing.T) {
+	// Setup
+	// The endpoint fails the first request, so the first webhook is only delivered on the retry
+	var mu sync.Mutex
+	requests := 0
+	received := make(chan receivedWebhook, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		received <- receivedWebhook{header: r.Header, body: body}
+	}))
+	defer endpoint.Close()
+
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	dispatcher := lifecycle.NewWebhookDispatcher(logger, repository.NewWebhookInMemoryStore(time.Hour),
+		[]string{endpoint.URL}, "secret", 3, 10*time.Millisecond)
+	dispatcher.Start()
+	defer dispatcher.ShutDown(context.Background())
+	server := api.NewServer("", 9999, false, logger, ccfc, at, api.WithNotifier(dispatcher))
+	router := server.Router
+
+	card := core.CreditCard{Number: 1111222233334444}
+	capturedUID := "53871001-f41a-4b87-9179-38d531bacece"
+	at.Set(capturedUID, core.NewTransaction(card, eur(1050), time.Now(), time.Hour))
+	voidedUID := "53871001-f41a-4b87-9179-38d531baaaaa"
+	at.Set(voidedUID, core.NewTransaction(card, eur(1050), time.Now(), time.Hour))
+
+	requestBodies := []struct {
+		path string
+		body string
+	}{
+		{path: "/api/v1/capture", body: `{"authorisation_id": "` + capturedUID + `", "amount": 10}`},
+		// Declined operations don't change the transaction, so they aren't notified
+		{path: "/api/v1/capture", body: `{"authorisation_id": "` + capturedUID + `", "amount": 10}`},
+		{path: "/api/v1/refund", body: `{"authorisation_id": "` + capturedUID + `", "amount": 4}`},
+		{path: "/api/v1/void", body: `{"authorisation_id": "` + voidedUID + `"}`},
+	}
+
+	expectedWebhooks := []struct {
+		eventType string
+		data      string
+	}{
+		{
+			eventType: "transaction.captured",
+			data: `{"authorisation_id": "` + capturedUID + `", "state": "captured", "currency": "EUR", "amount": 10.00,
+				"authorised_amount": 10.50, "captured_amount": 10.00, "refunded_amount": 0.00, "remaining_balance": 10.00}`,
+		},
+		{
+			eventType: "transaction.refunded",
+			data: `{"authorisation_id": "` + capturedUID + `", "state": "partially refunded", "currency": "EUR", "amount": 4.00,
+				"authorised_amount": 10.50, "captured_amount": 10.00, "refunded_amount": 4.00, "remaining_balance": 6.00}`,
+		},
+		{
+			eventType: "transaction.voided",
+			data: `{"authorisation_id": "` + voidedUID + `", "state": "voided", "currency": "EUR", "amount": 10.50,
+				"authorised_amount": 10.50, "captured_amount": 0.00, "refunded_amount": 0.00, "remaining_balance": 0.00}`,
+		},
+	}
+
+	// Each webhook is waited for before the next operation, so they are received in order
+	webhooks := make([]receivedWebhook, 0, len(expectedWebhooks))
+	for _, request := range requestBodies {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", request.path, bytes.NewBufferString(request.body))
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code, request.path)
+
+		var responseBody struct {
+			Code core.ResultCode `json:"code"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+		if responseBody.Code != core.ResultCode_Success {
+			continue
+		}
+
+		select {
+		case webhook := <-received:
+			webhooks = append(webhooks, webhook)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "webhook not received", request.path)
+		}
+	}
+
+	require.Len(t, webhooks, len(expectedWebhooks))
+	for i, expected := range expectedWebhooks {
+		webhook := webhooks[i]
+
+		var payload struct {
+			ID        string          `json:"id"`
+			Type      string          `json:"type"`
+			CreatedAt time.Time       `json:"created_at"`
+			Data      json.RawMessage `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(webhook.body, &payload))
+		assert.Equal(t, expected.eventType, payload.Type)
+		assert.JSONEq(t, expected.data, string(payload.Data))
+		assert.Equal(t, payload.ID, webhook.header.Get(core.WebhookIDHeader))
+		assert.Equal(t, expected.eventType, webhook.header.Get(core.WebhookEventHeader))
+
+		var timestamp int64
+		var signature string
+		_, err := fmt.Sscanf(webhook.header.Get(core.WebhookSignatureHeader), "t=%d,v1=%s", &timestamp, &signature)
+		require.NoError(t, err)
+		assert.Equal(t, core.WebhookSignature([]byte("secret"), timestamp, webhook.body), signature)
+	}
+
+	// Delivery log, the last attempt being recorded right after the endpoint responds
+	var responseBody struct {
+		Deliveries []struct {
+			EventType string                `json:"event_type"`
+			URL       string                `json:"url"`
+			Status    string                `json:"status"`
+			Attempts  []core.WebhookAttempt `json:"attempts"`
+		} `json:"deliveries"`
+	}
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/webhooks/deliveries?status=delivered", nil)
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code)
+
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responseBody))
+		return len(responseBody.Deliveries) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	delivery := responseBody.Deliveries[2]
+	assert.Equal(t, "transaction.captured", delivery.EventType)
+	assert.Equal(t, endpoint.URL, delivery.URL)
+	assert.Equal(t, "delivered", delivery.Status)
+	require.Len(t, delivery.Attempts, 2)
+	assert.Equal(t, 500, delivery.Attempts[0].StatusCode)
+	assert.Equal(t, 200, delivery.Attempts[1].StatusCode)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	// Setup
+	logger := log.NullLogger{}
+	ccfc := createCreditCardFileChecker()
+	at := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	store := repository.NewWebhookInMemoryStore(time.Hour)
+	// The dispatcher isn't started, so deliveries stay pending
+	dispatcher := lifecycle.NewWebhookDispatcher(logger, store, []string{"http://localhost/webhooks"}, "secret", 3, time.Second)
+
+	createdAt := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+	pending, err := core.NewWebhookDelivery("delivery1", "http://localhost/webhooks", core.WebhookPayload{ID: "event1", Type: "transaction.voided"}, createdAt)
+	require.NoError(t, err)
+	failed, err := core.NewWebhookDelivery("delivery2", "http://localhost/webhooks", core.WebhookPayload{ID: "event2", Type: "transaction.captured"}, createdAt.Add(time.Second))
+	require.NoError(t, err)
+	failed.RecordAttempt(core.WebhookAttempt{At: createdAt.Add(2 * time.Second), Error: "connection refused"}, 1, time.Second)
+	require.NoError(t, store.Add([]core.WebhookDelivery{pending, failed}))
+
+	pendingBody := `{"id": "delivery1", "event_id": "event1", "event_type": "transaction.voided", "url": "http://localhost/webhooks",
+		"status": "pending", "attempts": [], "created_at": "2030-03-01T12:00:00Z", "next_attempt_at": "2030-03-01T12:00:00Z",
+		"payload": {"id": "event1", "type": "transaction.voided", "created_at": "0001-01-01T00:00:00Z", "data": {"authorisation_id": "",
+		"state": "", "currency": "", "amount": 0, "authorised_amount": 0, "captured_amount": 0, "refunded_amount": 0, "remaining_balance": 0}}}`
+	failedBody := `{"id": "delivery2", "event_id": "event2", "event_type": "transaction.captured", "url": "http://localhost/webhooks",
+		"status": "failed", "attempts": [{"at": "2030-03-01T12:00:02Z", "error": "connection refused"}], "created_at": "2030-03-01T12:00:01Z",
+		"payload": {"id": "event2", "type": "transaction.captured", "created_at": "0001-01-01T00:00:00Z", "data": {"authorisation_id": "",
+		"state": "", "currency": "", "amount": 0, "authorised_amount": 0, "captured_amount": 0, "refunded_amount": 0, "remaining_balance": 0}}}`
+
+	// Table driven testing
+	tests := map[string]struct {
+		notifier             core.Notifier
+		query                string
+		expectedStatusCode   int
+		expectedResponseBody string
+	}{
+		"all deliveries": {
+			notifier:             dispatcher,
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"deliveries": [` + failedBody + `, ` + pendingBody + `]}`,
+		},
+		"pending deliveries": {
+			notifier:             dispatcher,
+			query:                "?status=pending",
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"deliveries": [` + pendingBody + `]}`,
+		},
+		"limit": {
+			notifier:             dispatcher,
+			query:                "?limit=1",
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"deliveries": [` + failedBody + `]}`,
+		},
+		"no deliveries": {
+			notifier:             dispatcher,
+			query:                "?status=delivered",
+			expectedStatusCode:   200,
+			expectedResponseBody: `{"deliveries": []}`,
+		},
+		"invalid status": {
+			notifier:             dispatcher,
+			query:                "?status=lost",
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid status <lost>"}`,
+		},
+		"invalid limit": {
+			notifier:             dispatcher,
+			query:                "?limit=0",
+			expectedStatusCode:   400,
+			expectedResponseBody: `{"message": "invalid limit: must be between 1 and 500"}`,
+		},
+		"webhooks not enabled": {
+			expectedStatusCode:   501,
+			expectedResponseBody: `{"message": "webhooks are not enabled"}`,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := []api.ServerOption{}
+			if test.notifier != nil {
+				options = append(options, api.WithNotifier(test.notifier))
+			}
+			server := api.NewServer("", 9999, false, logger, ccfc, at, options...)
+			router := server.Router
+
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/api/v1/webhooks/deliveries"+test.query, nil)
+			require.NoError(t, err)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, test.expectedStatusCode, w.Code)
+			assert.JSONEq(t, test.expectedResponseBody, w.Body.String())
+		})
+	}
+}
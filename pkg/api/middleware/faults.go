@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// faultKey is the key of the gin.Context holding the fault injected into the response.
+const faultKey = "middleware.fault"
+
+// InjectFault sets the fault the response to the request misbehaves with.
+// It's applied by the FaultInjection middleware once the handler has responded.
+func InjectFault(c *gin.Context, fault core.Fault) {
+	c.Set(faultKey, fault)
+}
+
+// FaultInjection returns a gin.HandlerFunc (middleware) that makes the response misbehave at the transport level,
+// with the fault set by InjectFault, so the callers' defensive parsing is exercised.
+//
+// The response is held back until the handler completes:
+// core.Fault_TruncatedBody sends half of the body, core.Fault_InvalidJSON strips the quotes from the body,
+// and core.Fault_ConnectionReset resets the connection after sending half of the body.
+// Responses without a fault are sent as they are.
+func FaultInjection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := c.Writer
+		buffer := &bufferedWriter{ResponseWriter: writer, status: 200}
+		c.Writer = buffer
+
+		defer func() {
+			c.Writer = writer
+		}()
+
+		c.Next()
+
+		// Nothing to send if the client went away before a response was written
+		if !buffer.written {
+			return
+		}
+
+		body := buffer.body.Bytes()
+		fault, _ := c.Get(faultKey)
+
+		switch fault {
+		case core.Fault_TruncatedBody:
+			body = body[:len(body)/2]
+		case core.Fault_InvalidJSON:
+			body = bytes.ReplaceAll(body, []byte(`"`), nil)
+		case core.Fault_ConnectionReset:
+			// Announce the whole body, but only send half of it before resetting the connection
+			writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			writer.WriteHeader(buffer.status)
+			_, _ = writer.Write(body[:len(body)/2])
+			resetConnection(writer)
+			return
+		}
+
+		writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		writer.WriteHeader(buffer.status)
+		_, _ = writer.Write(body)
+	}
+}
+
+// resetConnection hijacks the connection and closes it abruptly, sending a TCP RST rather than a FIN.
+func resetConnection(writer gin.ResponseWriter) {
+	writer.Flush()
+	conn, _, err := writer.Hijack()
+	if err != nil {
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+// bufferedWriter holds back the response written by the handler.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+// WriteHeader holds back the status code, it can change until the body is written.
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow marks the response as written.
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+// Write holds back the data.
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+// WriteString holds back the string.
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+// Status returns the status code held back.
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+// Size returns the size of the body held back.
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+// Written returns true if a response has been written.
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+// Flush does nothing, the response is held back until the handler completes.
+func (w *bufferedWriter) Flush() {}
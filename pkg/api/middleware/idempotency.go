@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// IdempotencyKeyHeader is the request header carrying the idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is the response header set when a stored response is replayed.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// Idempotency returns a gin.HandlerFunc (middleware) that honours the Idempotency-Key header.
+//
+// The first response to a request carrying a key is stored and replayed for any retry of the same request,
+// i.e., same method, path and body. Using the same key for a different request, or while the first request
+// is still in progress, is rejected with a 409.
+// Responses with a 5xx status are not stored, so the request can be retried.
+// Requests without the header are handled as usual.
+func Idempotency(store core.IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"message": "error reading body"})
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		response, err := store.Begin(key, requestFingerprint(c, body))
+		if errors.Is(err, core.ErrIdempotencyKeyReused) || errors.Is(err, core.ErrIdempotencyKeyInProgress) {
+			c.AbortWithStatusJSON(409, gin.H{"message": err.Error()})
+			return
+		}
+
+		if response != nil {
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(response.StatusCode, response.ContentType, response.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		completed := false
+		defer func() {
+			// Release the key if the handler didn't complete, e.g. it panicked
+			if !completed {
+				store.Cancel(key)
+			}
+		}()
+
+		c.Next()
+
+		// Nothing to replay if the client went away before a response was written
+		if c.Writer.Status() >= 500 || !c.Writer.Written() {
+			return
+		}
+
+		store.Complete(key, core.IdempotentResponse{
+			StatusCode:  c.Writer.Status(),
+			ContentType: c.Writer.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		completed = true
+	}
+}
+
+// requestFingerprint identifies a request by its method, path and body.
+func requestFingerprint(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(c.Request.URL.Path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// bodyRecorder is a gin.ResponseWriter that keeps a copy of the response body.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes the data to the connection and keeps a copy.
+func (w *bodyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes the string to the connection and keeps a copy.
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
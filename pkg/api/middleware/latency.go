@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deadline returns a gin.HandlerFunc (middleware) that cancels the request context once the timeout elapses,
+// so waiting for injected latency stops in time for a response to be written.
+func Deadline(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// Latency returns a gin.HandlerFunc (middleware) that waits for the duration returned by latency
+// before handing the request over.
+func Latency(latency func() time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Wait(c, latency()) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// Wait waits for the duration, unless the request is cancelled first.
+// It returns false if the request has been aborted: with a 504 if the request deadline was exceeded,
+// or without a response if the client went away.
+func Wait(c *gin.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.Request.Context().Done():
+		if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+			c.AbortWithStatusJSON(504, gin.H{"message": "timed out before responding"})
+		} else {
+			c.Abort()
+		}
+		return false
+	}
+}
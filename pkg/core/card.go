@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned when the credit card details are not valid.
+var (
+	ErrInvalidCardNumber = errors.New("invalid card number")
+	ErrCardExpired       = errors.New("card has expired")
+	ErrInvalidCVV        = errors.New("invalid CVV")
+)
+
+// Card numbers (PANs) are between 12 and 19 digits long.
+const (
+	minCardNumberLength = 12
+	maxCardNumberLength = 19
+)
+
+// CreditCard holds the credit card details provided on authorisation.
+type CreditCard struct {
+	Name        string
+	Number      int64
+	ExpiryMonth int
+	ExpiryYear  int
+	// CVV is kept as a string, so leading zeros aren't lost.
+	CVV string
+}
+
+// Validate checks the card number against the Luhn algorithm, the expiry date against the current date,
+// and the CVV against the number of digits expected for the card brand.
+// Cards are valid until the end of their expiry month.
+func (cc CreditCard) Validate(now time.Time) error {
+	if !ValidCardNumber(cc.Number) {
+		return ErrInvalidCardNumber
+	}
+
+	year, month, _ := now.Date()
+	if cc.ExpiryYear < year || (cc.ExpiryYear == year && cc.ExpiryMonth < int(month)) {
+		return ErrCardExpired
+	}
+
+	if len(cc.CVV) != DetectCardBrand(cc.Number).CVVLength() || !isDigits(cc.CVV) {
+		return ErrInvalidCVV
+	}
+
+	return nil
+}
+
+// ValidCardNumber returns true if the card number has a valid length and check digit.
+func ValidCardNumber(number int64) bool {
+	if number <= 0 {
+		return false
+	}
+
+	digits := strconv.FormatInt(number, 10)
+	if len(digits) < minCardNumberLength || len(digits) > maxCardNumberLength {
+		return false
+	}
+
+	// Luhn algorithm: double every second digit starting from the right-most one (the check digit)
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+// MaskCardNumber returns the card number with all digits masked but the BIN (first 6) and the last 4,
+// e.g. "400000******0119", as allowed to be displayed by PCI DSS.
+func MaskCardNumber(number int64) string {
+	digits := strconv.FormatInt(number, 10)
+	if len(digits) < minCardNumberLength {
+		return strings.Repeat("*", len(digits))
+	}
+	return digits[:6] + strings.Repeat("*", len(digits)-10) + digits[len(digits)-4:]
+}
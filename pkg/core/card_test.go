@@ -0,0 +1,125 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidCardNumber(t *testing.T) {
+	tests := map[string]struct {
+		number         int64
+		expectedResult bool
+	}{
+		"visa":              {number: 4000000000000077, expectedResult: true},
+		"mastercard":        {number: 5555555555554444, expectedResult: true},
+		"amex":              {number: 378282246310005, expectedResult: true},
+		"min length":        {number: 400000000002, expectedResult: true},
+		"max length":        {number: 4000000000000000006, expectedResult: true},
+		"wrong check digit": {number: 4000000000000078, expectedResult: false},
+		"single digit typo": {number: 4000000000010077, expectedResult: false},
+		"transposed digits": {number: 4000000000000707, expectedResult: false},
+		"too short":         {number: 18, expectedResult: false},
+		"zero":              {number: 0, expectedResult: false},
+		"negative":          {number: -4000000000000077, expectedResult: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expectedResult, core.ValidCardNumber(test.number))
+		})
+	}
+}
+
+func TestMaskCardNumber(t *testing.T) {
+	tests := map[string]struct {
+		number         int64
+		expectedResult string
+	}{
+		"visa":       {number: 4000000000000119, expectedResult: "400000******0119"},
+		"amex":       {number: 378282246310005, expectedResult: "378282*****0005"},
+		"min length": {number: 400000000002, expectedResult: "400000**0002"},
+		"too short":  {number: 18, expectedResult: "**"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expectedResult, core.MaskCardNumber(test.number))
+		})
+	}
+}
+
+func TestCreditCardValidate(t *testing.T) {
+	now := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		card        core.CreditCard
+		expectedErr error
+	}{
+		"valid card": {
+			card: core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "123"},
+		},
+		"expires this month": {
+			card: core.CreditCard{Number: 4000000000000077, ExpiryMonth: 3, ExpiryYear: 2021, CVV: "123"},
+		},
+		"expired last month": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 2, ExpiryYear: 2021, CVV: "123"},
+			expectedErr: core.ErrCardExpired,
+		},
+		"expired last year": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 12, ExpiryYear: 2020, CVV: "123"},
+			expectedErr: core.ErrCardExpired,
+		},
+		"invalid card number": {
+			card:        core.CreditCard{Number: 4000000000000078, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "123"},
+			expectedErr: core.ErrInvalidCardNumber,
+		},
+		"4 digit CVV": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "1234"},
+			expectedErr: core.ErrInvalidCVV,
+		},
+		"5 digit CVV": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "12345"},
+			expectedErr: core.ErrInvalidCVV,
+		},
+		"2 digit CVV": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "12"},
+			expectedErr: core.ErrInvalidCVV,
+		},
+		"CVV with leading zero": {
+			card: core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "012"},
+		},
+		"CVV with non digits": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "1a3"},
+			expectedErr: core.ErrInvalidCVV,
+		},
+		"missing CVV": {
+			card:        core.CreditCard{Number: 4000000000000077, ExpiryMonth: 10, ExpiryYear: 2025},
+			expectedErr: core.ErrInvalidCVV,
+		},
+		"amex 4 digit CVV": {
+			card: core.CreditCard{Number: 378282246310005, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "1234"},
+		},
+		"amex 3 digit CVV": {
+			card:        core.CreditCard{Number: 378282246310005, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "123"},
+			expectedErr: core.ErrInvalidCVV,
+		},
+		"amex 5 digit CVV": {
+			card:        core.CreditCard{Number: 378282246310005, ExpiryMonth: 10, ExpiryYear: 2025, CVV: "12345"},
+			expectedErr: core.ErrInvalidCVV,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.card.Validate(now)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
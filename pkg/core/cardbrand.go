@@ -0,0 +1,123 @@
+package core
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// CardBrand represents the card scheme a card number belongs to.
+type CardBrand string
+
+const (
+	CardBrand_Unknown    CardBrand = "unknown"
+	CardBrand_Visa       CardBrand = "visa"
+	CardBrand_Mastercard CardBrand = "mastercard"
+	CardBrand_Amex       CardBrand = "amex"
+	CardBrand_Discover   CardBrand = "discover"
+	CardBrand_JCB        CardBrand = "jcb"
+	CardBrand_Diners     CardBrand = "diners"
+	CardBrand_UnionPay   CardBrand = "unionpay"
+	CardBrand_Maestro    CardBrand = "maestro"
+)
+
+// CVVLength returns the number of digits of the CVV for cards of the brand.
+func (b CardBrand) CVVLength() int {
+	if b == CardBrand_Amex {
+		return 4
+	}
+	return 3
+}
+
+// binRangesCSV holds the BIN (Bank Identification Number) ranges of each brand, one per line with the brand,
+// the first and last prefix of the range, and the minimum and maximum length of the card numbers.
+//
+//go:embed data/bin_ranges.csv
+var binRangesCSV []byte
+
+// binRanges holds the BIN ranges, the most specific (longest prefix) first.
+var binRanges = mustParseBINRanges(binRangesCSV)
+
+// binRange represents the card numbers of a brand starting with a prefix between from and to.
+type binRange struct {
+	brand        CardBrand
+	prefixLength int
+	from, to     int64
+	minLength    int
+	maxLength    int
+}
+
+// DetectCardBrand returns the brand of the card number from its prefix and length.
+// It returns CardBrand_Unknown if the number doesn't belong to any known BIN range.
+func DetectCardBrand(number int64) CardBrand {
+	digits := strconv.FormatInt(number, 10)
+
+	for _, r := range binRanges {
+		if len(digits) < r.minLength || len(digits) > r.maxLength {
+			continue
+		}
+		prefix, err := strconv.ParseInt(digits[:r.prefixLength], 10, 64)
+		if err != nil {
+			continue
+		}
+		if prefix >= r.from && prefix <= r.to {
+			return r.brand
+		}
+	}
+
+	return CardBrand_Unknown
+}
+
+// mustParseBINRanges parses the BIN ranges table and panics if it's malformed.
+func mustParseBINRanges(data []byte) []binRange {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse BIN ranges table: %s", err))
+	}
+
+	result := make([]binRange, 0, len(records))
+	// Skip the header
+	for i, record := range records[1:] {
+		r, err := parseBINRange(record)
+		if err != nil {
+			panic(fmt.Sprintf("failed to parse BIN ranges table: line %d: %s", i+2, err))
+		}
+		result = append(result, r)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].prefixLength > result[j].prefixLength
+	})
+
+	return result
+}
+
+// parseBINRange parses a line of the BIN ranges table.
+func parseBINRange(record []string) (r binRange, err error) {
+	r.brand = CardBrand(record[0])
+	if len(record[1]) != len(record[2]) {
+		return binRange{}, fmt.Errorf("prefixes <%s> and <%s> have different lengths", record[1], record[2])
+	}
+	r.prefixLength = len(record[1])
+
+	if r.from, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return binRange{}, fmt.Errorf("invalid prefix <%s>", record[1])
+	}
+	if r.to, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+		return binRange{}, fmt.Errorf("invalid prefix <%s>", record[2])
+	}
+	if r.minLength, err = strconv.Atoi(record[3]); err != nil {
+		return binRange{}, fmt.Errorf("invalid length <%s>", record[3])
+	}
+	if r.maxLength, err = strconv.Atoi(record[4]); err != nil {
+		return binRange{}, fmt.Errorf("invalid length <%s>", record[4])
+	}
+	if r.minLength < r.prefixLength || r.maxLength < r.minLength {
+		return binRange{}, fmt.Errorf("invalid lengths <%d> to <%d>", r.minLength, r.maxLength)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,39 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectCardBrand(t *testing.T) {
+	tests := map[string]struct {
+		number        int64
+		expectedBrand core.CardBrand
+	}{
+		"visa":                {number: 4111111111111111, expectedBrand: core.CardBrand_Visa},
+		"visa 13 digits":      {number: 4222222222222, expectedBrand: core.CardBrand_Visa},
+		"mastercard":          {number: 5555555555554444, expectedBrand: core.CardBrand_Mastercard},
+		"mastercard 2-series": {number: 2223003122003222, expectedBrand: core.CardBrand_Mastercard},
+		"amex":                {number: 378282246310005, expectedBrand: core.CardBrand_Amex},
+		"amex wrong length":   {number: 3782822463100050, expectedBrand: core.CardBrand_Unknown},
+		"discover":            {number: 6011111111111117, expectedBrand: core.CardBrand_Discover},
+		"discover 65":         {number: 6500000000000002, expectedBrand: core.CardBrand_Discover},
+		"jcb":                 {number: 3530111333300000, expectedBrand: core.CardBrand_JCB},
+		"diners":              {number: 30569309025904, expectedBrand: core.CardBrand_Diners},
+		"diners 36":           {number: 36227206271667, expectedBrand: core.CardBrand_Diners},
+		"unionpay":            {number: 6200000000000005, expectedBrand: core.CardBrand_UnionPay},
+		"maestro":             {number: 6759649826438453, expectedBrand: core.CardBrand_Maestro},
+		"maestro 12 digits":   {number: 501800000009, expectedBrand: core.CardBrand_Maestro},
+		"maestro 5018":        {number: 5018000000000009, expectedBrand: core.CardBrand_Maestro},
+		"unknown prefix":      {number: 1111222233334444, expectedBrand: core.CardBrand_Unknown},
+		"too short":           {number: 4, expectedBrand: core.CardBrand_Unknown},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expectedBrand, core.DetectCardBrand(test.number))
+		})
+	}
+}
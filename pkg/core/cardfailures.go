@@ -0,0 +1,109 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CardFailures holds the operations failing on a credit card, each with the reason it's declined for.
+//
+// In the yaml file it's either a single CardFailure, or a list of them:
+//
+//	4000000000000119:
+//	  - "void fail"
+//	  - {operation: "refund fail", reason: "suspected_fraud"}
+type CardFailures []CardFailure
+
+// UnmarshalYAML unmarshals either a single CardFailure or a list of them to CardFailures.
+func (cfs *CardFailures) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	var result []CardFailure
+	if _, ok := raw.([]interface{}); ok {
+		err = unmarshal(&result)
+	} else {
+		var failure CardFailure
+		err = unmarshal(&failure)
+		result = []CardFailure{failure}
+	}
+	if err != nil {
+		return err
+	}
+
+	err = CardFailures(result).Validate()
+	if err != nil {
+		return err
+	}
+
+	*cfs = result
+	return nil
+}
+
+// Validate returns an error if an operation is missing or listed more than once.
+func (cfs CardFailures) Validate() error {
+	seen := make(map[CCFailReason]bool, len(cfs))
+	for _, failure := range cfs {
+		if failure.Operation == 0 {
+			return errors.New("missing operation to fail")
+		}
+		if seen[failure.Operation] {
+			return fmt.Errorf("operation <%s> listed more than once", failure.Operation)
+		}
+		seen[failure.Operation] = true
+	}
+	return nil
+}
+
+// CardFailure holds the operation failing on a credit card and the reason it's declined for.
+//
+// In the yaml file it's either the operation alone, declined with DeclineReason_DoNotHonour:
+//
+//	4000000000000119: "authorise fail"
+//
+// or a mapping with both:
+//
+//	4000000000000119: {operation: "authorise fail", reason: "insufficient_funds"}
+type CardFailure struct {
+	Operation CCFailReason  `yaml:"operation"`
+	Reason    DeclineReason `yaml:"reason"`
+}
+
+// UnmarshalYAML unmarshals either a quoted yaml string or a mapping to a CardFailure.
+func (cf *CardFailure) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var scalar string
+	if err := unmarshal(&scalar); err == nil {
+		var operation CCFailReason
+		err = operation.Load(scalar)
+		if err != nil {
+			return err
+		}
+		*cf = CardFailure{Operation: operation, Reason: DeclineReason_DoNotHonour}
+		return nil
+	}
+
+	// Alias the type so the mapping is decoded without calling this method again
+	type cardFailure CardFailure
+	result := cardFailure{Reason: DeclineReason_DoNotHonour}
+	err := unmarshal(&result)
+	if err != nil {
+		return err
+	}
+	if result.Operation == 0 {
+		return errors.New("missing operation to fail")
+	}
+
+	*cf = CardFailure(result)
+	return nil
+}
+
+// MarshalYAML marshals the CardFailure to a mapping with both the operation and the reason.
+func (cf CardFailure) MarshalYAML() (interface{}, error) {
+	return struct {
+		Operation string `yaml:"operation"`
+		Reason    string `yaml:"reason"`
+	}{Operation: cf.Operation.String(), Reason: cf.Reason.String()}, nil
+}
@@ -2,9 +2,11 @@ package core
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
 )
@@ -31,13 +33,126 @@ type OptionsConfiguration struct {
 	// and also, enables pprof
 	DevMode bool
 
-	LogLevel    log.Level
-	CreditCards CreditCardsConfiguration
+	LogLevel       log.Level
+	CreditCards    CreditCardsConfiguration
+	Authorisations AuthorisationsConfiguration
+
+	// NotFoundHTTPStatus is the HTTP status returned along with the "authorisation not found" code.
+	NotFoundHTTPStatus int
+
+	// IdempotencyKeyTTL is how long the response to a request carrying an idempotency key is kept for.
+	IdempotencyKeyTTL time.Duration
+
+	// AcceptedCurrencies are the ISO 4217 currency codes authorisations are accepted in.
+	// Empty means all currencies are accepted.
+	AcceptedCurrencies []string
+
+	RandomFailures RandomFailuresConfiguration
+	Webhooks       WebhooksConfiguration
+	Settlement     SettlementConfiguration
+}
+
+// Settlement modes for captures.
+const (
+	// SettlementSync settles captures straight away, they are final when the capture returns.
+	SettlementSync = "sync"
+	// SettlementDelay leaves captures pending settlement for a delay.
+	SettlementDelay = "delay"
+	// SettlementCutOff leaves captures pending settlement until the next daily cut-off time.
+	SettlementCutOff = "cutoff"
+)
+
+// SettlementConfiguration holds configuration related to the settlement of captures.
+type SettlementConfiguration struct {
+	// Mode is when captures settle, one of SettlementSync, SettlementDelay or SettlementCutOff.
+	Mode string
+	// Delay is how long captures are pending settlement for, in SettlementDelay mode.
+	Delay time.Duration
+	// CutOff is the time of the day (UTC), since midnight, captures settle at in SettlementCutOff mode.
+	CutOff time.Duration
+	// Interval is how often captures due to settle are looked for.
+	Interval time.Duration
+}
+
+// Enabled returns true if captures settle asynchronously.
+func (sc SettlementConfiguration) Enabled() bool {
+	return sc.Mode != SettlementSync
+}
+
+// Schedule returns the schedule captures settle on.
+func (sc SettlementConfiguration) Schedule() SettlementSchedule {
+	return SettlementSchedule{Delay: sc.Delay, CutOff: sc.CutOff, AtCutOff: sc.Mode == SettlementCutOff}
+}
+
+// WebhooksConfiguration holds configuration related to the webhooks notifying captures, voids and refunds.
+type WebhooksConfiguration struct {
+	// URLs are the endpoints every webhook is delivered to. Empty disables webhooks.
+	URLs []string
+	// Secret is the key the payloads are signed with.
+	Secret string
+	// MaxAttempts is how many times a webhook is attempted before giving up on it.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry, doubling on every further attempt.
+	RetryBackoff time.Duration
+	// QueueFilename is the database file pending deliveries are kept in, so they survive restarts.
+	// Empty keeps them in memory.
+	QueueFilename string
+	// LogRetention is how long delivered and failed deliveries are kept in the delivery log for.
+	LogRetention time.Duration
+}
+
+// Enabled returns true if webhooks are delivered to any endpoint.
+func (wc WebhooksConfiguration) Enabled() bool {
+	return len(wc.URLs) > 0
+}
+
+// RandomFailuresConfiguration holds configuration related to the operations failing at random.
+type RandomFailuresConfiguration struct {
+	// DeclineRates are the rates each operation is declined at, between 0 and 1.
+	DeclineRates map[CCFailReason]float64
+	// ErrorRates are the rates each operation fails with an HTTP 500 at, between 0 and 1.
+	ErrorRates map[CCFailReason]float64
+	// Seed seeds the random failures and latencies, so runs can be reproduced. Zero seeds them from the current time.
+	Seed int64
+}
+
+// Enabled returns true if any operation is set to fail at random.
+func (rfc RandomFailuresConfiguration) Enabled() bool {
+	return len(rfc.DeclineRates) > 0 || len(rfc.ErrorRates) > 0
 }
 
 // CreditCardsConfiguration holds configuration related to credit cards edge cases file.
 type CreditCardsConfiguration struct {
 	Filename string
+	// ReloadInterval is how often the file is checked for changes. Zero means it's only reloaded on SIGHUP.
+	ReloadInterval time.Duration
+	// AdminAPI serves the admin API managing the credit cards at runtime.
+	AdminAPI bool
+	// WriteBack writes the credit cards changed through the admin API back to the file.
+	WriteBack bool
+}
+
+// Storage types for authorisations.
+const (
+	// StorageMemory keeps authorisations in memory, they are lost on restart.
+	StorageMemory = "memory"
+	// StorageFile keeps authorisations in a database file, they survive restarts.
+	StorageFile = "file"
+)
+
+// AuthorisationsConfiguration holds configuration related to authorisations.
+type AuthorisationsConfiguration struct {
+	// Storage is where authorisations are kept, either StorageMemory or StorageFile.
+	Storage string
+	// Filename is the database file used when Storage is StorageFile.
+	Filename string
+	// SnapshotFilename is the file the in-memory authorisations are saved to on shutdown
+	// and restored from on startup, when Storage is StorageMemory. Empty disables snapshots.
+	SnapshotFilename string
+	// TTL is how long an authorisation can be captured or voided for. Zero means it never expires.
+	TTL time.Duration
+	// ReaperInterval is how often expired authorisations are looked for.
+	ReaperInterval time.Duration
 }
 
 // NewConfig returns new default configuration
@@ -74,12 +189,186 @@ func (config *Configuration) LoadConfig() (err error) {
 		}
 	}
 
+	if notFoundStatus, ok := os.LookupEnv(AppPrefix + "_OPTIONS_NOT_FOUND_HTTP_STATUS"); ok {
+		config.Options.NotFoundHTTPStatus, err = strconv.Atoi(notFoundStatus)
+		if err != nil || config.Options.NotFoundHTTPStatus < 200 || config.Options.NotFoundHTTPStatus > 599 {
+			return fmt.Errorf("configuration error: [options not found http status] input not allowed <%s>", notFoundStatus)
+		}
+	}
+
+	if idempotencyKeyTTL, ok := os.LookupEnv(AppPrefix + "_OPTIONS_IDEMPOTENCY_KEY_TTL"); ok {
+		config.Options.IdempotencyKeyTTL, err = time.ParseDuration(idempotencyKeyTTL)
+		if err != nil || config.Options.IdempotencyKeyTTL <= 0 {
+			return fmt.Errorf("configuration error: [options idempotency key ttl] input not allowed <%s>", idempotencyKeyTTL)
+		}
+	}
+
+	if acceptedCurrencies, ok := os.LookupEnv(AppPrefix + "_OPTIONS_ACCEPTED_CURRENCIES"); ok {
+		config.Options.AcceptedCurrencies, err = ParseCurrencyList(acceptedCurrencies)
+		if err != nil {
+			return fmt.Errorf("configuration error: [options accepted currencies] %s", err.Error())
+		}
+	}
+
+	if declineRates, ok := os.LookupEnv(AppPrefix + "_OPTIONS_RANDOM_DECLINE_RATES"); ok {
+		config.Options.RandomFailures.DeclineRates, err = ParseFailureRates(declineRates)
+		if err != nil {
+			return fmt.Errorf("configuration error: [options random decline rates] %s", err.Error())
+		}
+	}
+
+	if errorRates, ok := os.LookupEnv(AppPrefix + "_OPTIONS_RANDOM_ERROR_RATES"); ok {
+		config.Options.RandomFailures.ErrorRates, err = ParseFailureRates(errorRates)
+		if err != nil {
+			return fmt.Errorf("configuration error: [options random error rates] %s", err.Error())
+		}
+	}
+
+	for operation, rate := range config.Options.RandomFailures.DeclineRates {
+		if rate+config.Options.RandomFailures.ErrorRates[operation] > 1 {
+			return fmt.Errorf("configuration error: [options random rates] <%s> rates add up to more than 100%%", operation.Name())
+		}
+	}
+
+	if seed, ok := os.LookupEnv(AppPrefix + "_OPTIONS_RANDOM_SEED"); ok {
+		config.Options.RandomFailures.Seed, err = strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return fmt.Errorf("configuration error: [options random seed] input not allowed <%s>", seed)
+		}
+	}
+
 	if dbFileName, ok := os.LookupEnv(AppPrefix + "_OPTIONS_CREDITCARDS_FILENAME"); ok {
 		config.Options.CreditCards.Filename = dbFileName
 	} else {
 		return fmt.Errorf("configuration error: [creditcards filename] mandatory config parameter missing")
 	}
 
+	if reloadInterval, ok := os.LookupEnv(AppPrefix + "_OPTIONS_CREDITCARDS_RELOAD_INTERVAL"); ok {
+		config.Options.CreditCards.ReloadInterval, err = time.ParseDuration(reloadInterval)
+		if err != nil || config.Options.CreditCards.ReloadInterval < 0 {
+			return fmt.Errorf("configuration error: [creditcards reload interval] input not allowed <%s>", reloadInterval)
+		}
+	}
+
+	if adminAPI, ok := os.LookupEnv(AppPrefix + "_OPTIONS_CREDITCARDS_ADMIN_API"); ok {
+		config.Options.CreditCards.AdminAPI, err = strconv.ParseBool(adminAPI)
+		if err != nil {
+			return fmt.Errorf("configuration error: [creditcards admin api] unrecognizable boolean <%s>", adminAPI)
+		}
+	}
+
+	if writeBack, ok := os.LookupEnv(AppPrefix + "_OPTIONS_CREDITCARDS_WRITE_BACK"); ok {
+		config.Options.CreditCards.WriteBack, err = strconv.ParseBool(writeBack)
+		if err != nil {
+			return fmt.Errorf("configuration error: [creditcards write back] unrecognizable boolean <%s>", writeBack)
+		}
+	}
+
+	if storage, ok := os.LookupEnv(AppPrefix + "_OPTIONS_AUTHORISATIONS_STORAGE"); ok {
+		storage = strings.ToLower(storage)
+		if storage != StorageMemory && storage != StorageFile {
+			return fmt.Errorf("configuration error: [authorisations storage] unrecognised storage type <%s>", storage)
+		}
+		config.Options.Authorisations.Storage = storage
+	}
+
+	if authFileName, ok := os.LookupEnv(AppPrefix + "_OPTIONS_AUTHORISATIONS_FILENAME"); ok {
+		config.Options.Authorisations.Filename = authFileName
+	} else if config.Options.Authorisations.Storage == StorageFile {
+		return fmt.Errorf("configuration error: [authorisations filename] mandatory config parameter missing for file storage")
+	}
+
+	if snapshotFileName, ok := os.LookupEnv(AppPrefix + "_OPTIONS_AUTHORISATIONS_SNAPSHOT_FILENAME"); ok {
+		if config.Options.Authorisations.Storage == StorageFile {
+			return fmt.Errorf("configuration error: [authorisations snapshot filename] not allowed with file storage")
+		}
+		config.Options.Authorisations.SnapshotFilename = snapshotFileName
+	}
+
+	if ttl, ok := os.LookupEnv(AppPrefix + "_OPTIONS_AUTHORISATIONS_TTL"); ok {
+		config.Options.Authorisations.TTL, err = time.ParseDuration(ttl)
+		if err != nil || config.Options.Authorisations.TTL < 0 {
+			return fmt.Errorf("configuration error: [authorisations ttl] input not allowed <%s>", ttl)
+		}
+	}
+
+	if reaperInterval, ok := os.LookupEnv(AppPrefix + "_OPTIONS_AUTHORISATIONS_REAPER_INTERVAL"); ok {
+		config.Options.Authorisations.ReaperInterval, err = time.ParseDuration(reaperInterval)
+		if err != nil || config.Options.Authorisations.ReaperInterval <= 0 {
+			return fmt.Errorf("configuration error: [authorisations reaper interval] input not allowed <%s>", reaperInterval)
+		}
+	}
+
+	if webhookURLs, ok := os.LookupEnv(AppPrefix + "_OPTIONS_WEBHOOKS_URLS"); ok {
+		config.Options.Webhooks.URLs, err = ParseWebhookURLs(webhookURLs)
+		if err != nil {
+			return fmt.Errorf("configuration error: [webhooks urls] %s", err.Error())
+		}
+	}
+
+	if webhookSecret, ok := os.LookupEnv(AppPrefix + "_OPTIONS_WEBHOOKS_SECRET"); ok {
+		config.Options.Webhooks.Secret = webhookSecret
+	}
+	if config.Options.Webhooks.Enabled() && config.Options.Webhooks.Secret == "" {
+		return fmt.Errorf("configuration error: [webhooks secret] mandatory config parameter missing when webhooks are enabled")
+	}
+
+	if maxAttempts, ok := os.LookupEnv(AppPrefix + "_OPTIONS_WEBHOOKS_MAX_ATTEMPTS"); ok {
+		config.Options.Webhooks.MaxAttempts, err = strconv.Atoi(maxAttempts)
+		if err != nil || config.Options.Webhooks.MaxAttempts <= 0 {
+			return fmt.Errorf("configuration error: [webhooks max attempts] input not allowed <%s>", maxAttempts)
+		}
+	}
+
+	if retryBackoff, ok := os.LookupEnv(AppPrefix + "_OPTIONS_WEBHOOKS_RETRY_BACKOFF"); ok {
+		config.Options.Webhooks.RetryBackoff, err = time.ParseDuration(retryBackoff)
+		if err != nil || config.Options.Webhooks.RetryBackoff <= 0 {
+			return fmt.Errorf("configuration error: [webhooks retry backoff] input not allowed <%s>", retryBackoff)
+		}
+	}
+
+	if queueFileName, ok := os.LookupEnv(AppPrefix + "_OPTIONS_WEBHOOKS_QUEUE_FILENAME"); ok {
+		config.Options.Webhooks.QueueFilename = queueFileName
+	}
+
+	if logRetention, ok := os.LookupEnv(AppPrefix + "_OPTIONS_WEBHOOKS_LOG_RETENTION"); ok {
+		config.Options.Webhooks.LogRetention, err = time.ParseDuration(logRetention)
+		if err != nil || config.Options.Webhooks.LogRetention <= 0 {
+			return fmt.Errorf("configuration error: [webhooks log retention] input not allowed <%s>", logRetention)
+		}
+	}
+
+	if settlementMode, ok := os.LookupEnv(AppPrefix + "_OPTIONS_SETTLEMENT_MODE"); ok {
+		settlementMode = strings.ToLower(settlementMode)
+		if settlementMode != SettlementSync && settlementMode != SettlementDelay && settlementMode != SettlementCutOff {
+			return fmt.Errorf("configuration error: [settlement mode] unrecognised settlement mode <%s>", settlementMode)
+		}
+		config.Options.Settlement.Mode = settlementMode
+	}
+
+	if settlementDelay, ok := os.LookupEnv(AppPrefix + "_OPTIONS_SETTLEMENT_DELAY"); ok {
+		config.Options.Settlement.Delay, err = time.ParseDuration(settlementDelay)
+		if err != nil || config.Options.Settlement.Delay < 0 {
+			return fmt.Errorf("configuration error: [settlement delay] input not allowed <%s>", settlementDelay)
+		}
+	}
+
+	if cutOff, ok := os.LookupEnv(AppPrefix + "_OPTIONS_SETTLEMENT_CUTOFF"); ok {
+		config.Options.Settlement.CutOff, err = ParseCutOff(cutOff)
+		if err != nil {
+			return fmt.Errorf("configuration error: [settlement cutoff] %s", err.Error())
+		}
+	} else if config.Options.Settlement.Mode == SettlementCutOff {
+		return fmt.Errorf("configuration error: [settlement cutoff] mandatory config parameter missing when settlement mode is cutoff")
+	}
+
+	if settlementInterval, ok := os.LookupEnv(AppPrefix + "_OPTIONS_SETTLEMENT_INTERVAL"); ok {
+		config.Options.Settlement.Interval, err = time.ParseDuration(settlementInterval)
+		if err != nil || config.Options.Settlement.Interval <= 0 {
+			return fmt.Errorf("configuration error: [settlement interval] input not allowed <%s>", settlementInterval)
+		}
+	}
+
 	return nil
 }
 
@@ -92,6 +381,89 @@ func (config *Configuration) setDefaults() {
 	// Options
 	config.Options.DevMode = false
 	config.Options.LogLevel = log.INFO
+	config.Options.NotFoundHTTPStatus = 200
+	config.Options.IdempotencyKeyTTL = 24 * time.Hour
+	config.Options.CreditCards.ReloadInterval = 2 * time.Second
+	config.Options.Authorisations.Storage = StorageMemory
+	config.Options.Authorisations.TTL = 7 * 24 * time.Hour
+	config.Options.Authorisations.ReaperInterval = time.Minute
+	config.Options.Webhooks.MaxAttempts = 8
+	config.Options.Webhooks.RetryBackoff = time.Second
+	config.Options.Webhooks.LogRetention = 24 * time.Hour
+	config.Options.Settlement.Mode = SettlementSync
+	config.Options.Settlement.Delay = 30 * time.Second
+	config.Options.Settlement.Interval = time.Second
+}
+
+// ParseCurrencyList parses a comma separated list of ISO 4217 currency codes, e.g. "EUR,GBP,USD".
+func ParseCurrencyList(list string) (codes []string, err error) {
+	for _, code := range strings.Split(list, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if _, ok := LookupCurrency(code); !ok {
+			return nil, fmt.Errorf("unknown currency <%s>", code)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// ParseWebhookURLs parses a comma separated list of absolute http or https URLs,
+// e.g. "https://example.com/webhooks,http://localhost:9000/hook".
+func ParseWebhookURLs(list string) (urls []string, err error) {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		u, err := url.Parse(item)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url <%s>", item)
+		}
+		urls = append(urls, item)
+	}
+
+	return urls, nil
+}
+
+// ParseFailureRates parses a comma separated list of operations and the percentage of them to fail,
+// e.g. "authorise=2%,capture=0.5%", and returns the rates between 0 and 1.
+func ParseFailureRates(list string) (rates map[CCFailReason]float64, err error) {
+	rates = make(map[CCFailReason]float64)
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected operation=percentage <%s>", item)
+		}
+
+		var operation CCFailReason
+		if err := operation.Load(strings.TrimSpace(parts[0])); err != nil {
+			return nil, fmt.Errorf("unknown operation <%s>", parts[0])
+		}
+		if _, ok := rates[operation]; ok {
+			return nil, fmt.Errorf("operation <%s> listed more than once", operation.Name())
+		}
+
+		value := strings.TrimSuffix(strings.TrimSpace(parts[1]), "%")
+		percentage, err := strconv.ParseFloat(value, 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return nil, fmt.Errorf("invalid percentage <%s>", parts[1])
+		}
+
+		rates[operation] = percentage / 100
+	}
+
+	return rates, nil
 }
 
 // ParseLogLevel parses a string and returns a log level enum.
@@ -0,0 +1,207 @@
+package core_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFailureRates(t *testing.T) {
+	tests := map[string]struct {
+		input          string
+		expectedErr    bool
+		expectedOutput map[core.CCFailReason]float64
+	}{
+		"single operation": {
+			input:          "authorise=2%",
+			expectedOutput: map[core.CCFailReason]float64{core.CCFailReason_Authorise: 0.02},
+		},
+		"several operations": {
+			input:          "authorise=2, capture = 0.5% ,refund=100%",
+			expectedOutput: map[core.CCFailReason]float64{core.CCFailReason_Authorise: 0.02, core.CCFailReason_Capture: 0.005, core.CCFailReason_Refund: 1},
+		},
+		"empty":                  {input: "", expectedOutput: map[core.CCFailReason]float64{}},
+		"unknown operation":      {input: "chargeback=2%", expectedErr: true},
+		"missing percentage":     {input: "authorise", expectedErr: true},
+		"invalid percentage":     {input: "authorise=two", expectedErr: true},
+		"percentage above 100":   {input: "authorise=101%", expectedErr: true},
+		"negative percentage":    {input: "authorise=-1%", expectedErr: true},
+		"operation listed twice": {input: "void=1%,void=2%", expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rates, err := core.ParseFailureRates(test.input)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, rates, len(test.expectedOutput))
+			for operation, rate := range test.expectedOutput {
+				assert.InDelta(t, rate, rates[operation], 1e-9)
+			}
+		})
+	}
+}
+
+func TestParseWebhookURLs(t *testing.T) {
+	tests := map[string]struct {
+		input          string
+		expectedErr    bool
+		expectedOutput []string
+	}{
+		"single url": {
+			input:          "https://example.com/webhooks",
+			expectedOutput: []string{"https://example.com/webhooks"},
+		},
+		"several urls": {
+			input:          "https://example.com/webhooks, http://localhost:9000/hook,",
+			expectedOutput: []string{"https://example.com/webhooks", "http://localhost:9000/hook"},
+		},
+		"empty":              {input: ""},
+		"unsupported scheme": {input: "ftp://example.com/webhooks", expectedErr: true},
+		"relative url":       {input: "/webhooks", expectedErr: true},
+		"missing host":       {input: "http:///webhooks", expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			urls, err := core.ParseWebhookURLs(test.input)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOutput, urls)
+		})
+	}
+}
+
+// setEnv sets the environment variables for the duration of the test, unsetting the ones with an empty value.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		name := name
+		previous, ok := os.LookupEnv(name)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, previous)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+		if value == "" {
+			require.NoError(t, os.Unsetenv(name))
+		} else {
+			require.NoError(t, os.Setenv(name, value))
+		}
+	}
+}
+
+func TestLoadConfigSettlement(t *testing.T) {
+	tests := map[string]struct {
+		mode             string
+		cutOff           string
+		expectedErr      string
+		expectedSchedule core.SettlementSchedule
+	}{
+		"sync": {
+			mode:             "sync",
+			expectedSchedule: core.SettlementSchedule{Delay: 30 * time.Second},
+		},
+		"delay": {
+			mode:             "delay",
+			expectedSchedule: core.SettlementSchedule{Delay: 30 * time.Second},
+		},
+		"cutoff": {
+			mode:             "cutoff",
+			cutOff:           "17:30",
+			expectedSchedule: core.SettlementSchedule{Delay: 30 * time.Second, CutOff: 17*time.Hour + 30*time.Minute, AtCutOff: true},
+		},
+		"cutoff missing": {
+			mode:        "cutoff",
+			expectedErr: "configuration error: [settlement cutoff] mandatory config parameter missing when settlement mode is cutoff",
+		},
+		"invalid cutoff": {
+			mode:        "cutoff",
+			cutOff:      "5pm",
+			expectedErr: "configuration error: [settlement cutoff] expected HH:MM <5pm>",
+		},
+		"unknown mode": {
+			mode:        "weekly",
+			expectedErr: "configuration error: [settlement mode] unrecognised settlement mode <weekly>",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			setEnv(t, map[string]string{
+				core.AppPrefix + "_OPTIONS_CREDITCARDS_FILENAME": "cards.yaml",
+				core.AppPrefix + "_OPTIONS_SETTLEMENT_MODE":      test.mode,
+				core.AppPrefix + "_OPTIONS_SETTLEMENT_CUTOFF":    test.cutOff,
+			})
+
+			config := core.NewConfig()
+			err := config.LoadConfig()
+			if test.expectedErr != "" {
+				require.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSchedule, config.Options.Settlement.Schedule())
+		})
+	}
+}
+
+func TestLoadConfigAuthorisations(t *testing.T) {
+	tests := map[string]struct {
+		storage          string
+		filename         string
+		snapshotFilename string
+		expectedErr      string
+	}{
+		"memory storage": {},
+		"memory storage with snapshot": {
+			snapshotFilename: "snapshot.json",
+		},
+		"file storage": {
+			storage:  "file",
+			filename: "auth.db",
+		},
+		"file storage without filename": {
+			storage:     "file",
+			expectedErr: "configuration error: [authorisations filename] mandatory config parameter missing for file storage",
+		},
+		"file storage with snapshot": {
+			storage:          "file",
+			filename:         "auth.db",
+			snapshotFilename: "snapshot.json",
+			expectedErr:      "configuration error: [authorisations snapshot filename] not allowed with file storage",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			setEnv(t, map[string]string{
+				core.AppPrefix + "_OPTIONS_CREDITCARDS_FILENAME":             "cards.yaml",
+				core.AppPrefix + "_OPTIONS_AUTHORISATIONS_STORAGE":           test.storage,
+				core.AppPrefix + "_OPTIONS_AUTHORISATIONS_FILENAME":          test.filename,
+				core.AppPrefix + "_OPTIONS_AUTHORISATIONS_SNAPSHOT_FILENAME": test.snapshotFilename,
+			})
+
+			config := core.NewConfig()
+			err := config.LoadConfig()
+			if test.expectedErr != "" {
+				require.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.snapshotFilename, config.Options.Authorisations.SnapshotFilename)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrUnknownCurrency is returned when the currency is not an ISO 4217 currency code.
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// iso4217CSV holds the active ISO 4217 currencies, one per line with their code, number, exponent and name.
+// Codes without a minor unit (precious metals, testing codes, etc.) are left out, as they can't be charged.
+//
+//go:embed data/iso4217.csv
+var iso4217CSV []byte
+
+// currencies holds the ISO 4217 currencies indexed by code.
+var currencies = mustParseCurrencies(iso4217CSV)
+
+// Currency holds the details of an ISO 4217 currency.
+type Currency struct {
+	// Code is the three letter code, e.g. "EUR".
+	Code string
+	// Number is the three digit numeric code, e.g. "978".
+	Number string
+	// Exponent is the number of decimal places of the minor unit, e.g. 2 for cents.
+	Exponent int
+	Name     string
+}
+
+// LookupCurrency returns the ISO 4217 currency with the provided code.
+// Codes are case sensitive, e.g. "eur" is not a valid code.
+func LookupCurrency(code string) (currency Currency, ok bool) {
+	currency, ok = currencies[code]
+	return currency, ok
+}
+
+// CurrencyExponent returns the number of decimal places of the currency's minor unit.
+// Unknown currencies default to two decimal places.
+func CurrencyExponent(code string) int {
+	if currency, ok := currencies[code]; ok {
+		return currency.Exponent
+	}
+	return 2
+}
+
+// mustParseCurrencies parses the ISO 4217 table and panics if it's malformed.
+func mustParseCurrencies(data []byte) map[string]Currency {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse ISO 4217 table: %s", err))
+	}
+
+	result := make(map[string]Currency, len(records))
+	// Skip the header
+	for _, record := range records[1:] {
+		exponent, err := strconv.Atoi(record[2])
+		if err != nil {
+			panic(fmt.Sprintf("failed to parse ISO 4217 table: invalid exponent for currency <%s>", record[0]))
+		}
+		result[record[0]] = Currency{Code: record[0], Number: record[1], Exponent: exponent, Name: record[3]}
+	}
+
+	return result
+}
@@ -0,0 +1,62 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupCurrency(t *testing.T) {
+	tests := map[string]struct {
+		code             string
+		expectedOK       bool
+		expectedExponent int
+	}{
+		"euro":           {code: "EUR", expectedOK: true, expectedExponent: 2},
+		"yen":            {code: "JPY", expectedOK: true, expectedExponent: 0},
+		"kuwaiti dinar":  {code: "KWD", expectedOK: true, expectedExponent: 3},
+		"unidad fomento": {code: "CLF", expectedOK: true, expectedExponent: 4},
+		"lower case":     {code: "eur", expectedOK: false},
+		"too long":       {code: "EURO", expectedOK: false},
+		"too short":      {code: "xx", expectedOK: false},
+		"gold":           {code: "XAU", expectedOK: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			currency, ok := core.LookupCurrency(test.code)
+			require.Equal(t, test.expectedOK, ok)
+			if ok {
+				assert.Equal(t, test.code, currency.Code)
+				assert.Equal(t, test.expectedExponent, currency.Exponent)
+			}
+		})
+	}
+}
+
+func TestParseCurrencyList(t *testing.T) {
+	tests := map[string]struct {
+		input          string
+		expectedErr    bool
+		expectedOutput []string
+	}{
+		"single currency":    {input: "EUR", expectedOutput: []string{"EUR"}},
+		"several currencies": {input: "EUR, gbp ,USD", expectedOutput: []string{"EUR", "GBP", "USD"}},
+		"empty":              {input: "", expectedOutput: nil},
+		"unknown currency":   {input: "EUR,EURO", expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			codes, err := core.ParseCurrencyList(test.input)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOutput, codes)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 )
@@ -17,18 +18,32 @@ const (
 	CCFailReason_Refund
 	// CCFailReason_Void represents a void fail.
 	CCFailReason_Void
+	// CCFailReason_Settle represents a settlement fail, for captures settled asynchronously.
+	CCFailReason_Settle
 )
 
 // String returns the string representation of CCFailReason.
 func (ccfr CCFailReason) String() string {
-	return [...]string{"", "authorise fail", "capture fail", "refund fail", "void fail"}[ccfr]
+	return [...]string{"", "authorise fail", "capture fail", "refund fail", "void fail", "settle fail"}[ccfr]
 }
 
+// Name returns the name of the operation the CCFailReason fails.
+func (ccfr CCFailReason) Name() string {
+	return [...]string{"", "authorise", "capture", "refund", "void", "settle"}[ccfr]
+}
+
+// ccFailReasonToEnum also accepts the bare operation names, which read better in rules.
 var ccFailReasonToEnum = map[string]CCFailReason{
 	"authorise fail": CCFailReason_Authorise,
 	"capture fail":   CCFailReason_Capture,
 	"refund fail":    CCFailReason_Refund,
 	"void fail":      CCFailReason_Void,
+	"settle fail":    CCFailReason_Settle,
+	"authorise":      CCFailReason_Authorise,
+	"capture":        CCFailReason_Capture,
+	"refund":         CCFailReason_Refund,
+	"void":           CCFailReason_Void,
+	"settle":         CCFailReason_Settle,
 }
 
 // Load loads a reason into CCFailReason
@@ -56,3 +71,449 @@ func (ccfr *CCFailReason) UnmarshalYAML(unmarshal func(interface{}) error) error
 	*ccfr = result
 	return nil
 }
+
+// DeclineReason represents why the issuer declined an operation on a credit card.
+type DeclineReason uint
+
+const (
+	// DeclineReason_DoNotHonour represents a generic decline, used when no other reason is given.
+	DeclineReason_DoNotHonour DeclineReason = iota + 1
+	// DeclineReason_InsufficientFunds represents a card without enough funds for the amount.
+	DeclineReason_InsufficientFunds
+	// DeclineReason_LostCard represents a card reported lost.
+	DeclineReason_LostCard
+	// DeclineReason_StolenCard represents a card reported stolen.
+	DeclineReason_StolenCard
+	// DeclineReason_SuspectedFraud represents an operation flagged as fraudulent.
+	DeclineReason_SuspectedFraud
+	// DeclineReason_LimitExceeded represents a card over its spending or frequency limit.
+	DeclineReason_LimitExceeded
+	// DeclineReason_IssuerUnavailable represents an issuer that couldn't be reached.
+	DeclineReason_IssuerUnavailable
+	// DeclineReason_RestrictedCard represents a card that can't be used for this kind of operation.
+	DeclineReason_RestrictedCard
+	// DeclineReason_TransactionNotPermitted represents an operation the cardholder isn't allowed to make.
+	DeclineReason_TransactionNotPermitted
+	// DeclineReason_PickUpCard represents a card the merchant should retain.
+	DeclineReason_PickUpCard
+)
+
+// String returns the machine-readable code of DeclineReason.
+func (dr DeclineReason) String() string {
+	return [...]string{"", "do_not_honour", "insufficient_funds", "lost_card", "stolen_card", "suspected_fraud",
+		"limit_exceeded", "issuer_unavailable", "restricted_card", "transaction_not_permitted", "pick_up_card"}[dr]
+}
+
+// Message returns a human-readable description of DeclineReason.
+func (dr DeclineReason) Message() string {
+	return [...]string{"", "Do not honour", "Insufficient funds", "Lost card", "Stolen card", "Suspected fraud",
+		"Exceeds withdrawal limit", "Issuer unavailable", "Restricted card", "Transaction not permitted to cardholder",
+		"Pick up card"}[dr]
+}
+
+var declineReasonToEnum = map[string]DeclineReason{
+	"do_not_honour":             DeclineReason_DoNotHonour,
+	"insufficient_funds":        DeclineReason_InsufficientFunds,
+	"lost_card":                 DeclineReason_LostCard,
+	"stolen_card":               DeclineReason_StolenCard,
+	"suspected_fraud":           DeclineReason_SuspectedFraud,
+	"limit_exceeded":            DeclineReason_LimitExceeded,
+	"issuer_unavailable":        DeclineReason_IssuerUnavailable,
+	"restricted_card":           DeclineReason_RestrictedCard,
+	"transaction_not_permitted": DeclineReason_TransactionNotPermitted,
+	"pick_up_card":              DeclineReason_PickUpCard,
+}
+
+// Load loads a reason code into DeclineReason.
+func (dr *DeclineReason) Load(reason string) error {
+	if reasonEnum, ok := declineReasonToEnum[reason]; ok {
+		*dr = reasonEnum
+		return nil
+	}
+	return fmt.Errorf("unknown decline reason <%s>", reason)
+}
+
+// MarshalJSON marshals the DeclineReason enum to a quoted json string.
+func (dr DeclineReason) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dr.String())
+}
+
+// UnmarshalJSON unmarshals a quoted json string to the DeclineReason enum.
+func (dr *DeclineReason) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := declineReasonToEnum[j]
+	if !ok {
+		return errors.New("couldn't find matching DeclineReason enum value")
+	}
+
+	*dr = result
+	return nil
+}
+
+// UnmarshalYAML unmarshals a quoted yaml string to the DeclineReason enum.
+func (dr *DeclineReason) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var j string
+	err := unmarshal(&j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := declineReasonToEnum[j]
+	if !ok {
+		return fmt.Errorf("couldn't find matching DeclineReason enum value <%s>", j)
+	}
+
+	*dr = result
+	return nil
+}
+
+// OutcomeAction represents what the processor does with an operation matched by a rule.
+type OutcomeAction uint
+
+const (
+	// OutcomeAction_Approve represents an operation processed normally, even if the card is set to fail.
+	OutcomeAction_Approve OutcomeAction = iota + 1
+	// OutcomeAction_Decline represents an operation declined for the credit card.
+	OutcomeAction_Decline
+	// OutcomeAction_Error represents an operation failing with an HTTP error.
+	OutcomeAction_Error
+	// OutcomeAction_Delay represents an operation processed normally after a delay.
+	OutcomeAction_Delay
+	// OutcomeAction_Fault represents an operation failing at the transport level.
+	OutcomeAction_Fault
+)
+
+// String returns the string representation of OutcomeAction.
+func (oa OutcomeAction) String() string {
+	return [...]string{"", "approve", "decline", "error", "delay", "fault"}[oa]
+}
+
+var outcomeActionToEnum = map[string]OutcomeAction{
+	"approve": OutcomeAction_Approve,
+	"decline": OutcomeAction_Decline,
+	"error":   OutcomeAction_Error,
+	"delay":   OutcomeAction_Delay,
+	"fault":   OutcomeAction_Fault,
+}
+
+// UnmarshalYAML unmarshals a quoted yaml string to the OutcomeAction enum.
+func (oa *OutcomeAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var j string
+	err := unmarshal(&j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := outcomeActionToEnum[j]
+	if !ok {
+		return fmt.Errorf("couldn't find matching OutcomeAction enum value <%s>", j)
+	}
+
+	*oa = result
+	return nil
+}
+
+// Fault represents how a response misbehaves at the transport level.
+type Fault uint
+
+const (
+	// Fault_NoBody represents an error status without a body, the operation isn't processed.
+	Fault_NoBody Fault = iota + 1
+	// Fault_TruncatedBody represents the response body cut short, after the operation is processed.
+	Fault_TruncatedBody
+	// Fault_InvalidJSON represents a response body that isn't valid JSON, after the operation is processed.
+	Fault_InvalidJSON
+	// Fault_ConnectionReset represents the connection reset mid-response, after the operation is processed.
+	Fault_ConnectionReset
+)
+
+// String returns the string representation of Fault.
+func (f Fault) String() string {
+	return [...]string{"", "no_body", "truncated_body", "invalid_json", "connection_reset"}[f]
+}
+
+var faultToEnum = map[string]Fault{
+	"no_body":          Fault_NoBody,
+	"truncated_body":   Fault_TruncatedBody,
+	"invalid_json":     Fault_InvalidJSON,
+	"connection_reset": Fault_ConnectionReset,
+}
+
+// UnmarshalYAML unmarshals a quoted yaml string to the Fault enum.
+func (f *Fault) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var j string
+	err := unmarshal(&j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := faultToEnum[j]
+	if !ok {
+		return fmt.Errorf("couldn't find matching Fault enum value <%s>", j)
+	}
+
+	*f = result
+	return nil
+}
+
+// TransactionState represents the state of a transaction.
+type TransactionState uint
+
+const (
+	// TransactionState_Authorised represents an authorised transaction.
+	TransactionState_Authorised TransactionState = iota + 1
+	// TransactionState_Captured represents a captured transaction.
+	TransactionState_Captured
+	// TransactionState_PartiallyRefunded represents a captured transaction that has been partially refunded.
+	TransactionState_PartiallyRefunded
+	// TransactionState_Refunded represents a captured transaction that has been fully refunded.
+	TransactionState_Refunded
+	// TransactionState_Voided represents a voided transaction.
+	TransactionState_Voided
+	// TransactionState_Expired represents an authorisation that expired before being captured or voided.
+	TransactionState_Expired
+	// TransactionState_PendingSettlement represents a capture accepted but not settled yet.
+	TransactionState_PendingSettlement
+	// TransactionState_SettlementFailed represents a capture that failed to settle, no money was moved.
+	TransactionState_SettlementFailed
+)
+
+// String returns the string representation of TransactionState.
+func (ts TransactionState) String() string {
+	return [...]string{"", "authorised", "captured", "partially refunded", "refunded", "voided", "expired",
+		"pending_settlement", "settlement_failed"}[ts]
+}
+
+var transactionStateToEnum = map[string]TransactionState{
+	"authorised":         TransactionState_Authorised,
+	"captured":           TransactionState_Captured,
+	"partially refunded": TransactionState_PartiallyRefunded,
+	"refunded":           TransactionState_Refunded,
+	"voided":             TransactionState_Voided,
+	"expired":            TransactionState_Expired,
+	"pending_settlement": TransactionState_PendingSettlement,
+	"settlement_failed":  TransactionState_SettlementFailed,
+}
+
+// Load loads a state into TransactionState.
+func (ts *TransactionState) Load(state string) error {
+	if stateEnum, ok := transactionStateToEnum[state]; ok {
+		*ts = stateEnum
+		return nil
+	}
+	return fmt.Errorf("unknown transaction state <%s>", state)
+}
+
+// MarshalJSON marshals the TransactionState enum to a quoted json string.
+func (ts TransactionState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ts.String())
+}
+
+// UnmarshalJSON unmarshals a quoted json string to the TransactionState enum.
+func (ts *TransactionState) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := transactionStateToEnum[j]
+	if !ok {
+		return errors.New("couldn't find matching TransactionState enum value")
+	}
+
+	*ts = result
+	return nil
+}
+
+// TransactionEventType represents the kind of operation that changed a transaction.
+type TransactionEventType uint
+
+const (
+	// TransactionEventType_Authorised represents the authorisation creating the transaction.
+	TransactionEventType_Authorised TransactionEventType = iota + 1
+	// TransactionEventType_Captured represents a capture.
+	TransactionEventType_Captured
+	// TransactionEventType_Refunded represents a refund, either partial or full.
+	TransactionEventType_Refunded
+	// TransactionEventType_Voided represents a void.
+	TransactionEventType_Voided
+	// TransactionEventType_Expired represents an authorisation expiring before being captured or voided.
+	TransactionEventType_Expired
+	// TransactionEventType_Settled represents a capture settling.
+	TransactionEventType_Settled
+	// TransactionEventType_SettlementFailed represents a capture failing to settle.
+	TransactionEventType_SettlementFailed
+)
+
+// String returns the string representation of TransactionEventType.
+func (tet TransactionEventType) String() string {
+	return [...]string{"", "authorised", "captured", "refunded", "voided", "expired", "settled", "settlement_failed"}[tet]
+}
+
+var transactionEventTypeToEnum = map[string]TransactionEventType{
+	"authorised":        TransactionEventType_Authorised,
+	"captured":          TransactionEventType_Captured,
+	"refunded":          TransactionEventType_Refunded,
+	"voided":            TransactionEventType_Voided,
+	"expired":           TransactionEventType_Expired,
+	"settled":           TransactionEventType_Settled,
+	"settlement_failed": TransactionEventType_SettlementFailed,
+}
+
+// MarshalJSON marshals the TransactionEventType enum to a quoted json string.
+func (tet TransactionEventType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tet.String())
+}
+
+// UnmarshalJSON unmarshals a quoted json string to the TransactionEventType enum.
+func (tet *TransactionEventType) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := transactionEventTypeToEnum[j]
+	if !ok {
+		return errors.New("couldn't find matching TransactionEventType enum value")
+	}
+
+	*tet = result
+	return nil
+}
+
+// WebhookDeliveryStatus represents the status of the delivery of a webhook to an endpoint.
+type WebhookDeliveryStatus uint
+
+const (
+	// WebhookDeliveryStatus_Pending represents a webhook not delivered yet, to be attempted again.
+	WebhookDeliveryStatus_Pending WebhookDeliveryStatus = iota + 1
+	// WebhookDeliveryStatus_Delivered represents a webhook acknowledged by the endpoint.
+	WebhookDeliveryStatus_Delivered
+	// WebhookDeliveryStatus_Failed represents a webhook given up on after too many attempts.
+	WebhookDeliveryStatus_Failed
+)
+
+// String returns the string representation of WebhookDeliveryStatus.
+func (wds WebhookDeliveryStatus) String() string {
+	return [...]string{"", "pending", "delivered", "failed"}[wds]
+}
+
+var webhookDeliveryStatusToEnum = map[string]WebhookDeliveryStatus{
+	"pending":   WebhookDeliveryStatus_Pending,
+	"delivered": WebhookDeliveryStatus_Delivered,
+	"failed":    WebhookDeliveryStatus_Failed,
+}
+
+// Load loads a status into WebhookDeliveryStatus.
+func (wds *WebhookDeliveryStatus) Load(status string) error {
+	if statusEnum, ok := webhookDeliveryStatusToEnum[status]; ok {
+		*wds = statusEnum
+		return nil
+	}
+	return fmt.Errorf("unknown webhook delivery status <%s>", status)
+}
+
+// MarshalJSON marshals the WebhookDeliveryStatus enum to a quoted json string.
+func (wds WebhookDeliveryStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(wds.String())
+}
+
+// UnmarshalJSON unmarshals a quoted json string to the WebhookDeliveryStatus enum.
+func (wds *WebhookDeliveryStatus) UnmarshalJSON(b []byte) error {
+	var j string
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	result, ok := webhookDeliveryStatusToEnum[j]
+	if !ok {
+		return errors.New("couldn't find matching WebhookDeliveryStatus enum value")
+	}
+
+	*wds = result
+	return nil
+}
+
+// ResultCode represents the result of an operation as reported in the response body.
+type ResultCode uint
+
+const (
+	// ResultCode_Success represents a successful operation.
+	ResultCode_Success ResultCode = iota + 1
+	// ResultCode_Fail represents an operation declined for the credit card.
+	ResultCode_Fail
+	// ResultCode_TransactionVoided represents an operation on a voided transaction.
+	ResultCode_TransactionVoided
+	// ResultCode_TransactionAlreadyCaptured represents a capture or void on a captured transaction.
+	ResultCode_TransactionAlreadyCaptured
+	// ResultCode_TransactionNotCaptured represents a refund on a transaction that was never captured.
+	ResultCode_TransactionNotCaptured
+	// ResultCode_TransactionFullyRefunded represents a refund on a fully refunded transaction.
+	ResultCode_TransactionFullyRefunded
+	// ResultCode_AmountExceedsAuthorised represents a capture above the authorised amount.
+	ResultCode_AmountExceedsAuthorised
+	// ResultCode_AmountExceedsCaptured represents a refund above the captured amount not yet refunded.
+	ResultCode_AmountExceedsCaptured
+	// ResultCode_AuthorisationExpired represents an operation on an expired authorisation.
+	ResultCode_AuthorisationExpired
+	// ResultCode_AuthorisationNotFound represents an operation on an unknown authorisation.
+	ResultCode_AuthorisationNotFound
+	// ResultCode_CurrencyNotAccepted represents an authorisation in a currency the processor doesn't accept.
+	ResultCode_CurrencyNotAccepted
+	// ResultCode_InvalidCardNumber represents an authorisation with a card number failing the Luhn check.
+	ResultCode_InvalidCardNumber
+	// ResultCode_CardExpired represents an authorisation with a card past its expiry date.
+	ResultCode_CardExpired
+	// ResultCode_InvalidCVV represents an authorisation with a CVV of the wrong length for the card.
+	ResultCode_InvalidCVV
+	// ResultCode_TransactionNotSettled represents a refund on a capture still pending settlement.
+	ResultCode_TransactionNotSettled
+	// ResultCode_SettlementFailed represents an operation on a capture that failed to settle.
+	ResultCode_SettlementFailed
+)
+
+// ResultCodeFromError returns the ResultCode matching the error returned by a transaction state change
+// or by the credit card validation.
+// It returns false if the error isn't one of those errors, e.g. a storage failure.
+func ResultCodeFromError(err error) (code ResultCode, ok bool) {
+	switch {
+	case err == nil:
+		return ResultCode_Success, true
+	case errors.Is(err, ErrTransactionVoided):
+		return ResultCode_TransactionVoided, true
+	case errors.Is(err, ErrTransactionAlreadyCaptured):
+		return ResultCode_TransactionAlreadyCaptured, true
+	case errors.Is(err, ErrTransactionNotCaptured):
+		return ResultCode_TransactionNotCaptured, true
+	case errors.Is(err, ErrTransactionFullyRefunded):
+		return ResultCode_TransactionFullyRefunded, true
+	case errors.Is(err, ErrAmountExceedsAuthorised):
+		return ResultCode_AmountExceedsAuthorised, true
+	case errors.Is(err, ErrAmountExceedsCaptured):
+		return ResultCode_AmountExceedsCaptured, true
+	case errors.Is(err, ErrAuthorisationExpired):
+		return ResultCode_AuthorisationExpired, true
+	case errors.Is(err, ErrAuthorisationNotFound):
+		return ResultCode_AuthorisationNotFound, true
+	case errors.Is(err, ErrInvalidCardNumber):
+		return ResultCode_InvalidCardNumber, true
+	case errors.Is(err, ErrCardExpired):
+		return ResultCode_CardExpired, true
+	case errors.Is(err, ErrInvalidCVV):
+		return ResultCode_InvalidCVV, true
+	case errors.Is(err, ErrTransactionNotSettled):
+		return ResultCode_TransactionNotSettled, true
+	case errors.Is(err, ErrSettlementFailed):
+		return ResultCode_SettlementFailed, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,16 @@
+package core
+
+import "errors"
+
+// Errors returned when an idempotency key can't be used for a request.
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key has already been used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with the same idempotency key is still in progress")
+)
+
+// IdempotentResponse holds the response to a request carrying an idempotency key, so it can be replayed.
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
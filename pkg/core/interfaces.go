@@ -1,18 +1,127 @@
 package core
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // CreditCardChecker represents a database holding credentials
 type CreditCardChecker interface {
-	ShouldFail(ccNumber int64, reason CCFailReason) bool
+	// Evaluate returns the outcome of the operation on the credit card.
+	// It returns false if the operation should be processed normally.
+	Evaluate(request OperationRequest) (outcome Outcome, ok bool)
 }
 
+// Loader represents anything loaded from the content of a file, like the credit cards file.
+type Loader interface {
+	// Load replaces what's loaded with the data, leaving it untouched if the data is invalid.
+	Load(data []byte) error
+}
+
+// MagicAmountLister represents anything holding a table of magic amounts, reported to the callers.
+type MagicAmountLister interface {
+	MagicAmounts() []MagicAmount
+}
+
+// LatencyProvider represents anything setting the latency of the endpoints.
+// Latencies are drawn from the random source of the provider, so they can be reproduced.
+type LatencyProvider interface {
+	// EndpointLatency returns a latency drawn for the endpoint of the operation, or false if it responds instantly.
+	EndpointLatency(operation CCFailReason) (latency time.Duration, ok bool)
+	// ResponseDelay returns how long to wait before responding with the outcome, drawn from its latency if set.
+	ResponseDelay(outcome Outcome) time.Duration
+}
+
+// CardFailuresStore represents a database of the operations failing on each credit card, managed at runtime.
+// PutCardFailures and DeleteCardFailures only return an error if the change couldn't be persisted,
+// in which case nothing is changed.
+type CardFailuresStore interface {
+	ListCardFailures() map[int64]CardFailures
+	GetCardFailures(number int64) (failures CardFailures, ok bool)
+	// PutCardFailures replaces the failures of the credit card, returning true if it wasn't held before.
+	PutCardFailures(number int64, failures CardFailures) (created bool, err error)
+	// DeleteCardFailures removes the failures of the credit card, returning false if it wasn't held.
+	DeleteCardFailures(number int64) (ok bool, err error)
+}
+
+// Authoriser represents a database holding authorisations and their state.
+//
+// Errors other than the ones below are failures of the underlying storage.
+// Capture, Void and Refund return ErrAuthorisationNotFound if the UID is unknown,
+// or one of the transaction errors if the operation is not allowed in the current state.
+// Capture and Refund also return a copy of the transaction as it stands after the operation.
 type Authoriser interface {
-	Authorise(ccNumber int64) (uid string)
-	GetAssociatedCreditCard(uid string) (ccNumber int64, ok bool)
+	Authorise(card CreditCard, amount Money) (uid string, err error)
+	GetAssociatedCreditCard(uid string) (ccNumber int64, ok bool, err error)
+	GetTransaction(uid string) (tx Transaction, ok bool, err error)
+	Capture(uid string, amount Money) (tx Transaction, err error)
+	Void(uid string) error
+	Refund(uid string, amount Money) (tx Transaction, err error)
+}
+
+// TransactionLister represents a database of authorisations that can be searched.
+type TransactionLister interface {
+	// ListTransactions returns up to limit transactions matching the filter, newest first, starting after the cursor
+	// if set. It returns the cursor of the next page, or nil if there are no more transactions.
+	ListTransactions(filter TransactionFilter, after *TransactionCursor, limit int) (page []ListedTransaction, next *TransactionCursor, err error)
+}
+
+// Settler represents a database of authorisations whose captures can settle asynchronously.
+// Settle and FailSettlement return ErrTransactionNotPendingSettlement if the transaction isn't pending settlement,
+// and like Authoriser, they return a copy of the transaction as it stands after the operation.
+type Settler interface {
+	// CaptureForSettlement captures the transaction like Authoriser.Capture, leaving it pending settlement until settleAt.
+	CaptureForSettlement(uid string, amount Money, settleAt time.Time) (tx Transaction, err error)
+	// DueSettlements returns the transactions pending settlement due at the provided time, the ones due first first.
+	DueSettlements(now time.Time) (due []ListedTransaction, err error)
+	Settle(uid string) (tx Transaction, err error)
+	FailSettlement(uid string, reason DeclineReason) (tx Transaction, err error)
+}
+
+// Notifier represents anything notified of the operations changing a transaction, like webhooks.
+type Notifier interface {
+	// Notify notifies the event, the transaction being as it stands after it.
+	Notify(uid string, tx Transaction, event TransactionEvent)
+}
+
+// WebhookStore represents a database of webhook deliveries.
+// Pending deliveries are queued until they are delivered or failed, and then kept as a log.
+type WebhookStore interface {
+	Add(deliveries []WebhookDelivery) error
+	// Due returns the pending deliveries due at the provided time, the oldest first.
+	Due(now time.Time) ([]WebhookDelivery, error)
+	// Update replaces the delivery, e.g. after an attempt.
+	Update(delivery WebhookDelivery) error
+	WebhookDeliveryLister
+}
+
+// WebhookDeliveryLister represents anything holding a log of the webhook deliveries, reported to the callers.
+type WebhookDeliveryLister interface {
+	// ListDeliveries returns the deliveries, the newest first.
+	ListDeliveries() ([]WebhookDelivery, error)
+}
+
+// IdempotencyStore represents a database holding the responses to requests carrying an idempotency key.
+type IdempotencyStore interface {
+	// Begin reserves the key for the request identified by the fingerprint.
+	// If the key has already been used for the same request, it returns the stored response.
+	// It returns ErrIdempotencyKeyReused if the key has been used for a different request,
+	// and ErrIdempotencyKeyInProgress if the first request hasn't completed yet.
+	Begin(key string, fingerprint string) (response *IdempotentResponse, err error)
+	// Complete stores the response to the request the key was reserved for.
+	Complete(key string, response IdempotentResponse)
+	// Cancel releases the key without storing a response, so the request can be retried.
+	Cancel(key string)
 }
 
 // ShutDowner represents anything that can be shutdown like an HTTP server.
 type ShutDowner interface {
 	ShutDown(ctx context.Context) error
 }
+
+// Expirer represents anything holding entries that go stale over time, like authorisations.
+type Expirer interface {
+	// ExpireStale expires the entries that are stale at the provided time and returns how many were affected.
+	// Entries that can't be read are skipped and reported in the error, the others are still expired.
+	ExpireStale(now time.Time) (count int, err error)
+}
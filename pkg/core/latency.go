@@ -0,0 +1,209 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency is a distribution of response times.
+//
+// In the yaml file it's either a fixed duration, or one of the following distributions:
+//
+//	latency: "200ms"
+//	latency: {uniform: {min: "100ms", max: "300ms"}}
+//	latency: {normal: {mean: "200ms", stddev: "50ms"}}
+//	latency: {percentiles: {p50: "100ms", p90: "300ms", p99: "2s", p100: "10s"}}
+//
+// Percentiles describe long tails: response times are spread evenly between two consecutive percentiles,
+// starting from zero unless p0 is set, and never exceed the highest percentile set.
+type Latency struct {
+	kind latencyKind
+
+	// fixed, min and max of uniform, mean of normal
+	first, second time.Duration
+	// percentiles, sorted by quantile
+	percentiles []percentile
+}
+
+type latencyKind uint
+
+const (
+	latencyFixed latencyKind = iota
+	latencyUniform
+	latencyNormal
+	latencyPercentiles
+)
+
+// percentile holds the duration a quantile, between 0 and 1, of the response times are below of.
+type percentile struct {
+	quantile float64
+	duration time.Duration
+}
+
+// FixedLatency returns a Latency always of the provided duration.
+func FixedLatency(d time.Duration) Latency {
+	return Latency{kind: latencyFixed, first: d}
+}
+
+// UnmarshalYAML unmarshals either a quoted yaml duration or a mapping with a single distribution to a Latency.
+func (l *Latency) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fixed time.Duration
+	if err := unmarshal(&fixed); err == nil {
+		if fixed < 0 {
+			return fmt.Errorf("negative latency <%s>", fixed)
+		}
+		*l = FixedLatency(fixed)
+		return nil
+	}
+
+	var raw struct {
+		Uniform *struct {
+			Min time.Duration `yaml:"min"`
+			Max time.Duration `yaml:"max"`
+		} `yaml:"uniform"`
+		Normal *struct {
+			Mean   time.Duration `yaml:"mean"`
+			StdDev time.Duration `yaml:"stddev"`
+		} `yaml:"normal"`
+		Percentiles map[string]time.Duration `yaml:"percentiles"`
+	}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	var result Latency
+	distributions := 0
+	if raw.Uniform != nil {
+		distributions++
+		if raw.Uniform.Min < 0 || raw.Uniform.Max < raw.Uniform.Min {
+			return fmt.Errorf("invalid uniform latency between <%s> and <%s>", raw.Uniform.Min, raw.Uniform.Max)
+		}
+		result = Latency{kind: latencyUniform, first: raw.Uniform.Min, second: raw.Uniform.Max}
+	}
+	if raw.Normal != nil {
+		distributions++
+		if raw.Normal.Mean < 0 || raw.Normal.StdDev < 0 {
+			return fmt.Errorf("invalid normal latency of mean <%s> and standard deviation <%s>", raw.Normal.Mean, raw.Normal.StdDev)
+		}
+		result = Latency{kind: latencyNormal, first: raw.Normal.Mean, second: raw.Normal.StdDev}
+	}
+	if raw.Percentiles != nil {
+		distributions++
+		if result.percentiles, err = parsePercentiles(raw.Percentiles); err != nil {
+			return err
+		}
+		result.kind = latencyPercentiles
+	}
+	if distributions != 1 {
+		return errors.New("latency must be a duration or exactly one of uniform, normal or percentiles")
+	}
+
+	*l = result
+	return nil
+}
+
+// String returns the distribution in a form close to the yaml one, e.g. "uniform(100ms, 300ms)".
+func (l Latency) String() string {
+	switch l.kind {
+	case latencyUniform:
+		return fmt.Sprintf("uniform(%s, %s)", l.first, l.second)
+	case latencyNormal:
+		return fmt.Sprintf("normal(%s, %s)", l.first, l.second)
+	case latencyPercentiles:
+		items := make([]string, 0, len(l.percentiles))
+		for _, p := range l.percentiles {
+			items = append(items, fmt.Sprintf("p%g: %s", p.quantile*100, p.duration))
+		}
+		return "percentiles(" + strings.Join(items, ", ") + ")"
+	default:
+		return l.first.String()
+	}
+}
+
+// Random is a random source safe for concurrent use, seeded so the latencies drawn from it can be reproduced.
+type Random struct {
+	// mu guards random, which isn't safe for concurrent use
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// NewRandom creates a new Random seeded with the provided seed.
+func NewRandom(seed int64) *Random {
+	return &Random{random: rand.New(rand.NewSource(seed))}
+}
+
+// Sample returns a response time drawn from the distribution with the provided random source.
+func (l Latency) Sample(random *Random) time.Duration {
+	if l.kind == latencyFixed {
+		return l.first
+	}
+
+	random.mu.Lock()
+	defer random.mu.Unlock()
+
+	switch l.kind {
+	case latencyUniform:
+		return l.first + time.Duration(random.random.Int63n(int64(l.second-l.first)+1))
+	case latencyNormal:
+		d := l.first + time.Duration(random.random.NormFloat64()*float64(l.second))
+		if d < 0 {
+			return 0
+		}
+		return d
+	default:
+		return l.samplePercentiles(random.random.Float64())
+	}
+}
+
+// samplePercentiles returns the response time of the quantile q, interpolated between the percentiles around it.
+func (l Latency) samplePercentiles(q float64) time.Duration {
+	lower := percentile{}
+	for _, upper := range l.percentiles {
+		if q <= upper.quantile {
+			if upper.quantile == lower.quantile {
+				return upper.duration
+			}
+			fraction := (q - lower.quantile) / (upper.quantile - lower.quantile)
+			return lower.duration + time.Duration(fraction*float64(upper.duration-lower.duration))
+		}
+		lower = upper
+	}
+	return lower.duration
+}
+
+// parsePercentiles parses percentiles like {p50: "100ms", p99.9: "2s"}, checking durations grow with them.
+func parsePercentiles(raw map[string]time.Duration) ([]percentile, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("percentiles latency without percentiles")
+	}
+
+	result := make([]percentile, 0, len(raw))
+	for key, duration := range raw {
+		value, err := strconv.ParseFloat(strings.TrimPrefix(key, "p"), 64)
+		if err != nil || !strings.HasPrefix(key, "p") || value < 0 || value > 100 {
+			return nil, fmt.Errorf("invalid percentile <%s>, expected p0 to p100", key)
+		}
+		result = append(result, percentile{quantile: value / 100, duration: duration})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].quantile < result[j].quantile
+	})
+
+	var previous time.Duration
+	for _, p := range result {
+		if p.duration < previous {
+			return nil, fmt.Errorf("percentile p%g of <%s> is lower than the ones before", p.quantile*100, p.duration)
+		}
+		previous = p.duration
+	}
+
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package core_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLatencyUnmarshal(t *testing.T) {
+	tests := map[string]struct {
+		input          string
+		expectedString string
+		expectedErr    bool
+	}{
+		"fixed":                       {input: `"200ms"`, expectedString: "200ms"},
+		"uniform":                     {input: `{uniform: {min: "100ms", max: "300ms"}}`, expectedString: "uniform(100ms, 300ms)"},
+		"normal":                      {input: `{normal: {mean: "200ms", stddev: "50ms"}}`, expectedString: "normal(200ms, 50ms)"},
+		"percentiles":                 {input: `{percentiles: {p50: "100ms", p99.9: "2s", p100: "5s"}}`, expectedString: "percentiles(p50: 100ms, p99.9: 2s, p100: 5s)"},
+		"negative fixed":              {input: `"-1s"`, expectedErr: true},
+		"invalid duration":            {input: `"soon"`, expectedErr: true},
+		"uniform upside down":         {input: `{uniform: {min: "300ms", max: "100ms"}}`, expectedErr: true},
+		"negative standard deviation": {input: `{normal: {mean: "200ms", stddev: "-50ms"}}`, expectedErr: true},
+		"empty percentiles":           {input: `{percentiles: {}}`, expectedErr: true},
+		"invalid percentile":          {input: `{percentiles: {median: "100ms"}}`, expectedErr: true},
+		"percentile above 100":        {input: `{percentiles: {p101: "100ms"}}`, expectedErr: true},
+		"percentiles decreasing":      {input: `{percentiles: {p50: "1s", p90: "100ms"}}`, expectedErr: true},
+		"no distribution":             {input: `{}`, expectedErr: true},
+		"several distributions":       {input: `{uniform: {min: "1s", max: "2s"}, normal: {mean: "1s"}}`, expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var latency core.Latency
+			err := yaml.Unmarshal([]byte(test.input), &latency)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedString, latency.String())
+		})
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	tests := map[string]struct {
+		input string
+		// check is called with the samples sorted
+		check func(t *testing.T, samples []time.Duration)
+	}{
+		"fixed": {
+			input: `"200ms"`,
+			check: func(t *testing.T, samples []time.Duration) {
+				assert.Equal(t, 200*time.Millisecond, samples[0])
+				assert.Equal(t, 200*time.Millisecond, samples[len(samples)-1])
+			},
+		},
+		"uniform": {
+			input: `{uniform: {min: "100ms", max: "300ms"}}`,
+			check: func(t *testing.T, samples []time.Duration) {
+				assert.GreaterOrEqual(t, int64(samples[0]), int64(100*time.Millisecond))
+				assert.LessOrEqual(t, int64(samples[len(samples)-1]), int64(300*time.Millisecond))
+				assert.InDelta(t, int64(200*time.Millisecond), int64(samples[len(samples)/2]), float64(20*time.Millisecond))
+			},
+		},
+		"normal": {
+			input: `{normal: {mean: "50ms", stddev: "50ms"}}`,
+			check: func(t *testing.T, samples []time.Duration) {
+				assert.Equal(t, time.Duration(0), samples[0])
+				assert.InDelta(t, int64(50*time.Millisecond), int64(samples[len(samples)/2]), float64(10*time.Millisecond))
+			},
+		},
+		"percentiles": {
+			input: `{percentiles: {p0: "10ms", p50: "100ms", p90: "300ms", p100: "2s"}}`,
+			check: func(t *testing.T, samples []time.Duration) {
+				assert.GreaterOrEqual(t, int64(samples[0]), int64(10*time.Millisecond))
+				assert.LessOrEqual(t, int64(samples[len(samples)-1]), int64(2*time.Second))
+				assert.InDelta(t, int64(100*time.Millisecond), int64(samples[len(samples)*50/100]), float64(10*time.Millisecond))
+				assert.InDelta(t, int64(250*time.Millisecond), int64(samples[len(samples)*80/100]), float64(20*time.Millisecond))
+			},
+		},
+		"percentiles up to p99": {
+			input: `{percentiles: {p99: "1s"}}`,
+			check: func(t *testing.T, samples []time.Duration) {
+				assert.Equal(t, time.Second, samples[len(samples)-1])
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var latency core.Latency
+			err := yaml.Unmarshal([]byte(test.input), &latency)
+			require.NoError(t, err)
+
+			random := core.NewRandom(42)
+			samples := make([]time.Duration, 10000)
+			for i := range samples {
+				samples[i] = latency.Sample(random)
+			}
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+			test.check(t, samples)
+		})
+	}
+}
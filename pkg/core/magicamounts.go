@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// MagicAmount sets the outcome of the authorisations, captures and refunds of an amount, whatever the card.
+// Settlements are only matched when listed in the operations.
+//
+// In the yaml file, the amount is either an exact amount in major units, or the end of the amount
+// as formatted with the decimal places of its currency:
+//
+//	magicAmounts:
+//	  - amount: "100.51"
+//	    outcome: "error"
+//	    httpStatus: 503
+//	  - endsWith: ".05"
+//	    operations: ["authorise"]
+//	    currencies: ["EUR", "GBP"]
+//	    outcome: "decline"
+//	    reason: "insufficient_funds"
+type MagicAmount struct {
+	Amount     string         `yaml:"amount"`
+	EndsWith   string         `yaml:"endsWith"`
+	Operations []CCFailReason `yaml:"operations"`
+	Currencies []string       `yaml:"currencies"`
+	Outcome    `yaml:",inline"`
+
+	// amount is the parsed Amount, nil when matching on EndsWith
+	amount *big.Rat
+}
+
+// UnmarshalYAML unmarshals a yaml mapping to a MagicAmount, validating its amount and outcome.
+func (ma *MagicAmount) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// Alias the type so the mapping is decoded without calling this method again
+	type magicAmount MagicAmount
+	var result magicAmount
+	err := unmarshal(&result)
+	if err != nil {
+		return err
+	}
+
+	name := result.Amount + result.EndsWith
+	switch {
+	case result.Amount != "" && result.EndsWith != "":
+		return fmt.Errorf("magic amount <%s>: both amount and endsWith set", name)
+	case result.Amount != "":
+		if result.amount, err = parseDecimal(result.Amount); err != nil {
+			return fmt.Errorf("magic amount <%s>: %w", name, err)
+		}
+	case result.EndsWith == "":
+		return errors.New("magic amount: missing amount or endsWith")
+	}
+
+	if containsOperation(result.Operations, CCFailReason_Void) {
+		return fmt.Errorf("magic amount <%s>: voids can't be matched on the amount", name)
+	}
+
+	if err := result.Outcome.validate(); err != nil {
+		return fmt.Errorf("magic amount <%s>: %w", name, err)
+	}
+
+	*ma = MagicAmount(result)
+	return nil
+}
+
+// Matches returns true if the operation is of the magic amount.
+// Voids never match, as their amount isn't chosen by the caller, and settlements only match
+// when listed in the operations, as they follow a capture already matched on the same amount.
+func (ma MagicAmount) Matches(request OperationRequest) bool {
+	if request.Operation == CCFailReason_Void {
+		return false
+	}
+	if len(ma.Operations) == 0 {
+		if request.Operation == CCFailReason_Settle {
+			return false
+		}
+	} else if !containsOperation(ma.Operations, request.Operation) {
+		return false
+	}
+	if len(ma.Currencies) > 0 && !containsString(ma.Currencies, request.Amount.Currency) {
+		return false
+	}
+
+	if ma.amount != nil {
+		return majorUnits(request.Amount).Cmp(ma.amount) == 0
+	}
+	return strings.HasSuffix(request.Amount.String(), ma.EndsWith)
+}
+
+// EvaluateMagicAmounts returns the outcome of the first magic amount matching the operation.
+// It returns false if no magic amount matches.
+func EvaluateMagicAmounts(magicAmounts []MagicAmount, request OperationRequest) (outcome Outcome, ok bool) {
+	for _, magicAmount := range magicAmounts {
+		if magicAmount.Matches(request) {
+			return magicAmount.Outcome, true
+		}
+	}
+	return Outcome{}, false
+}
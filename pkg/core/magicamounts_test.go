@@ -0,0 +1,112 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMagicAmountUnmarshal(t *testing.T) {
+	tests := map[string]struct {
+		input       string
+		expectedErr bool
+	}{
+		"exact amount":            {input: `{amount: "100.51", outcome: "decline"}`},
+		"end of amount":           {input: `{endsWith: ".05", outcome: "decline"}`},
+		"missing amount":          {input: `{outcome: "decline"}`, expectedErr: true},
+		"both amounts":            {input: `{amount: "100.05", endsWith: ".05", outcome: "decline"}`, expectedErr: true},
+		"invalid amount":          {input: `{amount: "ten", outcome: "decline"}`, expectedErr: true},
+		"exponent amount":         {input: `{amount: "1e3", outcome: "decline"}`, expectedErr: true},
+		"void operation":          {input: `{amount: "100.51", operations: ["void"], outcome: "decline"}`, expectedErr: true},
+		"missing outcome":         {input: `{amount: "100.51"}`, expectedErr: true},
+		"error with an ok status": {input: `{amount: "100.51", outcome: "error", httpStatus: 204}`, expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var magicAmount core.MagicAmount
+			err := yaml.Unmarshal([]byte(test.input), &magicAmount)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestEvaluateMagicAmounts(t *testing.T) {
+	input := `
+- amount: "100.51"
+  outcome: "error"
+  httpStatus: 503
+- endsWith: ".05"
+  operations: ["authorise", "refund"]
+  currencies: ["EUR"]
+  outcome: "decline"
+  reason: "insufficient_funds"
+- amount: "7.77"
+  operations: ["settle"]
+  outcome: "decline"
+  reason: "insufficient_funds"
+`
+	var magicAmounts []core.MagicAmount
+	err := yaml.Unmarshal([]byte(input), &magicAmounts)
+	require.NoError(t, err)
+
+	card := core.CreditCard{Number: 4000000000000001}
+	declined := core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_InsufficientFunds}
+
+	tests := map[string]struct {
+		request         core.OperationRequest
+		expectedResult  bool
+		expectedOutcome core.Outcome
+	}{
+		"exact amount": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Capture, Card: card, Amount: eur(10051)},
+			expectedResult:  true,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 503},
+		},
+		"exact amount in another currency": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Capture, Card: card, Amount: core.Money{MinorUnits: 100510, Currency: "KWD"}},
+			expectedResult:  true,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 503},
+		},
+		"end of amount": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: card, Amount: eur(1205)},
+			expectedResult:  true,
+			expectedOutcome: declined,
+		},
+		"end of amount for another operation": {
+			request: core.OperationRequest{Operation: core.CCFailReason_Capture, Card: card, Amount: eur(1205)},
+		},
+		"end of amount in another currency": {
+			request: core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: card, Amount: core.Money{MinorUnits: 1205, Currency: "GBP"}},
+		},
+		"void of a magic amount": {
+			request: core.OperationRequest{Operation: core.CCFailReason_Void, Card: card, Amount: eur(10051)},
+		},
+		"settle of an amount without operations": {
+			request: core.OperationRequest{Operation: core.CCFailReason_Settle, Card: card, Amount: eur(10051)},
+		},
+		"settle of an amount with settle listed": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Settle, Card: card, Amount: eur(777)},
+			expectedResult:  true,
+			expectedOutcome: declined,
+		},
+		"other amount": {
+			request: core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: card, Amount: eur(1250)},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			outcome, ok := core.EvaluateMagicAmounts(magicAmounts, test.request)
+			assert.Equal(t, test.expectedResult, ok)
+			assert.Equal(t, test.expectedOutcome, outcome)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Errors returned when parsing an amount of money.
+var (
+	ErrInvalidAmount    = errors.New("amount is not a valid decimal number")
+	ErrAmountTooPrecise = errors.New("amount has more decimal places than the currency allows")
+)
+
+// ErrCurrencyMismatch is returned when an operation involves amounts of money in different currencies.
+var ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+
+// Money represents an exact amount of money in minor units (e.g. cents) of an ISO 4217 currency.
+type Money struct {
+	MinorUnits int64  `json:"minor_units"`
+	Currency   string `json:"currency"`
+}
+
+// ParseMoney parses a decimal amount in major units (e.g. "10.50") of the currency.
+// Amounts with more decimal places than the currency allows are rejected, e.g. "10.5" JPY or "1.2345" KWD,
+// although trailing zeros are ignored. The currency must be an ISO 4217 currency code.
+func ParseMoney(amount string, currency string) (Money, error) {
+	c, ok := LookupCurrency(currency)
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+	exponent := c.Exponent
+
+	negative, intPart, fracPart, ok := splitDecimal(amount)
+	if !ok {
+		return Money{}, ErrInvalidAmount
+	}
+
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > exponent {
+		return Money{}, ErrAmountTooPrecise
+	}
+	fracPart += strings.Repeat("0", exponent-len(fracPart))
+
+	minorUnits, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		minorUnits = -minorUnits
+	}
+
+	return Money{MinorUnits: minorUnits, Currency: currency}, nil
+}
+
+// splitDecimal splits a decimal number like "-10.50" into its sign, integer and fractional digits.
+// It returns false if the number isn't made of digits, with an optional sign and decimal point.
+func splitDecimal(s string) (negative bool, intPart string, fracPart string, ok bool) {
+	negative = strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+
+	intPart = s
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+		if fracPart == "" {
+			return false, "", "", false
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return false, "", "", false
+	}
+	return negative, intPart, fracPart, true
+}
+
+// String returns the amount as a decimal number in major units, e.g. "10.50".
+func (m Money) String() string {
+	exponent := CurrencyExponent(m.Currency)
+
+	minorUnits := m.MinorUnits
+	sign := ""
+	if minorUnits < 0 {
+		sign = "-"
+		minorUnits = -minorUnits
+	}
+
+	digits := strconv.FormatInt(minorUnits, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// IsPositive returns true if the amount is greater than zero.
+func (m Money) IsPositive() bool {
+	return m.MinorUnits > 0
+}
+
+// Add returns the sum of both amounts.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{MinorUnits: m.MinorUnits + other.MinorUnits, Currency: m.Currency}, nil
+}
+
+// Sub returns the difference between both amounts.
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{MinorUnits: m.MinorUnits - other.MinorUnits, Currency: m.Currency}, nil
+}
+
+// isDigits returns true if the string only holds decimal digits.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := map[string]struct {
+		amount        string
+		currency      string
+		expectedMoney core.Money
+		expectedErr   error
+	}{
+		"whole amount":                 {amount: "10", currency: "EUR", expectedMoney: core.Money{MinorUnits: 1000, Currency: "EUR"}},
+		"cents":                        {amount: "10.05", currency: "EUR", expectedMoney: core.Money{MinorUnits: 1005, Currency: "EUR"}},
+		"single decimal place":         {amount: "0.1", currency: "EUR", expectedMoney: core.Money{MinorUnits: 10, Currency: "EUR"}},
+		"inexact as float":             {amount: "0.29", currency: "USD", expectedMoney: core.Money{MinorUnits: 29, Currency: "USD"}},
+		"trailing zeros":               {amount: "10.5000", currency: "EUR", expectedMoney: core.Money{MinorUnits: 1050, Currency: "EUR"}},
+		"negative":                     {amount: "-1.50", currency: "EUR", expectedMoney: core.Money{MinorUnits: -150, Currency: "EUR"}},
+		"too precise":                  {amount: "10.005", currency: "EUR", expectedErr: core.ErrAmountTooPrecise},
+		"yen":                          {amount: "1050", currency: "JPY", expectedMoney: core.Money{MinorUnits: 1050, Currency: "JPY"}},
+		"yen with decimal places":      {amount: "10.5", currency: "JPY", expectedErr: core.ErrAmountTooPrecise},
+		"yen with zero decimal places": {amount: "10.0", currency: "JPY", expectedMoney: core.Money{MinorUnits: 10, Currency: "JPY"}},
+		"dinar":                        {amount: "1.234", currency: "KWD", expectedMoney: core.Money{MinorUnits: 1234, Currency: "KWD"}},
+		"dinar too precise":            {amount: "1.2345", currency: "KWD", expectedErr: core.ErrAmountTooPrecise},
+		"exponent":                     {amount: "1e2", currency: "EUR", expectedErr: core.ErrInvalidAmount},
+		"missing decimal places":       {amount: "10.", currency: "EUR", expectedErr: core.ErrInvalidAmount},
+		"missing whole part":           {amount: ".5", currency: "EUR", expectedErr: core.ErrInvalidAmount},
+		"empty":                        {amount: "", currency: "EUR", expectedErr: core.ErrInvalidAmount},
+		"overflow":                     {amount: "100000000000000000000", currency: "EUR", expectedErr: core.ErrInvalidAmount},
+		"unknown currency":             {amount: "10", currency: "EURO", expectedErr: core.ErrUnknownCurrency},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			money, err := core.ParseMoney(test.amount, test.currency)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedMoney, money)
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := map[string]struct {
+		money          core.Money
+		expectedString string
+	}{
+		"euros":        {money: core.Money{MinorUnits: 1050, Currency: "EUR"}, expectedString: "10.50"},
+		"cents":        {money: core.Money{MinorUnits: 5, Currency: "EUR"}, expectedString: "0.05"},
+		"zero":         {money: core.Money{MinorUnits: 0, Currency: "EUR"}, expectedString: "0.00"},
+		"negative":     {money: core.Money{MinorUnits: -150, Currency: "EUR"}, expectedString: "-1.50"},
+		"yen":          {money: core.Money{MinorUnits: 1050, Currency: "JPY"}, expectedString: "1050"},
+		"dinar":        {money: core.Money{MinorUnits: 1234, Currency: "KWD"}, expectedString: "1.234"},
+		"dinar fils":   {money: core.Money{MinorUnits: 7, Currency: "KWD"}, expectedString: "0.007"},
+		"dollar cents": {money: core.Money{MinorUnits: 29, Currency: "USD"}, expectedString: "0.29"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expectedString, test.money.String())
+
+			parsed, err := core.ParseMoney(test.money.String(), test.money.Currency)
+			require.NoError(t, err)
+			assert.Equal(t, test.money, parsed)
+		})
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	a := core.Money{MinorUnits: 1050, Currency: "EUR"}
+	b := core.Money{MinorUnits: 250, Currency: "EUR"}
+
+	sum, err := a.Add(b)
+	require.NoError(t, err)
+	assert.Equal(t, core.Money{MinorUnits: 1300, Currency: "EUR"}, sum)
+
+	diff, err := b.Sub(a)
+	require.NoError(t, err)
+	assert.Equal(t, core.Money{MinorUnits: -800, Currency: "EUR"}, diff)
+	assert.Equal(t, false, diff.IsPositive())
+
+	_, err = a.Add(core.Money{MinorUnits: 1, Currency: "GBP"})
+	require.ErrorIs(t, err, core.ErrCurrencyMismatch)
+}
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	bolt "go.etcd.io/bbolt"
+)
+
+// authorisationsBucket is the name of the bucket holding the authorisations.
+var authorisationsBucket = []byte("authorisations")
+
+// AuthoriserBoltStore keeps track of authorisations in a bbolt database file,
+// so that they survive restarts.
+//
+// Transactions are stored JSON encoded, keyed by UID.
+// Expiry works the same way as in AuthoriserInMemoryTracker.
+type AuthoriserBoltStore struct {
+	ttl time.Duration
+	db  *bolt.DB
+}
+
+// NewAuthoriserBoltStore opens (or creates) the database file and returns a new AuthoriserBoltStore.
+// A ttl of zero means authorisations never expire.
+func NewAuthoriserBoltStore(filename string, ttl time.Duration) (*AuthoriserBoltStore, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open authorisations database: %w", err)
+	}
+
+	err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(authorisationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create authorisations bucket: %w", err)
+	}
+
+	abs := AuthoriserBoltStore{ttl: ttl, db: db}
+	return &abs, nil
+}
+
+// Authorise generates a new UID and returns it.
+func (abs *AuthoriserBoltStore) Authorise(card core.CreditCard, amount core.Money) (uid string, err error) {
+	uid = uuid.NewString()
+	err = abs.Set(uid, core.NewTransaction(card, amount, time.Now(), abs.ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return uid, nil
+}
+
+// Set stores the transaction under the provided UID, replacing any existing one.
+func (abs *AuthoriserBoltStore) Set(uid string, tx core.Transaction) error {
+	return abs.db.Update(func(btx *bolt.Tx) error {
+		return putTransaction(btx.Bucket(authorisationsBucket), uid, tx)
+	})
+}
+
+// GetAssociatedCreditCard returns the credit card number used in the authorisation.
+func (abs *AuthoriserBoltStore) GetAssociatedCreditCard(uid string) (ccNumber int64, ok bool, err error) {
+	tx, ok, err := abs.GetTransaction(uid)
+	return tx.CCNumber, ok, err
+}
+
+// GetTransaction returns the transaction associated with the authorisation.
+func (abs *AuthoriserBoltStore) GetTransaction(uid string) (tx core.Transaction, ok bool, err error) {
+	err = abs.db.View(func(btx *bolt.Tx) error {
+		var err error
+		tx, ok, err = getTransaction(btx.Bucket(authorisationsBucket), uid)
+		return err
+	})
+	if err != nil {
+		return core.Transaction{}, false, err
+	}
+	return tx, ok, nil
+}
+
+// ListTransactions returns up to limit transactions matching the filter, newest first, starting after the cursor
+// if set. All the authorisations are scanned, there are no indexes.
+func (abs *AuthoriserBoltStore) ListTransactions(filter core.TransactionFilter, after *core.TransactionCursor, limit int) (
+	page []core.ListedTransaction, next *core.TransactionCursor, err error) {
+	var matching []core.ListedTransaction
+
+	err = abs.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(authorisationsBucket).ForEach(func(key, value []byte) error {
+			var tx core.Transaction
+			if err := json.Unmarshal(value, &tx); err != nil {
+				return fmt.Errorf("failed to decode authorisation <%s>: %w", key, err)
+			}
+			if filter.Matches(tx) {
+				matching = append(matching, core.ListedTransaction{UID: string(key), Transaction: tx})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	page, next = core.PageTransactions(matching, after, limit)
+	return page, next, nil
+}
+
+// DueSettlements returns the transactions pending settlement due at the provided time.
+// All the authorisations are scanned, there are no indexes.
+func (abs *AuthoriserBoltStore) DueSettlements(now time.Time) (due []core.ListedTransaction, err error) {
+	var pending []core.ListedTransaction
+
+	err = abs.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(authorisationsBucket).ForEach(func(key, value []byte) error {
+			var tx core.Transaction
+			if err := json.Unmarshal(value, &tx); err != nil {
+				return fmt.Errorf("failed to decode authorisation <%s>: %w", key, err)
+			}
+			if tx.State == core.TransactionState_PendingSettlement {
+				pending = append(pending, core.ListedTransaction{UID: string(key), Transaction: tx})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return core.DueSettlements(pending, now), nil
+}
+
+// Capture captures the authorised transaction.
+func (abs *AuthoriserBoltStore) Capture(uid string, amount core.Money) (tx core.Transaction, err error) {
+	return abs.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Capture(amount, time.Now())
+	})
+}
+
+// CaptureForSettlement captures the authorised transaction, leaving it pending settlement until settleAt.
+func (abs *AuthoriserBoltStore) CaptureForSettlement(uid string, amount core.Money, settleAt time.Time) (tx core.Transaction, err error) {
+	return abs.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.CaptureForSettlement(amount, time.Now(), settleAt)
+	})
+}
+
+// Settle settles the transaction pending settlement.
+func (abs *AuthoriserBoltStore) Settle(uid string) (tx core.Transaction, err error) {
+	return abs.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Settle(time.Now())
+	})
+}
+
+// FailSettlement fails the settlement of the transaction pending settlement.
+func (abs *AuthoriserBoltStore) FailSettlement(uid string, reason core.DeclineReason) (tx core.Transaction, err error) {
+	return abs.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.FailSettlement(reason, time.Now())
+	})
+}
+
+// Void voids the authorised transaction.
+func (abs *AuthoriserBoltStore) Void(uid string) error {
+	_, err := abs.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Void(time.Now())
+	})
+	return err
+}
+
+// Refund refunds the captured transaction.
+func (abs *AuthoriserBoltStore) Refund(uid string, amount core.Money) (tx core.Transaction, err error) {
+	return abs.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Refund(amount, time.Now())
+	})
+}
+
+// update applies the operation to the transaction within a read-write database transaction
+// and returns the transaction after the operation.
+// The transaction is written back even if the operation fails, as it might have expired in the meantime.
+func (abs *AuthoriserBoltStore) update(uid string, operation func(txPtr *core.Transaction) error) (tx core.Transaction, err error) {
+	var opErr error
+
+	err = abs.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(authorisationsBucket)
+
+		var ok bool
+		tx, ok, err = getTransaction(bucket, uid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			opErr = core.ErrAuthorisationNotFound
+			return nil
+		}
+
+		tx.Expire(time.Now())
+		opErr = operation(&tx)
+		return putTransaction(bucket, uid, tx)
+	})
+	if err != nil {
+		return core.Transaction{}, err
+	}
+
+	return tx, opErr
+}
+
+// ExpireStale expires the authorisations past their expiry time and removes the ones
+// that have been expired or voided for longer than the TTL.
+// It returns the number of authorisations expired or removed.
+// Authorisations that can't be decoded are skipped and reported in the error.
+func (abs *AuthoriserBoltStore) ExpireStale(now time.Time) (count int, err error) {
+	var undecodable []string
+
+	err = abs.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(authorisationsBucket)
+
+		// The bucket can't be modified while iterating over it, so collect the changes first
+		expired := make(map[string]core.Transaction)
+		var stale []string
+
+		err := bucket.ForEach(func(key, value []byte) error {
+			var tx core.Transaction
+			if err := json.Unmarshal(value, &tx); err != nil {
+				undecodable = append(undecodable, string(key))
+				return nil
+			}
+
+			if tx.Expire(now) {
+				expired[string(key)] = tx
+			} else if tx.Stale(now, abs.ttl) {
+				stale = append(stale, string(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for uid, tx := range expired {
+			if err := putTransaction(bucket, uid, tx); err != nil {
+				return err
+			}
+		}
+		for _, uid := range stale {
+			if err := bucket.Delete([]byte(uid)); err != nil {
+				return err
+			}
+		}
+
+		count = len(expired) + len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(undecodable) != 0 {
+		return count, fmt.Errorf("failed to decode authorisations <%s>", strings.Join(undecodable, ","))
+	}
+
+	return count, nil
+}
+
+// ShutDown closes the database.
+func (abs *AuthoriserBoltStore) ShutDown(ctx context.Context) error {
+	return abs.db.Close()
+}
+
+// getTransaction reads and decodes the transaction stored under the UID.
+func getTransaction(bucket *bolt.Bucket, uid string) (tx core.Transaction, ok bool, err error) {
+	value := bucket.Get([]byte(uid))
+	if value == nil {
+		return core.Transaction{}, false, nil
+	}
+
+	err = json.Unmarshal(value, &tx)
+	if err != nil {
+		return core.Transaction{}, false, fmt.Errorf("failed to decode authorisation <%s>: %w", uid, err)
+	}
+	return tx, true, nil
+}
+
+// putTransaction encodes and writes the transaction under the UID.
+func putTransaction(bucket *bolt.Bucket, uid string, tx core.Transaction) error {
+	value, err := json.Marshal(tx)
+	if err != nil {
+		return fmt.Errorf("failed to encode authorisation <%s>: %w", uid, err)
+	}
+	return bucket.Put([]byte(uid), value)
+}
@@ -0,0 +1,195 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStoreAuthorisationLifecycle(t *testing.T) {
+	auth, err := repository.NewAuthoriserBoltStore(filepath.Join(t.TempDir(), "auth.db"), time.Hour)
+	require.NoError(t, err)
+	defer auth.ShutDown(context.Background())
+
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+
+	number, ok, err := auth.GetAssociatedCreditCard(uid)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, int64(4000000000000119), number)
+
+	tx, err := auth.Capture(uid, eur(800))
+	require.NoError(t, err)
+	assert.Equal(t, eur(800), tx.RemainingBalance())
+	require.ErrorIs(t, auth.Void(uid), core.ErrTransactionAlreadyCaptured)
+
+	tx, err = auth.Refund(uid, eur(800))
+	require.NoError(t, err)
+	assert.Equal(t, core.TransactionState_Refunded, tx.State)
+
+	_, err = auth.Refund(uid, eur(100))
+	require.ErrorIs(t, err, core.ErrTransactionFullyRefunded)
+
+	_, err = auth.Capture("unknown", eur(1000))
+	assert.ErrorIs(t, err, core.ErrAuthorisationNotFound)
+	assert.ErrorIs(t, auth.Void("unknown"), core.ErrAuthorisationNotFound)
+}
+
+func TestBoltStoreSurvivesRestart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "auth.db")
+
+	auth, err := repository.NewAuthoriserBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	_, err = auth.Capture(uid, eur(1000))
+	require.NoError(t, err)
+	require.NoError(t, auth.ShutDown(context.Background()))
+
+	auth, err = repository.NewAuthoriserBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	defer auth.ShutDown(context.Background())
+
+	tx, ok, err := auth.GetTransaction(uid)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, core.TransactionState_Captured, tx.State)
+	assert.Equal(t, "EUR", tx.Currency())
+	assert.Equal(t, eur(1000), tx.CapturedAmount)
+}
+
+func TestBoltStoreExpireStale(t *testing.T) {
+	auth, err := repository.NewAuthoriserBoltStore(filepath.Join(t.TempDir(), "auth.db"), time.Hour)
+	require.NoError(t, err)
+	defer auth.ShutDown(context.Background())
+
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	capturedUID, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	_, err = auth.Capture(capturedUID, eur(1000))
+	require.NoError(t, err)
+	voidedUID, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	require.NoError(t, auth.Void(voidedUID))
+
+	assert.Equal(t, 0, expireStale(t, auth, time.Now()))
+
+	assert.Equal(t, 1, expireStale(t, auth, time.Now().Add(time.Hour+time.Minute)))
+	_, err = auth.Capture(uid, eur(1000))
+	assert.ErrorIs(t, err, core.ErrAuthorisationExpired)
+
+	// Expired and voided removed after another TTL, captured kept
+	assert.Equal(t, 2, expireStale(t, auth, time.Now().Add(2*time.Hour+time.Minute)))
+	_, ok, err := auth.GetTransaction(uid)
+	require.NoError(t, err)
+	assert.Equal(t, false, ok)
+	_, ok, err = auth.GetTransaction(voidedUID)
+	require.NoError(t, err)
+	assert.Equal(t, false, ok)
+	tx, ok, err := auth.GetTransaction(capturedUID)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, core.TransactionState_Captured, tx.State)
+}
+
+func TestBoltStoreListTransactions(t *testing.T) {
+	auth, err := repository.NewAuthoriserBoltStore(filepath.Join(t.TempDir(), "auth.db"), time.Hour)
+	require.NoError(t, err)
+	defer auth.ShutDown(context.Background())
+
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	_, err = auth.Authorise(core.CreditCard{Number: 4000000000000259}, eur(1000))
+	require.NoError(t, err)
+	_, err = auth.Capture(uid, eur(1000))
+	require.NoError(t, err)
+
+	page, next, err := auth.ListTransactions(core.TransactionFilter{States: []core.TransactionState{core.TransactionState_Captured}}, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, uid, page[0].UID)
+	assert.Equal(t, int64(4000000000000119), page[0].CCNumber)
+	assert.Nil(t, next)
+}
+
+func TestBoltStoreSettlementSurvivesRestart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "auth.db")
+
+	auth, err := repository.NewAuthoriserBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	settleAt := time.Now().Add(time.Minute)
+	_, err = auth.CaptureForSettlement(uid, eur(1000), settleAt)
+	require.NoError(t, err)
+	require.NoError(t, auth.ShutDown(context.Background()))
+
+	auth, err = repository.NewAuthoriserBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	defer auth.ShutDown(context.Background())
+
+	due, err := auth.DueSettlements(settleAt)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, uid, due[0].UID)
+	assert.True(t, settleAt.Equal(due[0].SettleAt))
+
+	tx, err := auth.Settle(uid)
+	require.NoError(t, err)
+	assert.Equal(t, core.TransactionState_Captured, tx.State)
+	due, err = auth.DueSettlements(settleAt)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+}
+
+func TestBoltStoreCorruptRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "auth.db")
+
+	auth, err := repository.NewAuthoriserBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	require.NoError(t, auth.ShutDown(context.Background()))
+
+	// Corrupt a record behind the store's back
+	corruptUID := "53871001-f41a-4b87-9179-38d531bacece"
+	db, err := bolt.Open(filename, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket([]byte("authorisations")).Put([]byte(corruptUID), []byte("{not json"))
+	}))
+	require.NoError(t, db.Close())
+
+	auth, err = repository.NewAuthoriserBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	defer auth.ShutDown(context.Background())
+
+	// Reported as a storage failure, not as an unknown authorisation
+	_, ok, err := auth.GetTransaction(corruptUID)
+	require.Error(t, err)
+	assert.Equal(t, false, ok)
+	_, _, err = auth.GetAssociatedCreditCard(corruptUID)
+	require.Error(t, err)
+	_, err = auth.Capture(corruptUID, eur(1000))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, core.ErrAuthorisationNotFound)
+
+	// The corrupt record is skipped, the others are still expired
+	count, err := auth.ExpireStale(time.Now().Add(time.Hour + time.Minute))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), corruptUID)
+	assert.Equal(t, 1, count)
+	tx, ok, err := auth.GetTransaction(uid)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, core.TransactionState_Expired, tx.State)
+}
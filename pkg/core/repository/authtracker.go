@@ -1,31 +1,213 @@
 package repository
 
 import (
+	"sync"
+	"time"
+
 	"github.com/google/uuid"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
 )
 
+// shardCount is the number of shards the authorisations are spread across.
+// It must be a power of two.
+const shardCount = 32
+
+// authorisationsShard holds a subset of the authorisations, guarded by its own lock.
+type authorisationsShard struct {
+	sync.RWMutex
+	transactions map[string]*core.Transaction
+}
+
 // AuthoriserInMemoryTracker keeps track of authorisations.
 // This struct mimics a database.
+//
+// It is safe for concurrent use. Authorisations are spread across shards by UID so that
+// concurrent requests on different authorisations rarely contend for the same lock.
+//
+// Authorisations expire once their TTL has elapsed, and expired or voided ones are removed altogether
+// after another TTL has elapsed, so that late requests are told the authorisation expired rather than not found.
 type AuthoriserInMemoryTracker struct {
-	// Authorisations maps a UID to a credit card number
-	Authorisations map[string]int64
+	ttl    time.Duration
+	shards [shardCount]*authorisationsShard
 }
 
 // NewAuthoriserInMemoryTracker creates a new AuthoriserInMemoryTracker.
-func NewAuthoriserInMemoryTracker() *AuthoriserInMemoryTracker {
-	at := AuthoriserInMemoryTracker{Authorisations: make(map[string]int64)}
+// A ttl of zero means authorisations never expire.
+func NewAuthoriserInMemoryTracker(ttl time.Duration) *AuthoriserInMemoryTracker {
+	at := AuthoriserInMemoryTracker{ttl: ttl}
+	for i := range at.shards {
+		at.shards[i] = &authorisationsShard{transactions: make(map[string]*core.Transaction)}
+	}
 	return &at
 }
 
+// shard returns the shard responsible for the UID.
+func (at *AuthoriserInMemoryTracker) shard(uid string) *authorisationsShard {
+	// FNV-1a hash, inlined to avoid allocating a hasher on every call
+	var hash uint32 = 2166136261
+	for i := 0; i < len(uid); i++ {
+		hash ^= uint32(uid[i])
+		hash *= 16777619
+	}
+	return at.shards[hash&(shardCount-1)]
+}
+
 // Authorise generates a new UID and returns it.
-func (at *AuthoriserInMemoryTracker) Authorise(ccNumber int64) (uid string) {
+// It never fails, the error is there to satisfy the core.Authoriser interface.
+func (at *AuthoriserInMemoryTracker) Authorise(card core.CreditCard, amount core.Money) (uid string, err error) {
 	uid = uuid.NewString()
-	at.Authorisations[uid] = ccNumber
+	at.Set(uid, core.NewTransaction(card, amount, time.Now(), at.ttl))
+
+	return uid, nil
+}
+
+// Set stores the transaction under the provided UID, replacing any existing one.
+func (at *AuthoriserInMemoryTracker) Set(uid string, tx core.Transaction) {
+	shard := at.shard(uid)
+	shard.Lock()
+	shard.transactions[uid] = &tx
+	shard.Unlock()
+}
+
+// GetAssociatedCreditCard returns the credit card number used in the authorisation.
+// It never fails, the error is there to satisfy the core.Authoriser interface.
+func (at *AuthoriserInMemoryTracker) GetAssociatedCreditCard(uid string) (ccNumber int64, ok bool, err error) {
+	shard := at.shard(uid)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	txPtr, ok := shard.transactions[uid]
+	if !ok {
+		return 0, false, nil
+	}
+	return txPtr.CCNumber, true, nil
+}
+
+// GetTransaction returns a copy of the transaction associated with the authorisation.
+// It never fails, the error is there to satisfy the core.Authoriser interface.
+func (at *AuthoriserInMemoryTracker) GetTransaction(uid string) (tx core.Transaction, ok bool, err error) {
+	shard := at.shard(uid)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	txPtr, ok := shard.transactions[uid]
+	if !ok {
+		return core.Transaction{}, false, nil
+	}
+	return *txPtr, true, nil
+}
+
+// ListTransactions returns up to limit transactions matching the filter, newest first, starting after the cursor
+// if set. It never fails, the error is there to satisfy the core.TransactionLister interface.
+func (at *AuthoriserInMemoryTracker) ListTransactions(filter core.TransactionFilter, after *core.TransactionCursor, limit int) (
+	page []core.ListedTransaction, next *core.TransactionCursor, err error) {
+	var matching []core.ListedTransaction
+	for _, shard := range at.shards {
+		shard.RLock()
+		for uid, txPtr := range shard.transactions {
+			if filter.Matches(*txPtr) {
+				matching = append(matching, core.ListedTransaction{UID: uid, Transaction: *txPtr})
+			}
+		}
+		shard.RUnlock()
+	}
+
+	page, next = core.PageTransactions(matching, after, limit)
+	return page, next, nil
+}
+
+// DueSettlements returns the transactions pending settlement due at the provided time.
+// It never fails, the error is there to satisfy the core.Settler interface.
+func (at *AuthoriserInMemoryTracker) DueSettlements(now time.Time) (due []core.ListedTransaction, err error) {
+	var pending []core.ListedTransaction
+	for _, shard := range at.shards {
+		shard.RLock()
+		for uid, txPtr := range shard.transactions {
+			if txPtr.State == core.TransactionState_PendingSettlement {
+				pending = append(pending, core.ListedTransaction{UID: uid, Transaction: *txPtr})
+			}
+		}
+		shard.RUnlock()
+	}
+
+	return core.DueSettlements(pending, now), nil
+}
+
+// Capture captures the authorised transaction.
+func (at *AuthoriserInMemoryTracker) Capture(uid string, amount core.Money) (tx core.Transaction, err error) {
+	return at.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Capture(amount, time.Now())
+	})
+}
+
+// CaptureForSettlement captures the authorised transaction, leaving it pending settlement until settleAt.
+func (at *AuthoriserInMemoryTracker) CaptureForSettlement(uid string, amount core.Money, settleAt time.Time) (tx core.Transaction, err error) {
+	return at.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.CaptureForSettlement(amount, time.Now(), settleAt)
+	})
+}
+
+// Settle settles the transaction pending settlement.
+func (at *AuthoriserInMemoryTracker) Settle(uid string) (tx core.Transaction, err error) {
+	return at.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Settle(time.Now())
+	})
+}
+
+// FailSettlement fails the settlement of the transaction pending settlement.
+func (at *AuthoriserInMemoryTracker) FailSettlement(uid string, reason core.DeclineReason) (tx core.Transaction, err error) {
+	return at.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.FailSettlement(reason, time.Now())
+	})
+}
+
+// Void voids the authorised transaction.
+func (at *AuthoriserInMemoryTracker) Void(uid string) error {
+	_, err := at.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Void(time.Now())
+	})
+	return err
+}
+
+// Refund refunds the captured transaction.
+func (at *AuthoriserInMemoryTracker) Refund(uid string, amount core.Money) (tx core.Transaction, err error) {
+	return at.update(uid, func(txPtr *core.Transaction) error {
+		return txPtr.Refund(amount, time.Now())
+	})
+}
+
+// update applies the operation to the transaction while holding the shard lock
+// and returns a copy of the transaction after the operation.
+func (at *AuthoriserInMemoryTracker) update(uid string, operation func(txPtr *core.Transaction) error) (tx core.Transaction, err error) {
+	shard := at.shard(uid)
+	shard.Lock()
+	defer shard.Unlock()
 
-	return uid
+	txPtr, ok := shard.transactions[uid]
+	if !ok {
+		return core.Transaction{}, core.ErrAuthorisationNotFound
+	}
+	// Don't wait for the reaper to notice the authorisation is past its expiry time
+	txPtr.Expire(time.Now())
+	err = operation(txPtr)
+	return *txPtr, err
 }
 
-func (at *AuthoriserInMemoryTracker) GetAssociatedCreditCard(uid string) (ccNumber int64, ok bool) {
-	ccNumber, ok = at.Authorisations[uid]
-	return ccNumber, ok
+// ExpireStale expires the authorisations past their expiry time and removes the ones
+// that have been expired or voided for longer than the TTL.
+// It returns the number of authorisations expired or removed, and never fails.
+func (at *AuthoriserInMemoryTracker) ExpireStale(now time.Time) (count int, err error) {
+	for _, shard := range at.shards {
+		shard.Lock()
+		for uid, txPtr := range shard.transactions {
+			if txPtr.Expire(now) {
+				count++
+			} else if txPtr.Stale(now, at.ttl) {
+				delete(shard.transactions, uid)
+				count++
+			}
+		}
+		shard.Unlock()
+	}
+	return count, nil
 }
@@ -1,21 +1,298 @@
 package repository_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// eur returns an amount of euros in cents.
+func eur(cents int64) core.Money {
+	return core.Money{MinorUnits: cents, Currency: "EUR"}
+}
+
+// expireStale expires the stale entries at the provided time, failing the test if it fails.
+func expireStale(t *testing.T, expirer core.Expirer, now time.Time) int {
+	t.Helper()
+	count, err := expirer.ExpireStale(now)
+	require.NoError(t, err)
+	return count
+}
+
 func TestAuthorisation(t *testing.T) {
-	auth := repository.NewAuthoriserInMemoryTracker()
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
 
 	var ccNumber int64 = 4000000000000119
 
-	uid := auth.Authorise(ccNumber)
+	uid, err := auth.Authorise(core.CreditCard{Number: ccNumber}, eur(1050))
+	require.NoError(t, err)
 
-	number, ok := auth.GetAssociatedCreditCard(uid)
+	number, ok, err := auth.GetAssociatedCreditCard(uid)
+	require.NoError(t, err)
 	require.Equal(t, true, ok)
 	assert.Equal(t, ccNumber, number)
 }
+
+func TestAuthorisationLifecycle(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+
+	tx, err := auth.Capture(uid, eur(800))
+	require.NoError(t, err)
+	assert.Equal(t, eur(800), tx.RemainingBalance())
+	require.ErrorIs(t, auth.Void(uid), core.ErrTransactionAlreadyCaptured)
+
+	tx, err = auth.Refund(uid, eur(500))
+	require.NoError(t, err)
+	assert.Equal(t, eur(300), tx.RemainingBalance())
+	tx, err = auth.Refund(uid, eur(300))
+	require.NoError(t, err)
+	assert.Equal(t, core.TransactionState_Refunded, tx.State)
+
+	_, err = auth.Refund(uid, eur(100))
+	require.ErrorIs(t, err, core.ErrTransactionFullyRefunded)
+
+	stored, ok, err := auth.GetTransaction(uid)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, "EUR", stored.Currency())
+	assert.Equal(t, eur(1000), stored.AuthorisedAmount)
+	assert.Equal(t, eur(800), stored.CapturedAmount)
+	assert.Equal(t, eur(800), stored.RefundedAmount)
+}
+
+func TestAuthorisationNotFound(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	_, ok, err := auth.GetAssociatedCreditCard("unknown")
+	require.NoError(t, err)
+	assert.Equal(t, false, ok)
+	_, err = auth.Capture("unknown", eur(1000))
+	assert.ErrorIs(t, err, core.ErrAuthorisationNotFound)
+	assert.ErrorIs(t, auth.Void("unknown"), core.ErrAuthorisationNotFound)
+	_, err = auth.Refund("unknown", eur(1000))
+	assert.ErrorIs(t, err, core.ErrAuthorisationNotFound)
+}
+
+func TestAuthorisationExpireStale(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	capturedUID, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	_, err = auth.Capture(capturedUID, eur(1000))
+	require.NoError(t, err)
+	voidedUID, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	require.NoError(t, auth.Void(voidedUID))
+
+	assert.Equal(t, 0, expireStale(t, auth, time.Now()))
+
+	// Authorisation expired, captured transaction left alone
+	assert.Equal(t, 1, expireStale(t, auth, time.Now().Add(time.Hour+time.Minute)))
+	tx, ok, err := auth.GetTransaction(uid)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, core.TransactionState_Expired, tx.State)
+	_, err = auth.Capture(uid, eur(1000))
+	assert.ErrorIs(t, err, core.ErrAuthorisationExpired)
+	assert.ErrorIs(t, auth.Void(uid), core.ErrAuthorisationExpired)
+
+	// Expired and voided removed after another TTL, captured kept
+	assert.Equal(t, 2, expireStale(t, auth, time.Now().Add(2*time.Hour+time.Minute)))
+	_, ok, err = auth.GetTransaction(uid)
+	require.NoError(t, err)
+	assert.Equal(t, false, ok)
+	_, ok, err = auth.GetTransaction(voidedUID)
+	require.NoError(t, err)
+	assert.Equal(t, false, ok)
+	tx, ok, err = auth.GetTransaction(capturedUID)
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, core.TransactionState_Captured, tx.State)
+}
+
+func TestAuthorisationExpiredBeforeReaped(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	uid := "53871001-f41a-4b87-9179-38d531bacece"
+	auth.Set(uid, core.NewTransaction(core.CreditCard{Number: 4000000000000119}, eur(1000), time.Now().Add(-2*time.Hour), time.Hour))
+
+	_, err := auth.Capture(uid, eur(1000))
+	assert.ErrorIs(t, err, core.ErrAuthorisationExpired)
+}
+
+func TestAuthorisationListTransactions(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, number := range []int64{4000000000000119, 4000000000000259, 4000000000000119} {
+		tx := core.NewTransaction(core.CreditCard{Number: number}, eur(1000), createdAt.Add(time.Duration(i)*time.Minute), 0)
+		auth.Set(fmt.Sprintf("uid-%d", i), tx)
+	}
+
+	page, next, err := auth.ListTransactions(core.TransactionFilter{CardLast4: "0119"}, nil, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "uid-2", page[0].UID)
+	require.NotNil(t, next)
+
+	page, next, err = auth.ListTransactions(core.TransactionFilter{CardLast4: "0119"}, next, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "uid-0", page[0].UID)
+	assert.Nil(t, next)
+}
+
+func TestAuthorisationSettlement(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	uid, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	failingUID, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+
+	now := time.Now()
+	tx, err := auth.CaptureForSettlement(uid, eur(800), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, core.TransactionState_PendingSettlement, tx.State)
+	_, err = auth.CaptureForSettlement(failingUID, eur(1000), now.Add(2*time.Minute))
+	require.NoError(t, err)
+
+	due, err := auth.DueSettlements(now)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+	due, err = auth.DueSettlements(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	assert.Equal(t, uid, due[0].UID)
+	assert.Equal(t, failingUID, due[1].UID)
+
+	// Pending settlements outlive the authorisation TTL
+	assert.Equal(t, 0, expireStale(t, auth, now.Add(3*time.Hour)))
+
+	tx, err = auth.Settle(uid)
+	require.NoError(t, err)
+	assert.Equal(t, core.TransactionState_Captured, tx.State)
+	_, err = auth.Settle(uid)
+	assert.ErrorIs(t, err, core.ErrTransactionNotPendingSettlement)
+
+	tx, err = auth.FailSettlement(failingUID, core.DeclineReason_InsufficientFunds)
+	require.NoError(t, err)
+	assert.Equal(t, core.TransactionState_SettlementFailed, tx.State)
+	_, err = auth.Refund(failingUID, eur(100))
+	assert.ErrorIs(t, err, core.ErrSettlementFailed)
+
+	due, err = auth.DueSettlements(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, due)
+	_, err = auth.Settle("unknown")
+	assert.ErrorIs(t, err, core.ErrAuthorisationNotFound)
+}
+
+func TestAuthorisationConcurrentAccess(t *testing.T) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	const goroutines = 50
+	const authorisationsPerGoroutine = 100
+
+	var wg sync.WaitGroup
+	uids := make(chan string, goroutines*authorisationsPerGoroutine)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(ccNumber int64) {
+			defer wg.Done()
+			for j := 0; j < authorisationsPerGoroutine; j++ {
+				uid, err := auth.Authorise(core.CreditCard{Number: ccNumber}, eur(1000))
+				assert.NoError(t, err)
+				uids <- uid
+
+				number, ok, err := auth.GetAssociatedCreditCard(uid)
+				assert.NoError(t, err)
+				assert.Equal(t, true, ok)
+				assert.Equal(t, ccNumber, number)
+			}
+		}(4000000000000000 + int64(i))
+	}
+	wg.Wait()
+	close(uids)
+
+	// Hammer the same authorisations from several goroutines at once,
+	// only one capture per authorisation can succeed.
+	var successes, failures int64
+	var mu sync.Mutex
+	for uid := range uids {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(uid string) {
+				defer wg.Done()
+				_, err := auth.Capture(uid, eur(1000))
+				_, _, _ = auth.GetTransaction(uid)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					successes++
+				} else {
+					failures++
+				}
+			}(uid)
+		}
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(goroutines*authorisationsPerGoroutine), successes)
+	assert.Equal(t, int64(3*goroutines*authorisationsPerGoroutine), failures)
+}
+
+func BenchmarkAuthorise(b *testing.B) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+		}
+	})
+}
+
+func BenchmarkGetAssociatedCreditCard(b *testing.B) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	uids := make([]string, 1024)
+	for i := range uids {
+		uids[i], _ = auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _, _ = auth.GetAssociatedCreditCard(uids[i%len(uids)])
+			i++
+		}
+	})
+}
+
+func BenchmarkAuthoriseAndGetAssociatedCreditCard(b *testing.B) {
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			uid, _ := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+			_, _, _ = auth.GetAssociatedCreditCard(uid)
+		}
+	})
+}
@@ -1,34 +1,293 @@
 package repository
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
 	"gopkg.in/yaml.v2"
 )
 
-// CreditCardFileChecker holds the credit cards number and the reason to fail.
+// CreditCardFileChecker holds the credit cards number and the reason to fail,
+// the rules setting the outcome of whole classes of operations, and the magic amounts.
 // This struct mimics a database.
+// It's safe for concurrent use, and can be reloaded while in use.
 type CreditCardFileChecker struct {
-	CreditCards map[int64]core.CCFailReason `yaml:"creditCards"`
+	// mu guards the fields below, swapped on every load
+	mu sync.RWMutex
+
+	CreditCards map[int64]core.CardFailures `yaml:"creditCards"`
+	// Rules are evaluated in order first, the first matching rule wins.
+	Rules []core.Rule `yaml:"rules"`
+	// MagicAmountTable is evaluated in order after the rules and before the credit cards.
+	MagicAmountTable []core.MagicAmount `yaml:"magicAmounts"`
+	// Latencies holds the latency of the endpoint of each operation.
+	Latencies map[core.CCFailReason]core.Latency `yaml:"latency"`
+
+	// writeBackFilename is the file the credit cards changed at runtime are written back to, if any
+	writeBackFilename string
+	// random is the source the latencies are drawn from, seeded from the current time unless set
+	random *core.Random
 }
 
 // NewCreditCardFileChecker creates a new CreditCardsHolder.
 func NewCreditCardFileChecker() *CreditCardFileChecker {
-	ccfc := CreditCardFileChecker{CreditCards: make(map[int64]core.CCFailReason)}
+	ccfc := CreditCardFileChecker{
+		CreditCards: make(map[int64]core.CardFailures),
+		random:      core.NewRandom(time.Now().UnixNano()),
+	}
 	return &ccfc
 }
 
-// Load loads data into the CreditCardFileChecker.
+// SeedLatencies seeds the random source the latencies are drawn from, so they can be reproduced.
+func (ccfc *CreditCardFileChecker) SeedLatencies(seed int64) {
+	ccfc.mu.Lock()
+	defer ccfc.mu.Unlock()
+
+	ccfc.random = core.NewRandom(seed)
+}
+
+// WriteBackTo writes the credit cards changed at runtime back to the file, so they survive reloads and restarts.
+// Only the creditCards section is rewritten, the rest of the file is kept as it is, minus its comments.
+func (ccfc *CreditCardFileChecker) WriteBackTo(filename string) {
+	ccfc.mu.Lock()
+	defer ccfc.mu.Unlock()
+
+	ccfc.writeBackFilename = filename
+}
+
+// Load loads data into the CreditCardFileChecker, replacing whatever it held.
+// The data is validated first, and nothing is replaced if it's invalid.
 func (ccfc *CreditCardFileChecker) Load(data []byte) error {
-	err := yaml.Unmarshal([]byte(data), &ccfc)
-	return err
+	loaded := NewCreditCardFileChecker()
+	err := yaml.Unmarshal(data, loaded)
+	if err != nil {
+		return err
+	}
+	if _, ok := loaded.Latencies[core.CCFailReason_Settle]; ok {
+		return fmt.Errorf("latency: settlements have no endpoint")
+	}
+
+	ccfc.mu.Lock()
+	defer ccfc.mu.Unlock()
+
+	ccfc.CreditCards = loaded.CreditCards
+	ccfc.Rules = loaded.Rules
+	ccfc.MagicAmountTable = loaded.MagicAmountTable
+	ccfc.Latencies = loaded.Latencies
+	return nil
 }
 
-// ShouldFail checks whether the provided credit card number should fail for the provided reason.
-func (ccfc *CreditCardFileChecker) ShouldFail(ccNumber int64, reason core.CCFailReason) bool {
-	if v, ok := ccfc.CreditCards[ccNumber]; ok {
-		if reason == v {
-			return true
+// Evaluate returns the outcome of the first rule or magic amount matching the operation.
+// If none matches, operations failing for the credit card are declined.
+func (ccfc *CreditCardFileChecker) Evaluate(request core.OperationRequest) (outcome core.Outcome, ok bool) {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	if outcome, ok := core.EvaluateRules(ccfc.Rules, request); ok {
+		return outcome, true
+	}
+
+	if outcome, ok := core.EvaluateMagicAmounts(ccfc.MagicAmountTable, request); ok {
+		return outcome, true
+	}
+
+	if reason, ok := ccfc.shouldFail(request.Card.Number, request.Operation); ok {
+		return core.Outcome{Action: core.OutcomeAction_Decline, Reason: reason}, true
+	}
+
+	return core.Outcome{}, false
+}
+
+// MagicAmounts returns the magic amounts table.
+func (ccfc *CreditCardFileChecker) MagicAmounts() []core.MagicAmount {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	return ccfc.MagicAmountTable
+}
+
+// EndpointLatency returns a latency drawn for the endpoint of the operation.
+func (ccfc *CreditCardFileChecker) EndpointLatency(operation core.CCFailReason) (latency time.Duration, ok bool) {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	distribution, ok := ccfc.Latencies[operation]
+	if !ok {
+		return 0, false
+	}
+	return distribution.Sample(ccfc.random), true
+}
+
+// ResponseDelay returns how long to wait before responding with the outcome.
+func (ccfc *CreditCardFileChecker) ResponseDelay(outcome core.Outcome) time.Duration {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	return outcome.ResponseDelay(ccfc.random)
+}
+
+// ShouldFail checks whether the provided credit card number should fail for the provided operation,
+// and returns the reason it's declined for.
+func (ccfc *CreditCardFileChecker) ShouldFail(ccNumber int64, operation core.CCFailReason) (reason core.DeclineReason, ok bool) {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	return ccfc.shouldFail(ccNumber, operation)
+}
+
+// shouldFail is ShouldFail for callers holding the lock already.
+func (ccfc *CreditCardFileChecker) shouldFail(ccNumber int64, operation core.CCFailReason) (reason core.DeclineReason, ok bool) {
+	for _, failure := range ccfc.CreditCards[ccNumber] {
+		if operation == failure.Operation {
+			return failure.Reason, true
 		}
 	}
-	return false
+	return 0, false
+}
+
+// ListCardFailures returns a copy of the operations failing on each credit card.
+func (ccfc *CreditCardFileChecker) ListCardFailures() map[int64]core.CardFailures {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	result := make(map[int64]core.CardFailures, len(ccfc.CreditCards))
+	for number, failures := range ccfc.CreditCards {
+		result[number] = append(core.CardFailures(nil), failures...)
+	}
+	return result
+}
+
+// GetCardFailures returns a copy of the operations failing on the credit card.
+func (ccfc *CreditCardFileChecker) GetCardFailures(number int64) (failures core.CardFailures, ok bool) {
+	ccfc.mu.RLock()
+	defer ccfc.mu.RUnlock()
+
+	failures, ok = ccfc.CreditCards[number]
+	if !ok {
+		return nil, false
+	}
+	return append(core.CardFailures(nil), failures...), true
+}
+
+// PutCardFailures replaces the operations failing on the credit card, returning true if it wasn't held before.
+// If write back is enabled and the file can't be written, nothing is changed.
+func (ccfc *CreditCardFileChecker) PutCardFailures(number int64, failures core.CardFailures) (created bool, err error) {
+	ccfc.mu.Lock()
+	defer ccfc.mu.Unlock()
+
+	creditCards := ccfc.copyCreditCards()
+	_, ok := creditCards[number]
+	creditCards[number] = append(core.CardFailures(nil), failures...)
+
+	err = ccfc.writeBack(creditCards)
+	if err != nil {
+		return false, err
+	}
+
+	ccfc.CreditCards = creditCards
+	return !ok, nil
+}
+
+// DeleteCardFailures removes the operations failing on the credit card, returning false if it wasn't held.
+// If write back is enabled and the file can't be written, nothing is changed.
+func (ccfc *CreditCardFileChecker) DeleteCardFailures(number int64) (ok bool, err error) {
+	ccfc.mu.Lock()
+	defer ccfc.mu.Unlock()
+
+	if _, ok := ccfc.CreditCards[number]; !ok {
+		return false, nil
+	}
+
+	creditCards := ccfc.copyCreditCards()
+	delete(creditCards, number)
+
+	err = ccfc.writeBack(creditCards)
+	if err != nil {
+		return false, err
+	}
+
+	ccfc.CreditCards = creditCards
+	return true, nil
+}
+
+// copyCreditCards returns a shallow copy of the credit cards, for callers holding the lock already.
+// Changes are made on a copy and swapped in once written back, so they are never partially applied.
+func (ccfc *CreditCardFileChecker) copyCreditCards() map[int64]core.CardFailures {
+	result := make(map[int64]core.CardFailures, len(ccfc.CreditCards))
+	for number, failures := range ccfc.CreditCards {
+		result[number] = failures
+	}
+	return result
+}
+
+// creditCardsKey is the key of the credit cards section in the yaml file.
+const creditCardsKey = "creditCards"
+
+// writeBack replaces the credit cards section of the file, if write back is enabled.
+// The file is replaced atomically, so it's never read half written, e.g. when reloaded.
+func (ccfc *CreditCardFileChecker) writeBack(creditCards map[int64]core.CardFailures) error {
+	if ccfc.writeBackFilename == "" {
+		return nil
+	}
+
+	info, err := os.Stat(ccfc.writeBackFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read credit cards file: %w", err)
+	}
+	data, err := ioutil.ReadFile(ccfc.writeBackFilename)
+	if err != nil {
+		return fmt.Errorf("failed to read credit cards file: %w", err)
+	}
+
+	// A MapSlice keeps the sections, and everything in them, in the order they are in the file
+	var content yaml.MapSlice
+	err = yaml.Unmarshal(data, &content)
+	if err != nil {
+		return fmt.Errorf("failed to decode credit cards file: %w", err)
+	}
+
+	numbers := make([]int64, 0, len(creditCards))
+	for number := range creditCards {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	section := make(yaml.MapSlice, 0, len(numbers))
+	for _, number := range numbers {
+		section = append(section, yaml.MapItem{Key: number, Value: creditCards[number]})
+	}
+
+	replaced := false
+	for i := range content {
+		if content[i].Key == creditCardsKey {
+			content[i].Value = section
+			replaced = true
+		}
+	}
+	if !replaced {
+		content = append(content, yaml.MapItem{Key: creditCardsKey, Value: section})
+	}
+
+	data, err = yaml.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to encode credit cards file: %w", err)
+	}
+
+	tmpFilename := ccfc.writeBackFilename + ".tmp"
+	err = ioutil.WriteFile(tmpFilename, data, info.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to write credit cards file: %w", err)
+	}
+
+	err = os.Rename(tmpFilename, ccfc.writeBackFilename)
+	if err != nil {
+		return fmt.Errorf("failed to write credit cards file: %w", err)
+	}
+
+	return nil
 }
@@ -1,23 +1,34 @@
 package repository_test
 
 import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
 	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestCreditCardShouldFail(t *testing.T) {
 	tests := map[string]struct {
 		ccNumber       int64
-		reason         core.CCFailReason
+		operation      core.CCFailReason
 		expectedResult bool
+		expectedReason core.DeclineReason
 	}{
-		"authorise fail": {ccNumber: 4000000000000119, reason: core.CCFailReason_Authorise, expectedResult: true},
-		"capture fail":   {ccNumber: 4000000000000259, reason: core.CCFailReason_Capture, expectedResult: true},
-		"refund fail":    {ccNumber: 4000000000003238, reason: core.CCFailReason_Refund, expectedResult: true},
-		"no fail":        {ccNumber: 123, expectedResult: false},
+		"authorise fail":              {ccNumber: 4000000000000119, operation: core.CCFailReason_Authorise, expectedResult: true, expectedReason: core.DeclineReason_DoNotHonour},
+		"capture fail":                {ccNumber: 4000000000000259, operation: core.CCFailReason_Capture, expectedResult: true, expectedReason: core.DeclineReason_InsufficientFunds},
+		"refund fail":                 {ccNumber: 4000000000003238, operation: core.CCFailReason_Refund, expectedResult: true, expectedReason: core.DeclineReason_StolenCard},
+		"other operation":             {ccNumber: 4000000000003238, operation: core.CCFailReason_Capture, expectedResult: false},
+		"several operations, void":    {ccNumber: 4000000000000077, operation: core.CCFailReason_Void, expectedResult: true, expectedReason: core.DeclineReason_DoNotHonour},
+		"several operations, refund":  {ccNumber: 4000000000000077, operation: core.CCFailReason_Refund, expectedResult: true, expectedReason: core.DeclineReason_LimitExceeded},
+		"several operations, capture": {ccNumber: 4000000000000077, operation: core.CCFailReason_Capture, expectedResult: false},
+		"no fail":                     {ccNumber: 123, expectedResult: false},
 	}
 
 	ccfc := createCreditCardFileChecker()
@@ -25,18 +36,298 @@ func TestCreditCardShouldFail(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 
-			value := ccfc.ShouldFail(test.ccNumber, test.reason)
-			assert.Equal(t, test.expectedResult, value)
+			reason, ok := ccfc.ShouldFail(test.ccNumber, test.operation)
+			assert.Equal(t, test.expectedResult, ok)
+			assert.Equal(t, test.expectedReason, reason)
 		})
 	}
 }
 
+func TestCreditCardEvaluate(t *testing.T) {
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`creditCards:
+  4000000000000119: "authorise fail"
+  4000000000000259: {operation: "capture fail", reason: "insufficient_funds"}
+rules:
+  - name: "approve small amounts"
+    match: {operations: ["authorise"], amountRange: {max: "1"}}
+    outcome: "approve"
+  - name: "unavailable issuer"
+    match: {binPrefixes: ["5105"]}
+    outcome: "error"
+    httpStatus: 503`))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		request         core.OperationRequest
+		expectedResult  bool
+		expectedOutcome core.Outcome
+	}{
+		"rule matching": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Void, Card: core.CreditCard{Number: 5105105105105100}, Amount: eur(1000)},
+			expectedResult:  true,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 503},
+		},
+		"rule matching before the credit card": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: core.CreditCard{Number: 4000000000000119}, Amount: eur(50)},
+			expectedResult:  true,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Approve},
+		},
+		"credit card failing": {
+			request:         core.OperationRequest{Operation: core.CCFailReason_Capture, Card: core.CreditCard{Number: 4000000000000259}, Amount: eur(1000)},
+			expectedResult:  true,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_InsufficientFunds},
+		},
+		"nothing matching": {
+			request:        core.OperationRequest{Operation: core.CCFailReason_Capture, Card: core.CreditCard{Number: 4000000000000119}, Amount: eur(1000)},
+			expectedResult: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			outcome, ok := ccfc.Evaluate(test.request)
+			assert.Equal(t, test.expectedResult, ok)
+			assert.Equal(t, test.expectedOutcome, outcome)
+		})
+	}
+}
+
+func TestCreditCardLoad(t *testing.T) {
+	tests := map[string]struct {
+		content          string
+		expectedErr      bool
+		expectedFailures map[int64]core.CardFailures
+	}{
+		"operation only": {
+			content: `creditCards:
+  4000000000000119: "authorise fail"`,
+			expectedFailures: map[int64]core.CardFailures{
+				4000000000000119: {{Operation: core.CCFailReason_Authorise, Reason: core.DeclineReason_DoNotHonour}},
+			},
+		},
+		"operation and reason": {
+			content: `creditCards:
+  4000000000000119: {operation: "authorise fail", reason: "insufficient_funds"}
+  4000000000000259:
+    operation: "capture fail"
+    reason: "issuer_unavailable"
+  4000000000003238: {operation: "refund fail"}`,
+			expectedFailures: map[int64]core.CardFailures{
+				4000000000000119: {{Operation: core.CCFailReason_Authorise, Reason: core.DeclineReason_InsufficientFunds}},
+				4000000000000259: {{Operation: core.CCFailReason_Capture, Reason: core.DeclineReason_IssuerUnavailable}},
+				4000000000003238: {{Operation: core.CCFailReason_Refund, Reason: core.DeclineReason_DoNotHonour}},
+			},
+		},
+		"list of operations": {
+			content: `creditCards:
+  4000000000000077:
+    - "void fail"
+    - {operation: "refund fail", reason: "stolen_card"}`,
+			expectedFailures: map[int64]core.CardFailures{
+				4000000000000077: {
+					{Operation: core.CCFailReason_Void, Reason: core.DeclineReason_DoNotHonour},
+					{Operation: core.CCFailReason_Refund, Reason: core.DeclineReason_StolenCard},
+				},
+			},
+		},
+		"settlement": {
+			content: `creditCards:
+  4000000000000341: {operation: "settle fail", reason: "insufficient_funds"}`,
+			expectedFailures: map[int64]core.CardFailures{
+				4000000000000341: {{Operation: core.CCFailReason_Settle, Reason: core.DeclineReason_InsufficientFunds}},
+			},
+		},
+		"settlement latency": {
+			content:     `latency: {settle: "1s"}`,
+			expectedErr: true,
+		},
+		"operation listed twice": {
+			content: `creditCards:
+  4000000000000077: ["void fail", {operation: "void fail", reason: "stolen_card"}]`,
+			expectedErr: true,
+		},
+		"unknown operation in list": {
+			content:     `creditCards: {4000000000000077: ["void fail", "explode"]}`,
+			expectedErr: true,
+		},
+		"unknown operation": {
+			content:     `creditCards: {4000000000000119: "explode"}`,
+			expectedErr: true,
+		},
+		"unknown reason": {
+			content:     `creditCards: {4000000000000119: {operation: "authorise fail", reason: "bad luck"}}`,
+			expectedErr: true,
+		},
+		"missing operation": {
+			content:     `creditCards: {4000000000000119: {reason: "stolen_card"}}`,
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ccfc := repository.NewCreditCardFileChecker()
+			err := ccfc.Load([]byte(test.content))
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedFailures, ccfc.CreditCards)
+		})
+	}
+}
+
+func TestCreditCardSeedLatencies(t *testing.T) {
+	outcome := core.Outcome{Action: core.OutcomeAction_Delay, Latency: &core.Latency{}}
+	require.NoError(t, yaml.Unmarshal([]byte(`{normal: {mean: "200ms", stddev: "50ms"}}`), outcome.Latency))
+
+	sequence := func(seed int64) []time.Duration {
+		ccfc := repository.NewCreditCardFileChecker()
+		require.NoError(t, ccfc.Load([]byte(`latency: {authorise: {uniform: {min: "100ms", max: "300ms"}}}`)))
+		ccfc.SeedLatencies(seed)
+
+		var latencies []time.Duration
+		for i := 0; i < 100; i++ {
+			latency, ok := ccfc.EndpointLatency(core.CCFailReason_Authorise)
+			require.True(t, ok)
+			latencies = append(latencies, latency, ccfc.ResponseDelay(outcome))
+		}
+		return latencies
+	}
+
+	assert.Equal(t, sequence(42), sequence(42))
+	assert.NotEqual(t, sequence(42), sequence(43))
+}
+
+func TestCreditCardReload(t *testing.T) {
+	ccfc := repository.NewCreditCardFileChecker()
+	err := ccfc.Load([]byte(`creditCards: {4000000000000119: "authorise fail"}`))
+	require.NoError(t, err)
+
+	// Reload while the checker is in use
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ccfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: core.CreditCard{Number: 4000000000000119}})
+				}
+			}
+		}()
+	}
+
+	err = ccfc.Load([]byte(`creditCards: {4000000000000259: "capture fail"}`))
+	close(stop)
+	wg.Wait()
+	require.NoError(t, err)
+
+	// The previous credit cards are replaced, not merged
+	_, ok := ccfc.ShouldFail(4000000000000119, core.CCFailReason_Authorise)
+	assert.False(t, ok)
+	_, ok = ccfc.ShouldFail(4000000000000259, core.CCFailReason_Capture)
+	assert.True(t, ok)
+
+	// Invalid data is rejected and the previous credit cards are kept
+	err = ccfc.Load([]byte(`creditCards: {4000000000000119: "explode"}`))
+	require.Error(t, err)
+	_, ok = ccfc.ShouldFail(4000000000000259, core.CCFailReason_Capture)
+	assert.True(t, ok)
+}
+
+func TestCreditCardPutDelete(t *testing.T) {
+	ccfc := createCreditCardFileChecker()
+
+	failures := core.CardFailures{{Operation: core.CCFailReason_Capture, Reason: core.DeclineReason_LostCard}}
+	created, err := ccfc.PutCardFailures(4000000000000119, failures)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	_, ok := ccfc.ShouldFail(4000000000000119, core.CCFailReason_Authorise)
+	assert.False(t, ok)
+	reason, ok := ccfc.ShouldFail(4000000000000119, core.CCFailReason_Capture)
+	assert.True(t, ok)
+	assert.Equal(t, core.DeclineReason_LostCard, reason)
+
+	created, err = ccfc.PutCardFailures(5555555555554444, failures)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	got, ok := ccfc.GetCardFailures(5555555555554444)
+	require.True(t, ok)
+	assert.Equal(t, failures, got)
+	assert.Len(t, ccfc.ListCardFailures(), 5)
+
+	ok, err = ccfc.DeleteCardFailures(5555555555554444)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok = ccfc.GetCardFailures(5555555555554444)
+	assert.False(t, ok)
+
+	ok, err = ccfc.DeleteCardFailures(5555555555554444)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestCreditCardWriteBack(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "creditcards.yaml")
+	content := `rules:
+  - name: "large amounts declined"
+    match: {amountRange: {min: "1000"}}
+    outcome: "decline"
+creditCards:
+  4000000000000119: "authorise fail"
+`
+	err := ioutil.WriteFile(filename, []byte(content), 0644)
+	require.NoError(t, err)
+
+	ccfc := repository.NewCreditCardFileChecker()
+	err = ccfc.Load([]byte(content))
+	require.NoError(t, err)
+	ccfc.WriteBackTo(filename)
+
+	_, err = ccfc.PutCardFailures(4000000000000259, core.CardFailures{{Operation: core.CCFailReason_Capture, Reason: core.DeclineReason_InsufficientFunds}})
+	require.NoError(t, err)
+	_, err = ccfc.DeleteCardFailures(4000000000000119)
+	require.NoError(t, err)
+
+	// The file loads into the same credit cards, and keeps the other sections
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	reloaded := repository.NewCreditCardFileChecker()
+	err = reloaded.Load(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, ccfc.ListCardFailures(), reloaded.ListCardFailures())
+	require.Len(t, reloaded.Rules, 1)
+	assert.Equal(t, "large amounts declined", reloaded.Rules[0].Name)
+
+	// Nothing is changed if the file can't be written
+	ccfc.WriteBackTo(filepath.Join(t.TempDir(), "missing", "creditcards.yaml"))
+	_, err = ccfc.PutCardFailures(4000000000003238, core.CardFailures{{Operation: core.CCFailReason_Refund, Reason: core.DeclineReason_DoNotHonour}})
+	require.Error(t, err)
+	_, ok := ccfc.GetCardFailures(4000000000003238)
+	assert.False(t, ok)
+}
+
 func createCreditCardFileChecker() *repository.CreditCardFileChecker {
 	ccfc := repository.NewCreditCardFileChecker()
 
-	ccfc.CreditCards[4000000000000119] = core.CCFailReason_Authorise
-	ccfc.CreditCards[4000000000000259] = core.CCFailReason_Capture
-	ccfc.CreditCards[4000000000003238] = core.CCFailReason_Refund
+	ccfc.CreditCards[4000000000000119] = core.CardFailures{{Operation: core.CCFailReason_Authorise, Reason: core.DeclineReason_DoNotHonour}}
+	ccfc.CreditCards[4000000000000259] = core.CardFailures{{Operation: core.CCFailReason_Capture, Reason: core.DeclineReason_InsufficientFunds}}
+	ccfc.CreditCards[4000000000003238] = core.CardFailures{{Operation: core.CCFailReason_Refund, Reason: core.DeclineReason_StolenCard}}
+	ccfc.CreditCards[4000000000000077] = core.CardFailures{
+		{Operation: core.CCFailReason_Void, Reason: core.DeclineReason_DoNotHonour},
+		{Operation: core.CCFailReason_Refund, Reason: core.DeclineReason_LimitExceeded},
+	}
 
 	return ccfc
 }
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// idempotencyEntry holds the request fingerprint and, once completed, the response for a key.
+type idempotencyEntry struct {
+	fingerprint string
+	response    *core.IdempotentResponse
+	expiresAt   time.Time
+}
+
+// IdempotencyInMemoryStore keeps track of idempotency keys and the responses to their requests.
+// This struct mimics a database.
+//
+// Keys expire after the TTL, after which they can be used again.
+type IdempotencyInMemoryStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// NewIdempotencyInMemoryStore creates a new IdempotencyInMemoryStore.
+func NewIdempotencyInMemoryStore(ttl time.Duration) *IdempotencyInMemoryStore {
+	ims := IdempotencyInMemoryStore{ttl: ttl, entries: make(map[string]*idempotencyEntry)}
+	return &ims
+}
+
+// Begin reserves the key for the request identified by the fingerprint.
+func (ims *IdempotencyInMemoryStore) Begin(key string, fingerprint string) (response *core.IdempotentResponse, err error) {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	now := time.Now()
+
+	entry, ok := ims.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		ims.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expiresAt: now.Add(ims.ttl)}
+		return nil, nil
+	}
+
+	if entry.fingerprint != fingerprint {
+		return nil, core.ErrIdempotencyKeyReused
+	}
+	if entry.response == nil {
+		return nil, core.ErrIdempotencyKeyInProgress
+	}
+
+	responseCopy := *entry.response
+	return &responseCopy, nil
+}
+
+// Complete stores the response to the request the key was reserved for.
+func (ims *IdempotencyInMemoryStore) Complete(key string, response core.IdempotentResponse) {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	if entry, ok := ims.entries[key]; ok {
+		entry.response = &response
+	}
+}
+
+// Cancel releases the key without storing a response.
+func (ims *IdempotencyInMemoryStore) Cancel(key string) {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	delete(ims.entries, key)
+}
+
+// ExpireStale removes the keys past their expiry time and returns how many were removed. It never fails.
+func (ims *IdempotencyInMemoryStore) ExpireStale(now time.Time) (count int, err error) {
+	ims.mu.Lock()
+	defer ims.mu.Unlock()
+
+	for key, entry := range ims.entries {
+		if now.After(entry.expiresAt) {
+			delete(ims.entries, key)
+			count++
+		}
+	}
+	return count, nil
+}
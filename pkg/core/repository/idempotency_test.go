@@ -0,0 +1,61 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store := repository.NewIdempotencyInMemoryStore(time.Hour)
+
+	response, err := store.Begin("key1", "fingerprint1")
+	require.NoError(t, err)
+	assert.Nil(t, response)
+
+	_, err = store.Begin("key1", "fingerprint1")
+	assert.ErrorIs(t, err, core.ErrIdempotencyKeyInProgress)
+
+	stored := core.IdempotentResponse{StatusCode: 200, ContentType: "application/json", Body: []byte(`{"code":1}`)}
+	store.Complete("key1", stored)
+
+	response, err = store.Begin("key1", "fingerprint1")
+	require.NoError(t, err)
+	require.NotNil(t, response)
+	assert.Equal(t, stored, *response)
+
+	_, err = store.Begin("key1", "fingerprint2")
+	assert.ErrorIs(t, err, core.ErrIdempotencyKeyReused)
+}
+
+func TestIdempotencyStoreCancel(t *testing.T) {
+	store := repository.NewIdempotencyInMemoryStore(time.Hour)
+
+	_, err := store.Begin("key1", "fingerprint1")
+	require.NoError(t, err)
+	store.Cancel("key1")
+
+	response, err := store.Begin("key1", "fingerprint2")
+	require.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestIdempotencyStoreExpireStale(t *testing.T) {
+	store := repository.NewIdempotencyInMemoryStore(time.Hour)
+
+	_, err := store.Begin("key1", "fingerprint1")
+	require.NoError(t, err)
+	store.Complete("key1", core.IdempotentResponse{StatusCode: 200})
+
+	assert.Equal(t, 0, expireStale(t, store, time.Now()))
+	assert.Equal(t, 1, expireStale(t, store, time.Now().Add(2*time.Hour)))
+
+	// Key can be used for a different request once expired
+	response, err := store.Begin("key1", "fingerprint2")
+	require.NoError(t, err)
+	assert.Nil(t, response)
+}
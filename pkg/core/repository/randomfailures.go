@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// RandomFailureChecker declines or fails operations at random, at configured rates per operation.
+// It's layered over another checker, and only operations that checker processes normally can fail at random.
+type RandomFailureChecker struct {
+	next         core.CreditCardChecker
+	declineRates map[core.CCFailReason]float64
+	errorRates   map[core.CCFailReason]float64
+
+	// mu guards random, which isn't safe for concurrent use
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// NewRandomFailureChecker creates a new RandomFailureChecker layered over the provided checker.
+// Rates are between 0 and 1, and the seed makes the sequence of failures reproducible.
+func NewRandomFailureChecker(next core.CreditCardChecker, declineRates map[core.CCFailReason]float64,
+	errorRates map[core.CCFailReason]float64, seed int64) *RandomFailureChecker {
+	return &RandomFailureChecker{
+		next:         next,
+		declineRates: declineRates,
+		errorRates:   errorRates,
+		random:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Evaluate returns the outcome set by the checker layered over, if any.
+// Otherwise, the operation is declined with core.DeclineReason_DoNotHonour or fails with an HTTP 500 at random.
+func (rfc *RandomFailureChecker) Evaluate(request core.OperationRequest) (outcome core.Outcome, ok bool) {
+	if outcome, ok := rfc.next.Evaluate(request); ok {
+		return outcome, true
+	}
+
+	rfc.mu.Lock()
+	draw := rfc.random.Float64()
+	rfc.mu.Unlock()
+
+	declineRate := rfc.declineRates[request.Operation]
+	if draw < declineRate {
+		return core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_DoNotHonour}, true
+	}
+	if draw < declineRate+rfc.errorRates[request.Operation] {
+		return core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 500}, true
+	}
+
+	return core.Outcome{}, false
+}
+
+// MagicAmounts returns the magic amounts table of the checker layered over, if it has any.
+func (rfc *RandomFailureChecker) MagicAmounts() []core.MagicAmount {
+	if lister, ok := rfc.next.(core.MagicAmountLister); ok {
+		return lister.MagicAmounts()
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandomFailureRates(t *testing.T) {
+	declineRates := map[core.CCFailReason]float64{core.CCFailReason_Authorise: 0.2, core.CCFailReason_Void: 1}
+	errorRates := map[core.CCFailReason]float64{core.CCFailReason_Authorise: 0.1}
+	rfc := repository.NewRandomFailureChecker(repository.NewCreditCardFileChecker(), declineRates, errorRates, 42)
+
+	const draws = 10000
+	counts := make(map[core.OutcomeAction]int)
+	for i := 0; i < draws; i++ {
+		outcome, ok := rfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: core.CreditCard{Number: 4000000000000001}})
+		if ok {
+			counts[outcome.Action]++
+		}
+	}
+	assert.InDelta(t, 0.2, float64(counts[core.OutcomeAction_Decline])/draws, 0.02)
+	assert.InDelta(t, 0.1, float64(counts[core.OutcomeAction_Error])/draws, 0.02)
+
+	outcome, ok := rfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Void, Card: core.CreditCard{Number: 4000000000000001}})
+	require.True(t, ok)
+	assert.Equal(t, core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_DoNotHonour}, outcome)
+
+	_, ok = rfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Capture, Card: core.CreditCard{Number: 4000000000000001}})
+	assert.False(t, ok)
+}
+
+func TestRandomFailureSeed(t *testing.T) {
+	declineRates := map[core.CCFailReason]float64{core.CCFailReason_Capture: 0.3}
+	errorRates := map[core.CCFailReason]float64{core.CCFailReason_Capture: 0.3}
+
+	sequence := func(seed int64) []core.OutcomeAction {
+		rfc := repository.NewRandomFailureChecker(repository.NewCreditCardFileChecker(), declineRates, errorRates, seed)
+		var actions []core.OutcomeAction
+		for i := 0; i < 100; i++ {
+			outcome, _ := rfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Capture, Card: core.CreditCard{Number: 4000000000000001}})
+			actions = append(actions, outcome.Action)
+		}
+		return actions
+	}
+
+	assert.Equal(t, sequence(42), sequence(42))
+	assert.NotEqual(t, sequence(42), sequence(43))
+}
+
+func TestRandomFailureLayered(t *testing.T) {
+	ccfc := createCreditCardFileChecker()
+	rfc := repository.NewRandomFailureChecker(ccfc, nil, map[core.CCFailReason]float64{core.CCFailReason_Authorise: 1}, 42)
+
+	// The outcome set for the credit card wins over random failures
+	outcome, ok := rfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: core.CreditCard{Number: 4000000000000119}})
+	require.True(t, ok)
+	assert.Equal(t, core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_DoNotHonour}, outcome)
+
+	outcome, ok = rfc.Evaluate(core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: core.CreditCard{Number: 4000000000000001}})
+	require.True(t, ok)
+	assert.Equal(t, core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 500}, outcome)
+}
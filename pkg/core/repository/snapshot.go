@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this service.
+// Version 2 stores amounts in minor units, version 1 snapshots (with float amounts) can no longer be loaded.
+const SnapshotVersion = 2
+
+// Snapshot holds the state of an AuthoriserInMemoryTracker.
+// It is written as JSON, which makes it easy to hand-craft fixtures too, e.g.:
+//
+//	{
+//	  "version": 2,
+//	  "authorisations": {
+//	    "53871001-f41a-4b87-9179-38d531bacece": {
+//	      "cc_number": 4000000000000119, "state": "captured",
+//	      "authorised_amount": {"minor_units": 1050, "currency": "EUR"},
+//	      "captured_amount": {"minor_units": 1050, "currency": "EUR"},
+//	      "refunded_amount": {"minor_units": 0, "currency": "EUR"},
+//	      "created_at": "2021-03-01T12:00:00Z", "expires_at": "2021-03-08T12:00:00Z"
+//	    }
+//	  }
+//	}
+type Snapshot struct {
+	Version        int                         `json:"version"`
+	Authorisations map[string]core.Transaction `json:"authorisations"`
+}
+
+// Snapshot returns a snapshot of all the authorisations.
+func (at *AuthoriserInMemoryTracker) Snapshot() Snapshot {
+	snapshot := Snapshot{Version: SnapshotVersion, Authorisations: make(map[string]core.Transaction)}
+
+	for _, shard := range at.shards {
+		shard.RLock()
+		for uid, txPtr := range shard.transactions {
+			snapshot.Authorisations[uid] = *txPtr
+		}
+		shard.RUnlock()
+	}
+
+	return snapshot
+}
+
+// Restore adds all the authorisations in the snapshot, replacing existing ones with the same UID.
+func (at *AuthoriserInMemoryTracker) Restore(snapshot Snapshot) error {
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version <%d>", snapshot.Version)
+	}
+
+	for uid, tx := range snapshot.Authorisations {
+		at.Set(uid, tx)
+	}
+
+	return nil
+}
+
+// SnapshotFile saves and loads snapshots of an AuthoriserInMemoryTracker to and from a file.
+type SnapshotFile struct {
+	filename string
+	tracker  *AuthoriserInMemoryTracker
+}
+
+// NewSnapshotFile creates a new SnapshotFile.
+func NewSnapshotFile(filename string, tracker *AuthoriserInMemoryTracker) *SnapshotFile {
+	sf := SnapshotFile{filename: filename, tracker: tracker}
+	return &sf
+}
+
+// Load restores the tracker from the snapshot file.
+// It returns false if there is no snapshot file yet.
+func (sf *SnapshotFile) Load() (ok bool, err error) {
+	data, err := ioutil.ReadFile(sf.filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Check the version first, older formats may not even decode
+	var header struct {
+		Version int `json:"version"`
+	}
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode snapshot file: %w", err)
+	}
+	if header.Version != SnapshotVersion {
+		return false, fmt.Errorf("unsupported snapshot version <%d>", header.Version)
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode snapshot file: %w", err)
+	}
+
+	err = sf.tracker.Restore(snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Save writes a snapshot of the tracker to the snapshot file.
+// The snapshot is written to a temporary file first, so a failed write never clobbers the previous snapshot.
+func (sf *SnapshotFile) Save() error {
+	data, err := json.Marshal(sf.tracker.Snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	tmpFilename := sf.filename + ".tmp"
+	err = ioutil.WriteFile(tmpFilename, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	err = os.Rename(tmpFilename, sf.filename)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	return nil
+}
+
+// ShutDown saves a snapshot of the tracker, so it can be restored on the next start.
+func (sf *SnapshotFile) ShutDown(ctx context.Context) error {
+	return sf.Save()
+}
@@ -0,0 +1,105 @@
+package repository_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotSaveAndLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot.json")
+
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	uid1, err := auth.Authorise(core.CreditCard{Number: 4000000000000119}, eur(1000))
+	require.NoError(t, err)
+	uid2, err := auth.Authorise(core.CreditCard{Number: 4000000000000259}, core.Money{MinorUnits: 2000, Currency: "GBP"})
+	require.NoError(t, err)
+	_, err = auth.Capture(uid2, core.Money{MinorUnits: 1500, Currency: "GBP"})
+	require.NoError(t, err)
+
+	require.NoError(t, repository.NewSnapshotFile(filename, auth).Save())
+
+	restored := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	ok, err := repository.NewSnapshotFile(filename, restored).Load()
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+
+	for _, uid := range []string{uid1, uid2} {
+		expected, _, err := auth.GetTransaction(uid)
+		require.NoError(t, err)
+		actual, ok, err := restored.GetTransaction(uid)
+		require.NoError(t, err)
+		require.Equal(t, true, ok)
+		assert.Equal(t, expected.State, actual.State)
+		assert.Equal(t, expected.CCNumber, actual.CCNumber)
+		assert.Equal(t, expected.CapturedAmount, actual.CapturedAmount)
+		assert.True(t, expected.ExpiresAt.Equal(actual.ExpiresAt))
+	}
+}
+
+func TestSnapshotLoadFixture(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "snapshot.json")
+	fixture := `{
+  "version": 2,
+  "authorisations": {
+    "53871001-f41a-4b87-9179-38d531bacece": {
+      "cc_number": 4000000000000119, "state": "partially refunded",
+      "authorised_amount": {"minor_units": 1050, "currency": "EUR"},
+      "captured_amount": {"minor_units": 1050, "currency": "EUR"},
+      "refunded_amount": {"minor_units": 400, "currency": "EUR"},
+      "created_at": "2021-03-01T12:00:00Z", "expires_at": "2021-03-08T12:00:00Z"
+    }
+  }
+}`
+	require.NoError(t, ioutil.WriteFile(filename, []byte(fixture), 0600))
+
+	auth := repository.NewAuthoriserInMemoryTracker(time.Hour)
+	ok, err := repository.NewSnapshotFile(filename, auth).Load()
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+
+	tx, ok, err := auth.GetTransaction("53871001-f41a-4b87-9179-38d531bacece")
+	require.NoError(t, err)
+	require.Equal(t, true, ok)
+	assert.Equal(t, core.TransactionState_PartiallyRefunded, tx.State)
+	assert.Equal(t, eur(650), tx.RemainingBalance())
+}
+
+func TestSnapshotLoad(t *testing.T) {
+	tests := map[string]struct {
+		content     string
+		expectedOK  bool
+		expectedErr bool
+	}{
+		"missing file":        {expectedOK: false, expectedErr: false},
+		"empty":               {content: `{"version": 2, "authorisations": {}}`, expectedOK: true},
+		"unsupported version": {content: `{"version": 99, "authorisations": {}}`, expectedErr: true},
+		"float amounts":       {content: `{"version": 1, "authorisations": {"uid": {"state": "authorised", "authorised_amount": 10.5}}}`, expectedErr: true},
+		"invalid json":        {content: `{"version": 2,`, expectedErr: true},
+		"unknown state":       {content: `{"version": 2, "authorisations": {"uid": {"state": "lost"}}}`, expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "snapshot.json")
+			if test.content != "" {
+				require.NoError(t, ioutil.WriteFile(filename, []byte(test.content), 0600))
+			}
+
+			ok, err := repository.NewSnapshotFile(filename, repository.NewAuthoriserInMemoryTracker(time.Hour)).Load()
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOK, ok)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	bolt "go.etcd.io/bbolt"
+)
+
+// webhookDeliveriesBucket is the name of the bucket holding the webhook deliveries.
+var webhookDeliveriesBucket = []byte("webhook_deliveries")
+
+// WebhookBoltStore keeps track of webhook deliveries in a bbolt database file,
+// so that pending deliveries survive restarts.
+//
+// Deliveries are stored JSON encoded, keyed by ID.
+// Retention works the same way as in WebhookInMemoryStore.
+type WebhookBoltStore struct {
+	retention time.Duration
+	db        *bolt.DB
+}
+
+// NewWebhookBoltStore opens (or creates) the database file and returns a new WebhookBoltStore.
+func NewWebhookBoltStore(filename string, retention time.Duration) (*WebhookBoltStore, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open webhooks database: %w", err)
+	}
+
+	err = db.Update(func(btx *bolt.Tx) error {
+		_, err := btx.CreateBucketIfNotExists(webhookDeliveriesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create webhook deliveries bucket: %w", err)
+	}
+
+	wbs := WebhookBoltStore{retention: retention, db: db}
+	return &wbs, nil
+}
+
+// Add queues the deliveries.
+func (wbs *WebhookBoltStore) Add(deliveries []core.WebhookDelivery) error {
+	return wbs.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(webhookDeliveriesBucket)
+		for _, wd := range deliveries {
+			if err := putDelivery(bucket, wd); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Due returns the pending deliveries due at the provided time, the oldest first.
+// All the deliveries are scanned, there are no indexes.
+func (wbs *WebhookBoltStore) Due(now time.Time) ([]core.WebhookDelivery, error) {
+	var due []core.WebhookDelivery
+	err := wbs.forEach(func(wd core.WebhookDelivery) {
+		if isDue(wd, now) {
+			due = append(due, wd)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortDue(due)
+	return due, nil
+}
+
+// Update replaces the delivery.
+func (wbs *WebhookBoltStore) Update(delivery core.WebhookDelivery) error {
+	return wbs.db.Update(func(btx *bolt.Tx) error {
+		return putDelivery(btx.Bucket(webhookDeliveriesBucket), delivery)
+	})
+}
+
+// ListDeliveries returns the deliveries, the newest first.
+func (wbs *WebhookBoltStore) ListDeliveries() ([]core.WebhookDelivery, error) {
+	deliveries := []core.WebhookDelivery{}
+	err := wbs.forEach(func(wd core.WebhookDelivery) {
+		deliveries = append(deliveries, wd)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortNewestFirst(deliveries)
+	return deliveries, nil
+}
+
+// ExpireStale removes the deliveries finished for longer than the retention.
+// It returns the number of deliveries removed. Deliveries that can't be decoded are skipped and reported in the error.
+func (wbs *WebhookBoltStore) ExpireStale(now time.Time) (count int, err error) {
+	var undecodable []string
+
+	err = wbs.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(webhookDeliveriesBucket)
+
+		// The bucket can't be modified while iterating over it, so collect the stale deliveries first
+		var stale []string
+		err := bucket.ForEach(func(key, value []byte) error {
+			var wd core.WebhookDelivery
+			if err := json.Unmarshal(value, &wd); err != nil {
+				undecodable = append(undecodable, string(key))
+				return nil
+			}
+			if isStale(wd, now, wbs.retention) {
+				stale = append(stale, string(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range stale {
+			if err := bucket.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		count = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(undecodable) != 0 {
+		return count, fmt.Errorf("failed to decode webhook deliveries <%s>", strings.Join(undecodable, ","))
+	}
+
+	return count, nil
+}
+
+// ShutDown closes the database.
+func (wbs *WebhookBoltStore) ShutDown(ctx context.Context) error {
+	return wbs.db.Close()
+}
+
+// forEach decodes every delivery and passes it to fn.
+func (wbs *WebhookBoltStore) forEach(fn func(wd core.WebhookDelivery)) error {
+	return wbs.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(webhookDeliveriesBucket).ForEach(func(key, value []byte) error {
+			var wd core.WebhookDelivery
+			if err := json.Unmarshal(value, &wd); err != nil {
+				return fmt.Errorf("failed to decode webhook delivery <%s>: %w", key, err)
+			}
+			fn(wd)
+			return nil
+		})
+	})
+}
+
+// putDelivery encodes and writes the delivery under its ID.
+func putDelivery(bucket *bolt.Bucket, wd core.WebhookDelivery) error {
+	value, err := json.Marshal(wd)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook delivery <%s>: %w", wd.ID, err)
+	}
+	return bucket.Put([]byte(wd.ID), value)
+}
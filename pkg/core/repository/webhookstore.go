@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+)
+
+// WebhookInMemoryStore keeps track of webhook deliveries.
+// This struct mimics a database, pending deliveries are lost on restart.
+//
+// Delivered and failed deliveries are removed once the retention has elapsed since their last attempt.
+type WebhookInMemoryStore struct {
+	retention time.Duration
+
+	mu         sync.Mutex
+	deliveries map[string]core.WebhookDelivery
+}
+
+// NewWebhookInMemoryStore creates a new WebhookInMemoryStore.
+func NewWebhookInMemoryStore(retention time.Duration) *WebhookInMemoryStore {
+	wms := WebhookInMemoryStore{retention: retention, deliveries: make(map[string]core.WebhookDelivery)}
+	return &wms
+}
+
+// Add queues the deliveries.
+// It never fails, the error is there to satisfy the core.WebhookStore interface.
+func (wms *WebhookInMemoryStore) Add(deliveries []core.WebhookDelivery) error {
+	wms.mu.Lock()
+	defer wms.mu.Unlock()
+
+	for _, wd := range deliveries {
+		wms.deliveries[wd.ID] = wd
+	}
+	return nil
+}
+
+// Due returns the pending deliveries due at the provided time, the oldest first.
+// It never fails, the error is there to satisfy the core.WebhookStore interface.
+func (wms *WebhookInMemoryStore) Due(now time.Time) ([]core.WebhookDelivery, error) {
+	wms.mu.Lock()
+	defer wms.mu.Unlock()
+
+	var due []core.WebhookDelivery
+	for _, wd := range wms.deliveries {
+		if isDue(wd, now) {
+			due = append(due, wd)
+		}
+	}
+
+	sortDue(due)
+	return due, nil
+}
+
+// Update replaces the delivery.
+// It never fails, the error is there to satisfy the core.WebhookStore interface.
+func (wms *WebhookInMemoryStore) Update(delivery core.WebhookDelivery) error {
+	wms.mu.Lock()
+	defer wms.mu.Unlock()
+
+	wms.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// ListDeliveries returns the deliveries, the newest first.
+// It never fails, the error is there to satisfy the core.WebhookDeliveryLister interface.
+func (wms *WebhookInMemoryStore) ListDeliveries() ([]core.WebhookDelivery, error) {
+	wms.mu.Lock()
+	defer wms.mu.Unlock()
+
+	deliveries := make([]core.WebhookDelivery, 0, len(wms.deliveries))
+	for _, wd := range wms.deliveries {
+		deliveries = append(deliveries, wd)
+	}
+
+	sortNewestFirst(deliveries)
+	return deliveries, nil
+}
+
+// ExpireStale removes the deliveries finished for longer than the retention.
+// It returns the number of deliveries removed, and never fails.
+func (wms *WebhookInMemoryStore) ExpireStale(now time.Time) (count int, err error) {
+	wms.mu.Lock()
+	defer wms.mu.Unlock()
+
+	for id, wd := range wms.deliveries {
+		if isStale(wd, now, wms.retention) {
+			delete(wms.deliveries, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// isDue returns true if the delivery is pending and its next attempt is due at the provided time.
+func isDue(wd core.WebhookDelivery, now time.Time) bool {
+	return wd.Status == core.WebhookDeliveryStatus_Pending && !wd.NextAttemptAt.After(now)
+}
+
+// isStale returns true if the delivery has been finished for longer than the retention.
+func isStale(wd core.WebhookDelivery, now time.Time, retention time.Duration) bool {
+	finishedAt, ok := wd.FinishedAt()
+	return ok && now.After(finishedAt.Add(retention))
+}
+
+// sortDue sorts the deliveries by the time of their next attempt, then by creation time.
+func sortDue(deliveries []core.WebhookDelivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].NextAttemptAt.Equal(deliveries[j].NextAttemptAt) {
+			return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+		}
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+}
+
+// sortNewestFirst sorts the deliveries by creation time, newest first, and then by ID.
+func sortNewestFirst(deliveries []core.WebhookDelivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+}
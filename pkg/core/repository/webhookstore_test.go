@@ -0,0 +1,114 @@
+package repository_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookStore is implemented by both webhook stores.
+type webhookStore interface {
+	core.WebhookStore
+	core.Expirer
+}
+
+func newDelivery(t *testing.T, id string, createdAt time.Time) core.WebhookDelivery {
+	wd, err := core.NewWebhookDelivery(id, "http://localhost/webhooks", core.WebhookPayload{ID: "event-" + id}, createdAt)
+	require.NoError(t, err)
+	return wd
+}
+
+func testWebhookStore(t *testing.T, store webhookStore) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	deliveries, err := store.ListDeliveries()
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	require.NoError(t, store.Add([]core.WebhookDelivery{newDelivery(t, "2", now.Add(time.Second)), newDelivery(t, "1", now)}))
+	require.NoError(t, store.Add([]core.WebhookDelivery{newDelivery(t, "3", now.Add(time.Minute))}))
+
+	due, err := store.Due(now.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	assert.Equal(t, "1", due[0].ID)
+	assert.Equal(t, "2", due[1].ID)
+	assert.Equal(t, "http://localhost/webhooks", due[0].URL)
+	assert.Equal(t, "event-1", due[0].EventID)
+
+	// The first delivery succeeds, the second one is retried later
+	delivered := due[0]
+	delivered.RecordAttempt(core.WebhookAttempt{At: now.Add(time.Second), StatusCode: 200}, 3, time.Minute)
+	require.NoError(t, store.Update(delivered))
+	retried := due[1]
+	retried.RecordAttempt(core.WebhookAttempt{At: now.Add(time.Second), StatusCode: 500}, 3, time.Minute)
+	require.NoError(t, store.Update(retried))
+
+	due, err = store.Due(now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, "3", due[0].ID)
+
+	due, err = store.Due(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	assert.Equal(t, "3", due[0].ID)
+	assert.Equal(t, "2", due[1].ID)
+	assert.Len(t, due[1].Attempts, 1)
+
+	deliveries, err = store.ListDeliveries()
+	require.NoError(t, err)
+	require.Len(t, deliveries, 3)
+	assert.Equal(t, "3", deliveries[0].ID)
+	assert.Equal(t, "2", deliveries[1].ID)
+	assert.Equal(t, "1", deliveries[2].ID)
+	assert.Equal(t, core.WebhookDeliveryStatus_Delivered, deliveries[2].Status)
+
+	// Only finished deliveries are removed once the retention has elapsed
+	assert.Equal(t, 0, expireStale(t, store, now.Add(time.Hour)))
+	assert.Equal(t, 1, expireStale(t, store, now.Add(2*time.Hour)))
+
+	deliveries, err = store.ListDeliveries()
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "3", deliveries[0].ID)
+	assert.Equal(t, "2", deliveries[1].ID)
+}
+
+func TestWebhookInMemoryStore(t *testing.T) {
+	testWebhookStore(t, repository.NewWebhookInMemoryStore(time.Hour))
+}
+
+func TestWebhookBoltStore(t *testing.T) {
+	store, err := repository.NewWebhookBoltStore(filepath.Join(t.TempDir(), "webhooks.db"), time.Hour)
+	require.NoError(t, err)
+	defer store.ShutDown(context.Background())
+
+	testWebhookStore(t, store)
+}
+
+func TestWebhookBoltStoreSurvivesRestart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "webhooks.db")
+	now := time.Now()
+
+	store, err := repository.NewWebhookBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, store.Add([]core.WebhookDelivery{newDelivery(t, "1", now)}))
+	require.NoError(t, store.ShutDown(context.Background()))
+
+	store, err = repository.NewWebhookBoltStore(filename, time.Hour)
+	require.NoError(t, err)
+	defer store.ShutDown(context.Background())
+
+	due, err := store.Due(now)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, "1", due[0].ID)
+	assert.Equal(t, core.WebhookDeliveryStatus_Pending, due[0].Status)
+}
@@ -0,0 +1,392 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OperationRequest holds the details of an operation checked against the credit card rules.
+type OperationRequest struct {
+	Operation CCFailReason
+	// Card holds the card details. The CVV is only known on authorisation.
+	Card CreditCard
+	// Amount is the amount of the operation, or the authorised amount for voids.
+	Amount Money
+	// Headers holds the headers of the request.
+	Headers http.Header
+}
+
+// Outcome holds what the processor does with an operation.
+type Outcome struct {
+	Action OutcomeAction `yaml:"outcome"`
+	// Reason is the reason declined operations are declined for.
+	Reason DeclineReason `yaml:"reason"`
+	// HTTPStatus is the HTTP status failed operations are reported with.
+	HTTPStatus int `yaml:"httpStatus"`
+	// Fault is how the response misbehaves for faulty operations.
+	Fault Fault `yaml:"fault"`
+	// Delay is how long to wait before responding, for any action.
+	Delay time.Duration `yaml:"delay"`
+	// Latency is a distribution of how long to wait before responding, on top of the delay.
+	Latency *Latency `yaml:"latency"`
+}
+
+// ResponseDelay returns how long to wait before responding, drawn from the latency distribution if set.
+func (o Outcome) ResponseDelay(random *Random) time.Duration {
+	delay := o.Delay
+	if o.Latency != nil {
+		delay += o.Latency.Sample(random)
+	}
+	return delay
+}
+
+// validate checks the outcome is complete, setting the defaults of the action.
+func (o *Outcome) validate() error {
+	switch o.Action {
+	case OutcomeAction_Approve:
+	case OutcomeAction_Decline:
+		if o.Reason == 0 {
+			o.Reason = DeclineReason_DoNotHonour
+		}
+	case OutcomeAction_Error:
+		if o.HTTPStatus == 0 {
+			o.HTTPStatus = 500
+		}
+		if o.HTTPStatus < 400 || o.HTTPStatus > 599 {
+			return fmt.Errorf("http status <%d> is not an error status", o.HTTPStatus)
+		}
+	case OutcomeAction_Fault:
+		if o.Fault == 0 {
+			return errors.New("fault outcome without a fault")
+		}
+		if o.Fault == Fault_NoBody {
+			if o.HTTPStatus == 0 {
+				o.HTTPStatus = 500
+			}
+			if o.HTTPStatus < 400 || o.HTTPStatus > 599 {
+				return fmt.Errorf("http status <%d> is not an error status", o.HTTPStatus)
+			}
+		}
+	case OutcomeAction_Delay:
+		if o.Delay <= 0 && o.Latency == nil {
+			return errors.New("delay outcome without a delay or latency")
+		}
+	default:
+		return errors.New("missing outcome")
+	}
+	if o.Delay < 0 {
+		return errors.New("negative delay")
+	}
+	return nil
+}
+
+// Rule sets the outcome of the operations it matches.
+//
+// In the yaml file, all the match criteria are optional and must all match:
+//
+//	rules:
+//	  - name: "slow amex refunds"
+//	    match:
+//	      operations: ["refund"]
+//	      cardNumbers: [378282246310005]
+//	      binPrefixes: ["34", "37"]
+//	      cardNumberRange: {from: 340000000000000, to: 349999999999999}
+//	      amountRange: {min: "100.00", max: "500"}
+//	      currencies: ["EUR", "GBP"]
+//	      cardholderName: "^TEST "
+//	      expiry: {from: "2030-01", to: "2030-12"}
+//	      headers: {X-Scenario: "^slow-refund$"}
+//	    outcome: "delay"
+//	    delay: "2s"
+//	    latency: {uniform: {min: "0s", max: "500ms"}}
+type Rule struct {
+	Name    string    `yaml:"name"`
+	Match   RuleMatch `yaml:"match"`
+	Outcome `yaml:",inline"`
+}
+
+// UnmarshalYAML unmarshals a yaml mapping to a Rule, validating its outcome.
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// Alias the type so the mapping is decoded without calling this method again
+	type rule Rule
+	var result rule
+	err := unmarshal(&result)
+	if err != nil {
+		return err
+	}
+
+	if err := result.Outcome.validate(); err != nil {
+		return fmt.Errorf("rule <%s>: %w", result.Name, err)
+	}
+
+	*r = Rule(result)
+	return nil
+}
+
+// RuleMatch holds the criteria an operation must meet for a rule to apply.
+// Empty criteria match any operation.
+type RuleMatch struct {
+	Operations      []CCFailReason   `yaml:"operations"`
+	CardNumbers     []int64          `yaml:"cardNumbers"`
+	BINPrefixes     []string         `yaml:"binPrefixes"`
+	CardNumberRange *CardNumberRange `yaml:"cardNumberRange"`
+	AmountRange     *AmountRange     `yaml:"amountRange"`
+	Currencies      []string         `yaml:"currencies"`
+	CardholderName  *Pattern         `yaml:"cardholderName"`
+	Expiry          *ExpiryRange     `yaml:"expiry"`
+	// Headers match the value of each header against a pattern, missing headers having an empty value.
+	Headers map[string]*Pattern `yaml:"headers"`
+}
+
+// Matches returns true if the operation meets all the criteria.
+func (m RuleMatch) Matches(request OperationRequest) bool {
+	if len(m.Operations) > 0 && !containsOperation(m.Operations, request.Operation) {
+		return false
+	}
+
+	if len(m.CardNumbers) > 0 && !containsCardNumber(m.CardNumbers, request.Card.Number) {
+		return false
+	}
+
+	if len(m.BINPrefixes) > 0 {
+		number := strconv.FormatInt(request.Card.Number, 10)
+		matched := false
+		for _, prefix := range m.BINPrefixes {
+			if strings.HasPrefix(number, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if m.CardNumberRange != nil && !m.CardNumberRange.Contains(request.Card.Number) {
+		return false
+	}
+	if m.AmountRange != nil && !m.AmountRange.Contains(request.Amount) {
+		return false
+	}
+	if len(m.Currencies) > 0 && !containsString(m.Currencies, request.Amount.Currency) {
+		return false
+	}
+	if m.CardholderName != nil && !m.CardholderName.MatchString(request.Card.Name) {
+		return false
+	}
+	if m.Expiry != nil && !m.Expiry.Contains(request.Card.ExpiryYear, request.Card.ExpiryMonth) {
+		return false
+	}
+	for name, pattern := range m.Headers {
+		if pattern != nil && !pattern.MatchString(request.Headers.Get(name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// EvaluateRules returns the outcome of the first rule matching the operation.
+// It returns false if no rule matches.
+func EvaluateRules(rules []Rule, request OperationRequest) (outcome Outcome, ok bool) {
+	for _, rule := range rules {
+		if rule.Match.Matches(request) {
+			return rule.Outcome, true
+		}
+	}
+	return Outcome{}, false
+}
+
+// CardNumberRange matches card numbers between From and To, both included.
+type CardNumberRange struct {
+	From int64 `yaml:"from"`
+	To   int64 `yaml:"to"`
+}
+
+// Contains returns true if the card number is within the range.
+func (r CardNumberRange) Contains(number int64) bool {
+	return number >= r.From && number <= r.To
+}
+
+// AmountRange matches amounts between Min and Max in major units, both included, whatever the currency.
+// Either bound can be left out.
+type AmountRange struct {
+	min, max *big.Rat
+}
+
+// UnmarshalYAML unmarshals a yaml mapping with decimal min and max amounts to an AmountRange.
+func (ar *AmountRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Min string `yaml:"min"`
+		Max string `yaml:"max"`
+	}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	*ar, err = NewAmountRange(raw.Min, raw.Max)
+	return err
+}
+
+// NewAmountRange returns an AmountRange between the decimal min and max amounts.
+// An empty bound is left out.
+func NewAmountRange(min, max string) (ar AmountRange, err error) {
+	if min != "" {
+		if ar.min, err = parseDecimal(min); err != nil {
+			return AmountRange{}, err
+		}
+	}
+	if max != "" {
+		if ar.max, err = parseDecimal(max); err != nil {
+			return AmountRange{}, err
+		}
+	}
+	return ar, nil
+}
+
+// Contains returns true if the amount is within the range.
+func (ar AmountRange) Contains(amount Money) bool {
+	value := majorUnits(amount)
+
+	if ar.min != nil && value.Cmp(ar.min) < 0 {
+		return false
+	}
+	if ar.max != nil && value.Cmp(ar.max) > 0 {
+		return false
+	}
+	return true
+}
+
+// ExpiryRange matches card expiry dates between From and To, both included, formatted as "YYYY-MM".
+// Either bound can be left out.
+type ExpiryRange struct {
+	// from and to are the number of months since year 0, zero when unbounded
+	from, to int
+}
+
+// UnmarshalYAML unmarshals a yaml mapping with "YYYY-MM" from and to dates to an ExpiryRange.
+func (er *ExpiryRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+	}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	var result ExpiryRange
+	if raw.From != "" {
+		if result.from, err = parseYearMonth(raw.From); err != nil {
+			return err
+		}
+	}
+	if raw.To != "" {
+		if result.to, err = parseYearMonth(raw.To); err != nil {
+			return err
+		}
+	}
+
+	*er = result
+	return nil
+}
+
+// Contains returns true if the expiry date is within the range.
+func (er ExpiryRange) Contains(year int, month int) bool {
+	value := year*12 + month
+	if er.from != 0 && value < er.from {
+		return false
+	}
+	if er.to != 0 && value > er.to {
+		return false
+	}
+	return true
+}
+
+// Pattern is a regular expression unmarshalled from a quoted yaml string.
+type Pattern struct {
+	*regexp.Regexp
+}
+
+// UnmarshalYAML unmarshals a quoted yaml string to a Pattern.
+func (p *Pattern) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var j string
+	err := unmarshal(&j)
+	if err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile(j)
+	if err != nil {
+		return fmt.Errorf("invalid pattern <%s>: %w", j, err)
+	}
+
+	p.Regexp = re
+	return nil
+}
+
+// majorUnits returns the amount in major units of its currency, e.g. 10.50 for 1050 cents.
+func majorUnits(amount Money) *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(amount.MinorUnits),
+		new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(CurrencyExponent(amount.Currency))), nil))
+}
+
+// parseDecimal parses a decimal number like "10.50", with the grammar of the amounts parsed by ParseMoney.
+func parseDecimal(s string) (*big.Rat, error) {
+	negative, intPart, fracPart, ok := splitDecimal(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount <%s>", s)
+	}
+
+	digits, _ := new(big.Int).SetString(intPart+fracPart, 10)
+	if negative {
+		digits.Neg(digits)
+	}
+	return new(big.Rat).SetFrac(digits, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(len(fracPart))), nil)), nil
+}
+
+// parseYearMonth parses a "YYYY-MM" date and returns the number of months since year 0.
+func parseYearMonth(s string) (int, error) {
+	t, err := time.Parse("2006-01", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiry date <%s>, expected YYYY-MM", s)
+	}
+	return t.Year()*12 + int(t.Month()), nil
+}
+
+// containsOperation returns true if the operation is in the list.
+func containsOperation(operations []CCFailReason, operation CCFailReason) bool {
+	for _, o := range operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// containsCardNumber returns true if the card number is in the list.
+func containsCardNumber(numbers []int64, number int64) bool {
+	for _, n := range numbers {
+		if n == number {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if the string is in the list.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package core_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRuleUnmarshal(t *testing.T) {
+	fixedTwoSeconds := core.FixedLatency(2 * time.Second)
+
+	tests := map[string]struct {
+		input           string
+		expectedErr     bool
+		expectedOutcome core.Outcome
+	}{
+		"approve": {
+			input:           `{name: "r", outcome: "approve"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Approve},
+		},
+		"decline with default reason": {
+			input:           `{name: "r", outcome: "decline"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_DoNotHonour},
+		},
+		"decline with reason": {
+			input:           `{name: "r", outcome: "decline", reason: "lost_card"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_LostCard},
+		},
+		"error with default status": {
+			input:           `{name: "r", outcome: "error"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 500},
+		},
+		"error with status": {
+			input:           `{name: "r", outcome: "error", httpStatus: 503}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Error, HTTPStatus: 503},
+		},
+		"delay": {
+			input:           `{name: "r", outcome: "delay", delay: "2s"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Delay, Delay: 2 * time.Second},
+		},
+		"decline after a delay": {
+			input:           `{name: "r", outcome: "decline", delay: "150ms"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_DoNotHonour, Delay: 150 * time.Millisecond},
+		},
+		"delay from a latency distribution": {
+			input:           `{name: "r", outcome: "delay", latency: "2s"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Delay, Latency: &fixedTwoSeconds},
+		},
+		"fault": {
+			input:           `{name: "r", outcome: "fault", fault: "connection_reset"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Fault, Fault: core.Fault_ConnectionReset},
+		},
+		"fault without a body": {
+			input:           `{name: "r", outcome: "fault", fault: "no_body", httpStatus: 503}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Fault, Fault: core.Fault_NoBody, HTTPStatus: 503},
+		},
+		"fault without a body with default status": {
+			input:           `{name: "r", outcome: "fault", fault: "no_body"}`,
+			expectedOutcome: core.Outcome{Action: core.OutcomeAction_Fault, Fault: core.Fault_NoBody, HTTPStatus: 500},
+		},
+		"fault without a fault": {
+			input:       `{name: "r", outcome: "fault"}`,
+			expectedErr: true,
+		},
+		"unknown fault": {
+			input:       `{name: "r", outcome: "fault", fault: "explode"}`,
+			expectedErr: true,
+		},
+		"missing outcome": {
+			input:       `{name: "r"}`,
+			expectedErr: true,
+		},
+		"unknown outcome": {
+			input:       `{name: "r", outcome: "explode"}`,
+			expectedErr: true,
+		},
+		"error with non error status": {
+			input:       `{name: "r", outcome: "error", httpStatus: 200}`,
+			expectedErr: true,
+		},
+		"delay without a delay": {
+			input:       `{name: "r", outcome: "delay"}`,
+			expectedErr: true,
+		},
+		"negative delay": {
+			input:       `{name: "r", outcome: "approve", delay: "-1s"}`,
+			expectedErr: true,
+		},
+		"invalid amount": {
+			input:       `{name: "r", outcome: "approve", match: {amountRange: {min: "ten"}}}`,
+			expectedErr: true,
+		},
+		"fraction amount": {
+			input:       `{name: "r", outcome: "approve", match: {amountRange: {min: "1/3"}}}`,
+			expectedErr: true,
+		},
+		"exponent amount": {
+			input:       `{name: "r", outcome: "approve", match: {amountRange: {max: "1e3"}}}`,
+			expectedErr: true,
+		},
+		"invalid expiry": {
+			input:       `{name: "r", outcome: "approve", match: {expiry: {from: "12/2030"}}}`,
+			expectedErr: true,
+		},
+		"invalid cardholder name pattern": {
+			input:       `{name: "r", outcome: "approve", match: {cardholderName: "(unclosed"}}`,
+			expectedErr: true,
+		},
+		"unknown operation": {
+			input:       `{name: "r", outcome: "approve", match: {operations: ["explode"]}}`,
+			expectedErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var rule core.Rule
+			err := yaml.Unmarshal([]byte(test.input), &rule)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOutcome, rule.Outcome)
+		})
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	card := core.CreditCard{Name: "TEST customer", Number: 4000000000000119, ExpiryMonth: 10, ExpiryYear: 2030}
+	request := core.OperationRequest{Operation: core.CCFailReason_Capture, Card: card, Amount: eur(1050),
+		Headers: http.Header{"X-Scenario": []string{"slow-refund"}}}
+
+	tests := map[string]struct {
+		match          string
+		expectedResult bool
+	}{
+		"no criteria":                   {match: `{}`, expectedResult: true},
+		"operation":                     {match: `{operations: ["authorise", "capture"]}`, expectedResult: true},
+		"other operation":               {match: `{operations: ["refund fail"]}`, expectedResult: false},
+		"card number":                   {match: `{cardNumbers: [4000000000000259, 4000000000000119]}`, expectedResult: true},
+		"other card number":             {match: `{cardNumbers: [4000000000000259]}`, expectedResult: false},
+		"BIN prefix":                    {match: `{binPrefixes: ["5", "4000"]}`, expectedResult: true},
+		"other BIN prefix":              {match: `{binPrefixes: ["5", "41"]}`, expectedResult: false},
+		"card number range":             {match: `{cardNumberRange: {from: 4000000000000000, to: 4000000000000999}}`, expectedResult: true},
+		"card number out of range":      {match: `{cardNumberRange: {from: 4000000000000120, to: 4000000000000999}}`, expectedResult: false},
+		"amount range":                  {match: `{amountRange: {min: "10", max: "10.50"}}`, expectedResult: true},
+		"amount above range":            {match: `{amountRange: {max: "10.49"}}`, expectedResult: false},
+		"amount below range":            {match: `{amountRange: {min: "10.51"}}`, expectedResult: false},
+		"currency":                      {match: `{currencies: ["GBP", "EUR"]}`, expectedResult: true},
+		"other currency":                {match: `{currencies: ["GBP"]}`, expectedResult: false},
+		"cardholder name":               {match: `{cardholderName: "^TEST "}`, expectedResult: true},
+		"other cardholder name":         {match: `{cardholderName: "^QA "}`, expectedResult: false},
+		"expiry range":                  {match: `{expiry: {from: "2030-10", to: "2031-01"}}`, expectedResult: true},
+		"expiry before range":           {match: `{expiry: {from: "2030-11"}}`, expectedResult: false},
+		"expiry after range":            {match: `{expiry: {to: "2030-09"}}`, expectedResult: false},
+		"header":                        {match: `{headers: {x-scenario: "^slow-"}}`, expectedResult: true},
+		"other header value":            {match: `{headers: {X-Scenario: "^fast-"}}`, expectedResult: false},
+		"missing header":                {match: `{headers: {X-Other: "."}}`, expectedResult: false},
+		"all criteria":                  {match: `{operations: ["capture"], binPrefixes: ["4"], currencies: ["EUR"], cardholderName: "customer$"}`, expectedResult: true},
+		"all criteria but one matching": {match: `{operations: ["capture"], binPrefixes: ["4"], currencies: ["GBP"], cardholderName: "customer$"}`, expectedResult: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var match core.RuleMatch
+			err := yaml.Unmarshal([]byte(test.match), &match)
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectedResult, match.Matches(request))
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	input := `
+- name: "large amounts declined"
+  match: {amountRange: {min: "1000"}}
+  outcome: "decline"
+  reason: "limit_exceeded"
+- name: "everything else on the BIN approved"
+  match: {binPrefixes: ["4000"]}
+  outcome: "approve"
+`
+	var rules []core.Rule
+	err := yaml.Unmarshal([]byte(input), &rules)
+	require.NoError(t, err)
+
+	card := core.CreditCard{Number: 4000000000000119}
+
+	outcome, ok := core.EvaluateRules(rules, core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: card, Amount: eur(200000)})
+	require.True(t, ok)
+	assert.Equal(t, core.Outcome{Action: core.OutcomeAction_Decline, Reason: core.DeclineReason_LimitExceeded}, outcome)
+
+	outcome, ok = core.EvaluateRules(rules, core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: card, Amount: eur(1050)})
+	require.True(t, ok)
+	assert.Equal(t, core.Outcome{Action: core.OutcomeAction_Approve}, outcome)
+
+	_, ok = core.EvaluateRules(rules, core.OperationRequest{Operation: core.CCFailReason_Authorise, Card: core.CreditCard{Number: 5555555555554444}, Amount: eur(1050)})
+	assert.False(t, ok)
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SettlementSchedule sets when captures pending settlement settle:
+// either after a delay, or in a daily batch at a cut-off time.
+type SettlementSchedule struct {
+	// Delay is how long after the capture it settles, when there is no cut-off.
+	Delay time.Duration
+	// CutOff is the time of the day (UTC), since midnight, captures settle at when AtCutOff is set.
+	CutOff   time.Duration
+	AtCutOff bool
+}
+
+// SettleAt returns the time the capture made at the provided time settles at.
+// With a cut-off, that's the next cut-off strictly after the capture.
+func (ss SettlementSchedule) SettleAt(capturedAt time.Time) time.Time {
+	if !ss.AtCutOff {
+		return capturedAt.Add(ss.Delay)
+	}
+
+	settleAt := capturedAt.UTC().Truncate(24 * time.Hour).Add(ss.CutOff)
+	if !settleAt.After(capturedAt) {
+		settleAt = settleAt.Add(24 * time.Hour)
+	}
+	return settleAt
+}
+
+// ParseCutOff parses a time of the day in 24h format, e.g. "17:30", and returns it as a duration since midnight.
+func ParseCutOff(cutOff string) (time.Duration, error) {
+	t, err := time.Parse("15:04", cutOff)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM <%s>", cutOff)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// DueSettlements returns the transactions pending settlement due at the provided time,
+// the ones due first first, with ties broken by UID.
+func DueSettlements(transactions []ListedTransaction, now time.Time) []ListedTransaction {
+	var due []ListedTransaction
+	for _, tx := range transactions {
+		if tx.State == TransactionState_PendingSettlement && !tx.SettleAt.After(now) {
+			due = append(due, tx)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].SettleAt.Equal(due[j].SettleAt) {
+			return due[i].SettleAt.Before(due[j].SettleAt)
+		}
+		return due[i].UID < due[j].UID
+	})
+	return due
+}
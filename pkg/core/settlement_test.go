@@ -0,0 +1,113 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettlementScheduleSettleAt(t *testing.T) {
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	cutOff := core.SettlementSchedule{CutOff: 17 * time.Hour, AtCutOff: true}
+
+	tests := map[string]struct {
+		schedule       core.SettlementSchedule
+		capturedAt     time.Time
+		expectedOutput time.Time
+	}{
+		"delay": {
+			schedule:       core.SettlementSchedule{Delay: 30 * time.Second},
+			capturedAt:     day.Add(12 * time.Hour),
+			expectedOutput: day.Add(12*time.Hour + 30*time.Second),
+		},
+		"no delay": {
+			schedule:       core.SettlementSchedule{},
+			capturedAt:     day.Add(12 * time.Hour),
+			expectedOutput: day.Add(12 * time.Hour),
+		},
+		"before the cut-off": {
+			schedule:       cutOff,
+			capturedAt:     day.Add(12 * time.Hour),
+			expectedOutput: day.Add(17 * time.Hour),
+		},
+		"at the cut-off": {
+			schedule:       cutOff,
+			capturedAt:     day.Add(17 * time.Hour),
+			expectedOutput: day.Add(41 * time.Hour),
+		},
+		"after the cut-off": {
+			schedule:       cutOff,
+			capturedAt:     day.Add(23 * time.Hour),
+			expectedOutput: day.Add(41 * time.Hour),
+		},
+		"cut-off in another time zone": {
+			schedule:       cutOff,
+			capturedAt:     day.Add(16 * time.Hour).In(time.FixedZone("UTC+2", 2*60*60)),
+			expectedOutput: day.Add(17 * time.Hour),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			settleAt := test.schedule.SettleAt(test.capturedAt)
+			assert.True(t, test.expectedOutput.Equal(settleAt), "expected %s, got %s", test.expectedOutput, settleAt)
+		})
+	}
+}
+
+func TestParseCutOff(t *testing.T) {
+	tests := map[string]struct {
+		input          string
+		expectedErr    bool
+		expectedOutput time.Duration
+	}{
+		"midnight":          {input: "00:00", expectedOutput: 0},
+		"afternoon":         {input: "17:30", expectedOutput: 17*time.Hour + 30*time.Minute},
+		"last minute":       {input: "23:59", expectedOutput: 23*time.Hour + 59*time.Minute},
+		"empty":             {input: "", expectedErr: true},
+		"hour out of range": {input: "24:00", expectedErr: true},
+		"with seconds":      {input: "17:30:00", expectedErr: true},
+		"not a time":        {input: "5pm", expectedErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cutOff, err := core.ParseCutOff(test.input)
+			if test.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOutput, cutOff)
+		})
+	}
+}
+
+func TestDueSettlements(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	pending := func(uid string, settleAt time.Time) core.ListedTransaction {
+		tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), now.Add(-time.Hour), time.Hour)
+		tx.State = core.TransactionState_PendingSettlement
+		tx.SettleAt = settleAt
+		return core.ListedTransaction{UID: uid, Transaction: tx}
+	}
+	captured := pending("captured", now.Add(-time.Hour))
+	captured.State = core.TransactionState_Captured
+
+	due := core.DueSettlements([]core.ListedTransaction{
+		pending("later", now.Add(time.Second)),
+		pending("b", now.Add(-time.Minute)),
+		captured,
+		pending("now", now),
+		pending("a", now.Add(-time.Minute)),
+	}, now)
+
+	var uids []string
+	for _, tx := range due {
+		uids = append(uids, tx.UID)
+	}
+	assert.Equal(t, []string{"a", "b", "now"}, uids)
+}
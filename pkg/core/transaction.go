@@ -0,0 +1,253 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAuthorisationNotFound is returned when there is no transaction for the provided authorisation ID.
+var ErrAuthorisationNotFound = errors.New("authorisation not found")
+
+// Errors returned when an operation is not allowed in the current state of the transaction.
+var (
+	ErrAuthorisationExpired       = errors.New("authorisation has expired")
+	ErrTransactionVoided          = errors.New("transaction has been voided")
+	ErrTransactionAlreadyCaptured = errors.New("transaction has already been captured")
+	ErrTransactionNotCaptured     = errors.New("transaction has not been captured")
+	ErrTransactionFullyRefunded   = errors.New("transaction has been fully refunded")
+	ErrTransactionNotSettled      = errors.New("transaction has not settled yet")
+	ErrSettlementFailed           = errors.New("transaction has failed to settle")
+)
+
+// ErrTransactionNotPendingSettlement is returned when settling a transaction that isn't pending settlement.
+var ErrTransactionNotPendingSettlement = errors.New("transaction is not pending settlement")
+
+// Errors returned when the amount of an operation is not covered by the transaction.
+var (
+	ErrAmountExceedsAuthorised = errors.New("amount exceeds the authorised amount")
+	ErrAmountExceedsCaptured   = errors.New("amount exceeds the captured amount still available for refund")
+)
+
+// Transaction holds the state of an authorisation and everything that happened to it afterwards.
+// All amounts are in the currency of the authorisation.
+type Transaction struct {
+	CCNumber       int64  `json:"cc_number"`
+	CardholderName string `json:"cardholder_name,omitempty"`
+	ExpiryMonth    int    `json:"expiry_month,omitempty"`
+	ExpiryYear     int    `json:"expiry_year,omitempty"`
+
+	State            TransactionState `json:"state"`
+	AuthorisedAmount Money            `json:"authorised_amount"`
+	CapturedAmount   Money            `json:"captured_amount"`
+	RefundedAmount   Money            `json:"refunded_amount"`
+	CreatedAt        time.Time        `json:"created_at"`
+	// ExpiresAt is the time after which the authorisation can no longer be captured or voided.
+	// The zero value means the authorisation never expires.
+	ExpiresAt time.Time `json:"expires_at"`
+	// SettleAt is the time a capture pending settlement is due to settle at.
+	// The zero value means the transaction isn't pending settlement.
+	SettleAt time.Time `json:"settle_at"`
+
+	// Events are the operations that changed the transaction, oldest first.
+	Events []TransactionEvent `json:"events,omitempty"`
+}
+
+// TransactionEvent records an operation that changed a transaction.
+type TransactionEvent struct {
+	Type TransactionEventType `json:"type"`
+	// Amount is the amount moved by the operation, which is the authorised amount released for voids and expiries.
+	Amount    Money     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+	// Reason is why the settlement failed, for settlement failures.
+	Reason DeclineReason `json:"reason,omitempty"`
+}
+
+// NewTransaction returns a new authorised transaction.
+// The card details are kept, except for the CVV, so later operations can be checked against the rules too.
+// A ttl of zero means the authorisation never expires.
+func NewTransaction(card CreditCard, amount Money, createdAt time.Time, ttl time.Duration) Transaction {
+	tx := Transaction{
+		CCNumber:         card.Number,
+		CardholderName:   card.Name,
+		ExpiryMonth:      card.ExpiryMonth,
+		ExpiryYear:       card.ExpiryYear,
+		State:            TransactionState_Authorised,
+		AuthorisedAmount: amount,
+		CapturedAmount:   Money{Currency: amount.Currency},
+		RefundedAmount:   Money{Currency: amount.Currency},
+		CreatedAt:        createdAt,
+		Events:           []TransactionEvent{{Type: TransactionEventType_Authorised, Amount: amount, CreatedAt: createdAt}},
+	}
+	if ttl > 0 {
+		tx.ExpiresAt = createdAt.Add(ttl)
+	}
+	return tx
+}
+
+// Card returns the details of the card used in the authorisation, without the CVV.
+func (t *Transaction) Card() CreditCard {
+	return CreditCard{Name: t.CardholderName, Number: t.CCNumber, ExpiryMonth: t.ExpiryMonth, ExpiryYear: t.ExpiryYear}
+}
+
+// Currency returns the currency of the transaction.
+func (t *Transaction) Currency() string {
+	return t.AuthorisedAmount.Currency
+}
+
+// RemainingBalance returns the amount that can still be moved by the next operation.
+// That is the authorised amount before capture, and the captured amount not yet refunded after capture.
+func (t *Transaction) RemainingBalance() Money {
+	switch t.State {
+	case TransactionState_Authorised:
+		return t.AuthorisedAmount
+	case TransactionState_Captured, TransactionState_PartiallyRefunded, TransactionState_PendingSettlement:
+		return Money{MinorUnits: t.CapturedAmount.MinorUnits - t.RefundedAmount.MinorUnits, Currency: t.Currency()}
+	default:
+		return Money{Currency: t.Currency()}
+	}
+}
+
+// Expire moves an authorised transaction past its expiry time to the expired state.
+// It returns true if the transaction has been expired by this call.
+func (t *Transaction) Expire(now time.Time) bool {
+	if t.State != TransactionState_Authorised || t.ExpiresAt.IsZero() || now.Before(t.ExpiresAt) {
+		return false
+	}
+	t.State = TransactionState_Expired
+	t.addEvent(TransactionEventType_Expired, t.AuthorisedAmount, t.ExpiresAt)
+	return true
+}
+
+// Stale returns true if the transaction has been expired or voided for longer than the TTL.
+// Captured and refunded transactions are never stale, as merchants may still look them up.
+func (t Transaction) Stale(now time.Time, ttl time.Duration) bool {
+	if t.State != TransactionState_Expired && t.State != TransactionState_Voided {
+		return false
+	}
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt.Add(ttl))
+}
+
+// Capture moves the transaction to the captured state at the provided time.
+// Only authorised transactions can be captured, for up to the authorised amount.
+func (t *Transaction) Capture(amount Money, now time.Time) error {
+	return t.capture(amount, now, TransactionState_Captured)
+}
+
+// CaptureForSettlement moves the transaction to the pending settlement state at the provided time,
+// to be settled (or to fail to settle) at settleAt. It's otherwise allowed like Capture.
+func (t *Transaction) CaptureForSettlement(amount Money, now time.Time, settleAt time.Time) error {
+	err := t.capture(amount, now, TransactionState_PendingSettlement)
+	if err != nil {
+		return err
+	}
+	t.SettleAt = settleAt
+	return nil
+}
+
+// capture moves an authorised transaction to the provided state, either captured or pending settlement.
+func (t *Transaction) capture(amount Money, now time.Time, state TransactionState) error {
+	switch t.State {
+	case TransactionState_Authorised:
+		if amount.Currency != t.Currency() {
+			return ErrCurrencyMismatch
+		}
+		if amount.MinorUnits > t.AuthorisedAmount.MinorUnits {
+			return ErrAmountExceedsAuthorised
+		}
+		t.CapturedAmount = amount
+		t.State = state
+		t.addEvent(TransactionEventType_Captured, amount, now)
+		return nil
+	case TransactionState_Voided:
+		return ErrTransactionVoided
+	case TransactionState_Expired:
+		return ErrAuthorisationExpired
+	case TransactionState_SettlementFailed:
+		return ErrSettlementFailed
+	default:
+		return ErrTransactionAlreadyCaptured
+	}
+}
+
+// Settle moves a transaction pending settlement to the captured state at the provided time,
+// after which it can be refunded.
+func (t *Transaction) Settle(now time.Time) error {
+	if t.State != TransactionState_PendingSettlement {
+		return ErrTransactionNotPendingSettlement
+	}
+	t.State = TransactionState_Captured
+	t.SettleAt = time.Time{}
+	t.addEvent(TransactionEventType_Settled, t.CapturedAmount, now)
+	return nil
+}
+
+// FailSettlement moves a transaction pending settlement to the settlement failed state at the provided time,
+// recording why it failed. No money is moved, and nothing else can be done with the transaction.
+func (t *Transaction) FailSettlement(reason DeclineReason, now time.Time) error {
+	if t.State != TransactionState_PendingSettlement {
+		return ErrTransactionNotPendingSettlement
+	}
+	t.State = TransactionState_SettlementFailed
+	t.SettleAt = time.Time{}
+	t.Events = append(t.Events, TransactionEvent{Type: TransactionEventType_SettlementFailed, Amount: t.CapturedAmount,
+		CreatedAt: now, Reason: reason})
+	return nil
+}
+
+// Void moves the transaction to the voided state at the provided time.
+// Only authorised transactions can be voided, once captured the money has to be refunded instead.
+func (t *Transaction) Void(now time.Time) error {
+	switch t.State {
+	case TransactionState_Authorised:
+		t.State = TransactionState_Voided
+		t.addEvent(TransactionEventType_Voided, t.AuthorisedAmount, now)
+		return nil
+	case TransactionState_Voided:
+		return ErrTransactionVoided
+	case TransactionState_Expired:
+		return ErrAuthorisationExpired
+	case TransactionState_SettlementFailed:
+		return ErrSettlementFailed
+	default:
+		return ErrTransactionAlreadyCaptured
+	}
+}
+
+// Refund moves the transaction to the partially refunded or refunded state at the provided time.
+// Only captured (or partially refunded) transactions can be refunded, for up to the amount not yet refunded.
+func (t *Transaction) Refund(amount Money, now time.Time) error {
+	switch t.State {
+	case TransactionState_Captured, TransactionState_PartiallyRefunded:
+		if amount.Currency != t.Currency() {
+			return ErrCurrencyMismatch
+		}
+		if amount.MinorUnits > t.RemainingBalance().MinorUnits {
+			return ErrAmountExceedsCaptured
+		}
+		t.RefundedAmount.MinorUnits += amount.MinorUnits
+		if t.RefundedAmount.MinorUnits < t.CapturedAmount.MinorUnits {
+			t.State = TransactionState_PartiallyRefunded
+		} else {
+			t.State = TransactionState_Refunded
+		}
+		t.addEvent(TransactionEventType_Refunded, amount, now)
+		return nil
+	case TransactionState_Authorised:
+		return ErrTransactionNotCaptured
+	case TransactionState_PendingSettlement:
+		return ErrTransactionNotSettled
+	case TransactionState_SettlementFailed:
+		return ErrSettlementFailed
+	case TransactionState_Voided:
+		return ErrTransactionVoided
+	case TransactionState_Expired:
+		return ErrAuthorisationExpired
+	default:
+		return ErrTransactionFullyRefunded
+	}
+}
+
+// addEvent records an operation that changed the transaction.
+func (t *Transaction) addEvent(eventType TransactionEventType, amount Money, createdAt time.Time) {
+	t.Events = append(t.Events, TransactionEvent{Type: eventType, Amount: amount, CreatedAt: createdAt})
+}
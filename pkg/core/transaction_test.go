@@ -0,0 +1,277 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eur returns an amount of euros in cents.
+func eur(cents int64) core.Money {
+	return core.Money{MinorUnits: cents, Currency: "EUR"}
+}
+
+func TestTransactionStateMachine(t *testing.T) {
+	capture := func(amount int64) func(tx *core.Transaction) error {
+		return func(tx *core.Transaction) error { return tx.Capture(eur(amount), time.Now()) }
+	}
+	refund := func(amount int64) func(tx *core.Transaction) error {
+		return func(tx *core.Transaction) error { return tx.Refund(eur(amount), time.Now()) }
+	}
+	void := func(tx *core.Transaction) error { return tx.Void(time.Now()) }
+
+	tests := map[string]struct {
+		initialState     core.TransactionState
+		operation        func(tx *core.Transaction) error
+		expectedErr      error
+		expectedState    core.TransactionState
+		expectedBalance  int64
+		expectedCaptured int64
+		expectedRefunded int64
+	}{
+		"capture authorised": {
+			initialState:     core.TransactionState_Authorised,
+			operation:        capture(1000),
+			expectedState:    core.TransactionState_Captured,
+			expectedBalance:  1000,
+			expectedCaptured: 1000,
+		},
+		"capture less than authorised": {
+			initialState:     core.TransactionState_Authorised,
+			operation:        capture(400),
+			expectedState:    core.TransactionState_Captured,
+			expectedBalance:  400,
+			expectedCaptured: 400,
+		},
+		"capture more than authorised": {
+			initialState:    core.TransactionState_Authorised,
+			operation:       capture(1100),
+			expectedErr:     core.ErrAmountExceedsAuthorised,
+			expectedState:   core.TransactionState_Authorised,
+			expectedBalance: 1000,
+		},
+		"capture captured": {
+			initialState:     core.TransactionState_Captured,
+			operation:        capture(1000),
+			expectedErr:      core.ErrTransactionAlreadyCaptured,
+			expectedState:    core.TransactionState_Captured,
+			expectedBalance:  800,
+			expectedCaptured: 800,
+		},
+		"capture voided": {
+			initialState:  core.TransactionState_Voided,
+			operation:     capture(1000),
+			expectedErr:   core.ErrTransactionVoided,
+			expectedState: core.TransactionState_Voided,
+		},
+		"void authorised": {
+			initialState:  core.TransactionState_Authorised,
+			operation:     void,
+			expectedState: core.TransactionState_Voided,
+		},
+		"void voided": {
+			initialState:  core.TransactionState_Voided,
+			operation:     void,
+			expectedErr:   core.ErrTransactionVoided,
+			expectedState: core.TransactionState_Voided,
+		},
+		"void captured": {
+			initialState:     core.TransactionState_Captured,
+			operation:        void,
+			expectedErr:      core.ErrTransactionAlreadyCaptured,
+			expectedState:    core.TransactionState_Captured,
+			expectedBalance:  800,
+			expectedCaptured: 800,
+		},
+		"refund captured in full": {
+			initialState:     core.TransactionState_Captured,
+			operation:        refund(800),
+			expectedState:    core.TransactionState_Refunded,
+			expectedCaptured: 800,
+			expectedRefunded: 800,
+		},
+		"refund captured partially": {
+			initialState:     core.TransactionState_Captured,
+			operation:        refund(300),
+			expectedState:    core.TransactionState_PartiallyRefunded,
+			expectedBalance:  500,
+			expectedCaptured: 800,
+			expectedRefunded: 300,
+		},
+		"refund more than captured": {
+			initialState:     core.TransactionState_Captured,
+			operation:        refund(900),
+			expectedErr:      core.ErrAmountExceedsCaptured,
+			expectedState:    core.TransactionState_Captured,
+			expectedBalance:  800,
+			expectedCaptured: 800,
+		},
+		"refund authorised": {
+			initialState:    core.TransactionState_Authorised,
+			operation:       refund(500),
+			expectedErr:     core.ErrTransactionNotCaptured,
+			expectedState:   core.TransactionState_Authorised,
+			expectedBalance: 1000,
+		},
+		"refund refunded": {
+			initialState:     core.TransactionState_Refunded,
+			operation:        refund(500),
+			expectedErr:      core.ErrTransactionFullyRefunded,
+			expectedState:    core.TransactionState_Refunded,
+			expectedCaptured: 800,
+			expectedRefunded: 800,
+		},
+		"capture expired": {
+			initialState:  core.TransactionState_Expired,
+			operation:     capture(1000),
+			expectedErr:   core.ErrAuthorisationExpired,
+			expectedState: core.TransactionState_Expired,
+		},
+		"void expired": {
+			initialState:  core.TransactionState_Expired,
+			operation:     void,
+			expectedErr:   core.ErrAuthorisationExpired,
+			expectedState: core.TransactionState_Expired,
+		},
+		"refund expired": {
+			initialState:  core.TransactionState_Expired,
+			operation:     refund(500),
+			expectedErr:   core.ErrAuthorisationExpired,
+			expectedState: core.TransactionState_Expired,
+		},
+		"refund voided": {
+			initialState:  core.TransactionState_Voided,
+			operation:     refund(500),
+			expectedErr:   core.ErrTransactionVoided,
+			expectedState: core.TransactionState_Voided,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), time.Now(), time.Hour)
+			tx.State = test.initialState
+			if test.initialState == core.TransactionState_Captured {
+				tx.CapturedAmount = eur(800)
+			} else if test.initialState == core.TransactionState_Refunded {
+				tx.CapturedAmount = eur(800)
+				tx.RefundedAmount = eur(800)
+			}
+
+			err := test.operation(&tx)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expectedState, tx.State)
+			assert.Equal(t, test.expectedBalance, tx.RemainingBalance().MinorUnits)
+			assert.Equal(t, test.expectedCaptured, tx.CapturedAmount.MinorUnits)
+			assert.Equal(t, test.expectedRefunded, tx.RefundedAmount.MinorUnits)
+		})
+	}
+}
+
+func TestTransactionPartialRefunds(t *testing.T) {
+	tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), time.Now(), time.Hour)
+
+	require.NoError(t, tx.Capture(eur(1000), time.Now()))
+	require.NoError(t, tx.Refund(eur(410), time.Now()))
+	assert.Equal(t, core.TransactionState_PartiallyRefunded, tx.State)
+	assert.Equal(t, eur(590), tx.RemainingBalance())
+
+	require.ErrorIs(t, tx.Refund(eur(591), time.Now()), core.ErrAmountExceedsCaptured)
+	require.ErrorIs(t, tx.Refund(core.Money{MinorUnits: 100, Currency: "GBP"}, time.Now()), core.ErrCurrencyMismatch)
+	require.NoError(t, tx.Refund(eur(590), time.Now()))
+	assert.Equal(t, core.TransactionState_Refunded, tx.State)
+	assert.Equal(t, eur(0), tx.RemainingBalance())
+}
+
+func TestTransactionExpire(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	assert.Equal(t, false, tx.Expire(createdAt.Add(59*time.Minute)))
+	assert.Equal(t, core.TransactionState_Authorised, tx.State)
+	assert.Equal(t, true, tx.Expire(createdAt.Add(time.Hour)))
+	assert.Equal(t, core.TransactionState_Expired, tx.State)
+	assert.Equal(t, false, tx.Expire(createdAt.Add(2*time.Hour)))
+
+	captured := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	require.NoError(t, captured.Capture(eur(1000), time.Now()))
+	assert.Equal(t, false, captured.Expire(createdAt.Add(2*time.Hour)))
+	assert.Equal(t, core.TransactionState_Captured, captured.State)
+
+	neverExpires := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, 0)
+	assert.Equal(t, false, neverExpires.Expire(createdAt.Add(24*365*time.Hour)))
+}
+
+func TestTransactionEvents(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	require.NoError(t, tx.Capture(eur(800), createdAt.Add(time.Minute)))
+	require.ErrorIs(t, tx.Capture(eur(800), createdAt.Add(2*time.Minute)), core.ErrTransactionAlreadyCaptured)
+	require.NoError(t, tx.Refund(eur(300), createdAt.Add(3*time.Minute)))
+
+	// Operations that fail don't change the transaction, so they aren't recorded
+	assert.Equal(t, []core.TransactionEvent{
+		{Type: core.TransactionEventType_Authorised, Amount: eur(1000), CreatedAt: createdAt},
+		{Type: core.TransactionEventType_Captured, Amount: eur(800), CreatedAt: createdAt.Add(time.Minute)},
+		{Type: core.TransactionEventType_Refunded, Amount: eur(300), CreatedAt: createdAt.Add(3 * time.Minute)},
+	}, tx.Events)
+
+	// Expiries are recorded at the expiry time, whenever they are noticed
+	expired := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	require.True(t, expired.Expire(createdAt.Add(5*time.Hour)))
+	assert.Equal(t, []core.TransactionEvent{
+		{Type: core.TransactionEventType_Authorised, Amount: eur(1000), CreatedAt: createdAt},
+		{Type: core.TransactionEventType_Expired, Amount: eur(1000), CreatedAt: createdAt.Add(time.Hour)},
+	}, expired.Events)
+}
+
+func TestTransactionSettlement(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	settleAt := createdAt.Add(time.Hour)
+
+	tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	require.NoError(t, tx.CaptureForSettlement(eur(800), createdAt.Add(time.Minute), settleAt))
+	assert.Equal(t, core.TransactionState_PendingSettlement, tx.State)
+	assert.Equal(t, settleAt, tx.SettleAt)
+	assert.Equal(t, eur(800), tx.RemainingBalance())
+	assert.Equal(t, false, tx.Expire(createdAt.Add(2*time.Hour)))
+
+	require.ErrorIs(t, tx.Capture(eur(800), time.Now()), core.ErrTransactionAlreadyCaptured)
+	require.ErrorIs(t, tx.Void(time.Now()), core.ErrTransactionAlreadyCaptured)
+	require.ErrorIs(t, tx.Refund(eur(100), time.Now()), core.ErrTransactionNotSettled)
+
+	require.NoError(t, tx.Settle(settleAt))
+	assert.Equal(t, core.TransactionState_Captured, tx.State)
+	require.ErrorIs(t, tx.Settle(settleAt), core.ErrTransactionNotPendingSettlement)
+	require.NoError(t, tx.Refund(eur(100), settleAt.Add(time.Minute)))
+
+	assert.Equal(t, []core.TransactionEvent{
+		{Type: core.TransactionEventType_Authorised, Amount: eur(1000), CreatedAt: createdAt},
+		{Type: core.TransactionEventType_Captured, Amount: eur(800), CreatedAt: createdAt.Add(time.Minute)},
+		{Type: core.TransactionEventType_Settled, Amount: eur(800), CreatedAt: settleAt},
+		{Type: core.TransactionEventType_Refunded, Amount: eur(100), CreatedAt: settleAt.Add(time.Minute)},
+	}, tx.Events)
+
+	failed := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	require.NoError(t, failed.CaptureForSettlement(eur(1000), createdAt, settleAt))
+	require.NoError(t, failed.FailSettlement(core.DeclineReason_InsufficientFunds, settleAt))
+	assert.Equal(t, core.TransactionState_SettlementFailed, failed.State)
+	assert.Equal(t, core.TransactionEvent{
+		Type: core.TransactionEventType_SettlementFailed, Amount: eur(1000), CreatedAt: settleAt,
+		Reason: core.DeclineReason_InsufficientFunds,
+	}, failed.Events[len(failed.Events)-1])
+
+	require.ErrorIs(t, failed.Settle(settleAt), core.ErrTransactionNotPendingSettlement)
+	require.ErrorIs(t, failed.Capture(eur(1000), time.Now()), core.ErrSettlementFailed)
+	require.ErrorIs(t, failed.Void(time.Now()), core.ErrSettlementFailed)
+	require.ErrorIs(t, failed.Refund(eur(100), time.Now()), core.ErrSettlementFailed)
+}
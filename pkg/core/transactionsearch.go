@@ -0,0 +1,133 @@
+package core
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListedTransaction is a transaction along with the UID of its authorisation.
+type ListedTransaction struct {
+	UID string
+	Transaction
+}
+
+// TransactionFilter selects transactions. Criteria left unset match all transactions.
+type TransactionFilter struct {
+	States []TransactionState
+	// CardLast4 are the last 4 digits of the card number.
+	CardLast4 string
+	Currency  string
+	// AuthorisedAmount is the range the authorised amount is within.
+	AuthorisedAmount *AmountRange
+	// CreatedFrom and CreatedTo bound the time the authorisation was created at, From included and To excluded.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// Matches returns true if the transaction meets all the criteria.
+func (f TransactionFilter) Matches(tx Transaction) bool {
+	if len(f.States) > 0 && !containsState(f.States, tx.State) {
+		return false
+	}
+	if f.CardLast4 != "" && !strings.HasSuffix(strconv.FormatInt(tx.CCNumber, 10), f.CardLast4) {
+		return false
+	}
+	if f.Currency != "" && tx.Currency() != f.Currency {
+		return false
+	}
+	if f.AuthorisedAmount != nil && !f.AuthorisedAmount.Contains(tx.AuthorisedAmount) {
+		return false
+	}
+	if !f.CreatedFrom.IsZero() && tx.CreatedAt.Before(f.CreatedFrom) {
+		return false
+	}
+	if !f.CreatedTo.IsZero() && !tx.CreatedAt.Before(f.CreatedTo) {
+		return false
+	}
+	return true
+}
+
+// TransactionCursor is the position of a transaction in a listing, from which the next page starts.
+// Transactions are listed newest first, the ones created at the same time ordered by UID.
+type TransactionCursor struct {
+	CreatedAt time.Time
+	UID       string
+}
+
+// NewTransactionCursor returns the position of the transaction in a listing.
+func NewTransactionCursor(tx ListedTransaction) TransactionCursor {
+	return TransactionCursor{CreatedAt: tx.CreatedAt, UID: tx.UID}
+}
+
+// String encodes the cursor as an opaque URL safe string.
+func (tc TransactionCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(tc.CreatedAt.UnixNano(), 10) + ":" + tc.UID))
+}
+
+// ParseTransactionCursor decodes a cursor encoded by TransactionCursor.String.
+func ParseTransactionCursor(s string) (TransactionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return TransactionCursor{}, ErrInvalidCursor
+	}
+
+	return TransactionCursor{CreatedAt: time.Unix(0, nanos).UTC(), UID: parts[1]}, nil
+}
+
+// before returns true if the transaction is listed before the position of the cursor.
+func (tc TransactionCursor) before(tx ListedTransaction) bool {
+	if !tx.CreatedAt.Equal(tc.CreatedAt) {
+		return tx.CreatedAt.After(tc.CreatedAt)
+	}
+	return tx.UID <= tc.UID
+}
+
+// PageTransactions sorts the transactions newest first and returns up to limit of them, starting after the cursor
+// if set. It returns the cursor of the next page, or nil if there are no more transactions.
+func PageTransactions(transactions []ListedTransaction, after *TransactionCursor, limit int) (page []ListedTransaction, next *TransactionCursor) {
+	sort.Slice(transactions, func(i, j int) bool {
+		if !transactions[i].CreatedAt.Equal(transactions[j].CreatedAt) {
+			return transactions[i].CreatedAt.After(transactions[j].CreatedAt)
+		}
+		return transactions[i].UID < transactions[j].UID
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(transactions), func(i int) bool { return !after.before(transactions[i]) })
+	}
+
+	page = transactions[start:]
+	if len(page) > limit {
+		page = page[:limit]
+		cursor := NewTransactionCursor(page[limit-1])
+		next = &cursor
+	}
+	return page, next
+}
+
+// containsState returns true if the state is in the list.
+func containsState(states []TransactionState, state TransactionState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
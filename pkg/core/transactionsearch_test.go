@@ -0,0 +1,98 @@
+package core_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionFilter(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tx := core.NewTransaction(core.CreditCard{Number: 4000000000000119}, eur(1050), createdAt, time.Hour)
+	require.NoError(t, tx.Capture(eur(1050), createdAt))
+
+	amountRange := func(min, max string) *core.AmountRange {
+		ar, err := core.NewAmountRange(min, max)
+		require.NoError(t, err)
+		return &ar
+	}
+
+	tests := map[string]struct {
+		filter         core.TransactionFilter
+		expectedResult bool
+	}{
+		"no criteria":         {filter: core.TransactionFilter{}, expectedResult: true},
+		"state":               {filter: core.TransactionFilter{States: []core.TransactionState{core.TransactionState_Voided, core.TransactionState_Captured}}, expectedResult: true},
+		"other state":         {filter: core.TransactionFilter{States: []core.TransactionState{core.TransactionState_Authorised}}, expectedResult: false},
+		"card last 4":         {filter: core.TransactionFilter{CardLast4: "0119"}, expectedResult: true},
+		"other card last 4":   {filter: core.TransactionFilter{CardLast4: "0259"}, expectedResult: false},
+		"currency":            {filter: core.TransactionFilter{Currency: "EUR"}, expectedResult: true},
+		"other currency":      {filter: core.TransactionFilter{Currency: "GBP"}, expectedResult: false},
+		"amount range":        {filter: core.TransactionFilter{AuthorisedAmount: amountRange("10.50", "20")}, expectedResult: true},
+		"amount out of range": {filter: core.TransactionFilter{AuthorisedAmount: amountRange("", "10.49")}, expectedResult: false},
+		"created window":      {filter: core.TransactionFilter{CreatedFrom: createdAt, CreatedTo: createdAt.Add(time.Hour)}, expectedResult: true},
+		"created before":      {filter: core.TransactionFilter{CreatedFrom: createdAt.Add(time.Second)}, expectedResult: false},
+		"created after":       {filter: core.TransactionFilter{CreatedTo: createdAt}, expectedResult: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expectedResult, test.filter.Matches(tx))
+		})
+	}
+}
+
+func TestPageTransactions(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Pairs of transactions created at the same time, so the UID breaks ties
+	var transactions []core.ListedTransaction
+	for i := 0; i < 7; i++ {
+		tx := core.NewTransaction(core.CreditCard{Number: 4000000000000119}, eur(1050), createdAt.Add(time.Duration(i/2)*time.Minute), 0)
+		transactions = append(transactions, core.ListedTransaction{UID: fmt.Sprintf("uid-%d", i), Transaction: tx})
+	}
+
+	var uids []string
+	var after *core.TransactionCursor
+	pages := 0
+	for {
+		page, next := core.PageTransactions(append([]core.ListedTransaction(nil), transactions...), after, 3)
+		pages++
+		for _, tx := range page {
+			uids = append(uids, tx.UID)
+		}
+		if next == nil {
+			break
+		}
+
+		// Cursors survive being sent to the client and back
+		cursor, err := core.ParseTransactionCursor(next.String())
+		require.NoError(t, err)
+		after = &cursor
+	}
+
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []string{"uid-6", "uid-4", "uid-5", "uid-2", "uid-3", "uid-0", "uid-1"}, uids)
+}
+
+func TestParseTransactionCursor(t *testing.T) {
+	tests := map[string]struct {
+		input string
+	}{
+		"not base64":   {input: "!!!"},
+		"no separator": {input: "MTIz"},
+		"no time":      {input: "OnVpZA"},
+		"no uid":       {input: "MTIzOg"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := core.ParseTransactionCursor(test.input)
+			assert.ErrorIs(t, err, core.ErrInvalidCursor)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Webhook request headers.
+const (
+	// WebhookIDHeader carries the ID of the event, the same for all the attempts to deliver it.
+	WebhookIDHeader = "X-Webhook-Id"
+	// WebhookEventHeader carries the type of the event, e.g. "transaction.captured".
+	WebhookEventHeader = "X-Webhook-Event"
+	// WebhookSignatureHeader carries the time of the attempt and the signature of the payload,
+	// e.g. "t=1614600000,v1=5257a869...".
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// maxWebhookRetryDelay caps the delay between two attempts to deliver a webhook.
+const maxWebhookRetryDelay = time.Hour
+
+// WebhookPayload is the body of the webhooks notifying an operation that changed a transaction.
+type WebhookPayload struct {
+	ID        string             `json:"id"`
+	Type      string             `json:"type"`
+	CreatedAt time.Time          `json:"created_at"`
+	Data      WebhookPayloadData `json:"data"`
+}
+
+// WebhookPayloadData holds the transaction as it stands after the operation.
+// Amounts are in major units, like in the API responses.
+type WebhookPayloadData struct {
+	AuthorisationID  string           `json:"authorisation_id"`
+	State            TransactionState `json:"state"`
+	Currency         string           `json:"currency"`
+	Amount           json.Number      `json:"amount"`
+	AuthorisedAmount json.Number      `json:"authorised_amount"`
+	CapturedAmount   json.Number      `json:"captured_amount"`
+	RefundedAmount   json.Number      `json:"refunded_amount"`
+	RemainingBalance json.Number      `json:"remaining_balance"`
+	// DeclineCode is why a settlement failed, for settlement failures.
+	DeclineCode DeclineReason `json:"decline_code,omitempty"`
+}
+
+// WebhookEventType returns the type of the webhooks notifying the event, e.g. "transaction.captured".
+func WebhookEventType(event TransactionEvent) string {
+	return "transaction." + event.Type.String()
+}
+
+// NewWebhookPayload returns the payload of the webhooks notifying the event on the transaction.
+func NewWebhookPayload(eventID string, uid string, tx Transaction, event TransactionEvent) WebhookPayload {
+	return WebhookPayload{
+		ID:        eventID,
+		Type:      WebhookEventType(event),
+		CreatedAt: event.CreatedAt,
+		Data: WebhookPayloadData{
+			AuthorisationID:  uid,
+			State:            tx.State,
+			Currency:         tx.Currency(),
+			Amount:           json.Number(event.Amount.String()),
+			AuthorisedAmount: json.Number(tx.AuthorisedAmount.String()),
+			CapturedAmount:   json.Number(tx.CapturedAmount.String()),
+			RefundedAmount:   json.Number(tx.RefundedAmount.String()),
+			RemainingBalance: json.Number(tx.RemainingBalance().String()),
+			DeclineCode:      event.Reason,
+		},
+	}
+}
+
+// WebhookSignature returns the hex encoded HMAC-SHA256 of the timestamp (in Unix seconds) and the payload,
+// joined by a dot, keyed with the secret.
+// Receivers recompute it with the timestamp sent along, and can reject old timestamps to prevent replays.
+func WebhookSignature(secret []byte, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDelivery holds a webhook to deliver to an endpoint, and the attempts made so far.
+// Delivered and failed deliveries are kept as a log for a while.
+type WebhookDelivery struct {
+	ID        string                `json:"id"`
+	EventID   string                `json:"event_id"`
+	EventType string                `json:"event_type"`
+	URL       string                `json:"url"`
+	Payload   json.RawMessage       `json:"payload"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	Attempts  []WebhookAttempt      `json:"attempts,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	// NextAttemptAt is the time of the next attempt, while the delivery is pending.
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// WebhookAttempt records an attempt to deliver a webhook.
+type WebhookAttempt struct {
+	At time.Time `json:"at"`
+	// StatusCode is the HTTP status the endpoint responded with, zero if it couldn't be reached.
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Succeeded returns true if the endpoint acknowledged the webhook with a 2xx status.
+func (wa WebhookAttempt) Succeeded() bool {
+	return wa.StatusCode >= 200 && wa.StatusCode < 300
+}
+
+// NewWebhookDelivery returns a new pending delivery of the payload to the endpoint, due straight away.
+func NewWebhookDelivery(id string, url string, payload WebhookPayload, createdAt time.Time) (WebhookDelivery, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	wd := WebhookDelivery{
+		ID:            id,
+		EventID:       payload.ID,
+		EventType:     payload.Type,
+		URL:           url,
+		Payload:       data,
+		Status:        WebhookDeliveryStatus_Pending,
+		CreatedAt:     createdAt,
+		NextAttemptAt: createdAt,
+	}
+	return wd, nil
+}
+
+// RecordAttempt records an attempt to deliver the webhook.
+// The delivery is delivered if the attempt succeeded, or failed once maxAttempts have been made.
+// Otherwise it's retried after a delay doubling on every attempt, starting from backoff and capped at an hour.
+func (wd *WebhookDelivery) RecordAttempt(attempt WebhookAttempt, maxAttempts int, backoff time.Duration) {
+	wd.Attempts = append(wd.Attempts, attempt)
+
+	switch {
+	case attempt.Succeeded():
+		wd.Status = WebhookDeliveryStatus_Delivered
+		wd.NextAttemptAt = time.Time{}
+	case len(wd.Attempts) >= maxAttempts:
+		wd.Status = WebhookDeliveryStatus_Failed
+		wd.NextAttemptAt = time.Time{}
+	default:
+		delay := backoff
+		for i := 1; i < len(wd.Attempts) && delay < maxWebhookRetryDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxWebhookRetryDelay {
+			delay = maxWebhookRetryDelay
+		}
+		wd.NextAttemptAt = attempt.At.Add(delay)
+	}
+}
+
+// FinishedAt returns the time of the last attempt, once the delivery is no longer pending.
+func (wd *WebhookDelivery) FinishedAt() (finishedAt time.Time, ok bool) {
+	if wd.Status == WebhookDeliveryStatus_Pending || len(wd.Attempts) == 0 {
+		return time.Time{}, false
+	}
+	return wd.Attempts[len(wd.Attempts)-1].At, true
+}
@@ -0,0 +1,122 @@
+package core_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	tests := map[string]struct {
+		secret            string
+		timestamp         int64
+		payload           string
+		expectedSignature string
+	}{
+		"empty payload": {
+			secret:            "secret",
+			timestamp:         1614600000,
+			payload:           "",
+			expectedSignature: "43684c2bb87e6d2a0f281cc42ab10bc5b929a54e677700dd51c1cdb3a5ad6a39",
+		},
+		"payload": {
+			secret:            "secret",
+			timestamp:         1614600000,
+			payload:           `{"id":"1"}`,
+			expectedSignature: "2eb4b0bc6a073805ddad22ae8ba14ef6ecae81ffcae9301a54e7fb50e0ba3b13",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			signature := core.WebhookSignature([]byte(test.secret), test.timestamp, []byte(test.payload))
+			assert.Equal(t, test.expectedSignature, signature)
+		})
+	}
+}
+
+func TestNewWebhookPayload(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tx := core.NewTransaction(core.CreditCard{Number: 4000000000000001}, eur(1000), createdAt, time.Hour)
+	require.NoError(t, tx.Capture(eur(800), createdAt.Add(time.Minute)))
+	require.NoError(t, tx.Refund(eur(300), createdAt.Add(2*time.Minute)))
+
+	payload := core.NewWebhookPayload("event1", "uid1", tx, tx.Events[len(tx.Events)-1])
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"id": "event1", "type": "transaction.refunded", "created_at": "2021-03-01T12:02:00Z",
+		"data": {"authorisation_id": "uid1", "state": "partially refunded", "currency": "EUR", "amount": 3.00,
+		"authorised_amount": 10.00, "captured_amount": 8.00, "refunded_amount": 3.00, "remaining_balance": 5.00}}`, string(data))
+}
+
+func TestWebhookDeliveryRecordAttempt(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	failed := func(at time.Time) core.WebhookAttempt {
+		return core.WebhookAttempt{At: at, StatusCode: 500}
+	}
+
+	tests := map[string]struct {
+		attempts              []core.WebhookAttempt
+		expectedStatus        core.WebhookDeliveryStatus
+		expectedNextAttemptAt time.Time
+	}{
+		"delivered": {
+			attempts:       []core.WebhookAttempt{{At: createdAt, StatusCode: 204}},
+			expectedStatus: core.WebhookDeliveryStatus_Delivered,
+		},
+		"first retry after the backoff": {
+			attempts:              []core.WebhookAttempt{failed(createdAt)},
+			expectedStatus:        core.WebhookDeliveryStatus_Pending,
+			expectedNextAttemptAt: createdAt.Add(time.Second),
+		},
+		"backoff doubles on every attempt": {
+			attempts:              []core.WebhookAttempt{failed(createdAt), {At: createdAt.Add(time.Second), Error: "connection refused"}, failed(createdAt.Add(3 * time.Second))},
+			expectedStatus:        core.WebhookDeliveryStatus_Pending,
+			expectedNextAttemptAt: createdAt.Add(7 * time.Second),
+		},
+		"delivered after retries": {
+			attempts:       []core.WebhookAttempt{failed(createdAt), {At: createdAt.Add(time.Second), StatusCode: 200}},
+			expectedStatus: core.WebhookDeliveryStatus_Delivered,
+		},
+		"failed after max attempts": {
+			attempts: []core.WebhookAttempt{failed(createdAt), failed(createdAt.Add(time.Second)), failed(createdAt.Add(3 * time.Second)),
+				failed(createdAt.Add(7 * time.Second)), failed(createdAt.Add(15 * time.Second))},
+			expectedStatus: core.WebhookDeliveryStatus_Failed,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			wd, err := core.NewWebhookDelivery("delivery1", "http://localhost/webhooks", core.WebhookPayload{ID: "event1"}, createdAt)
+			require.NoError(t, err)
+
+			for _, attempt := range test.attempts {
+				wd.RecordAttempt(attempt, 5, time.Second)
+			}
+
+			assert.Equal(t, test.expectedStatus, wd.Status)
+			assert.Equal(t, test.expectedNextAttemptAt, wd.NextAttemptAt)
+			assert.Len(t, wd.Attempts, len(test.attempts))
+		})
+	}
+}
+
+func TestWebhookDeliveryBackoffCap(t *testing.T) {
+	createdAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	wd, err := core.NewWebhookDelivery("delivery1", "http://localhost/webhooks", core.WebhookPayload{ID: "event1"}, createdAt)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		wd.RecordAttempt(core.WebhookAttempt{At: createdAt, StatusCode: 503}, 100, time.Minute)
+	}
+
+	assert.Equal(t, core.WebhookDeliveryStatus_Pending, wd.Status)
+	assert.Equal(t, createdAt.Add(time.Hour), wd.NextAttemptAt)
+}
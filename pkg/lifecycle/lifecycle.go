@@ -14,7 +14,8 @@ import (
 
 // TerminateHandler terminates the application.
 // This function waits on a SIGINT or SIGTERM signal and shuts down the HTTP server gracefully.
-func TerminateHandler(logger log.Logger, server core.ShutDowner) {
+// Any other components provided are shut down afterwards, in order.
+func TerminateHandler(logger log.Logger, server core.ShutDowner, components ...core.ShutDowner) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -27,4 +28,11 @@ func TerminateHandler(logger log.Logger, server core.ShutDowner) {
 	if err != nil {
 		logger.Error(fmt.Sprintf("server failed to shutdown gracefully: %s", err.Error()))
 	}
+
+	for _, component := range components {
+		err := component.ShutDown(ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("component failed to shutdown gracefully: %s", err.Error()))
+		}
+	}
 }
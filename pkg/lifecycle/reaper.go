@@ -0,0 +1,72 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+)
+
+// Reaper periodically expires stale entries in the background.
+type Reaper struct {
+	logger   log.Logger
+	expirer  core.Expirer
+	interval time.Duration
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewReaper creates a new Reaper.
+func NewReaper(logger log.Logger, expirer core.Expirer, interval time.Duration) *Reaper {
+	r := Reaper{
+		logger:   logger,
+		expirer:  expirer,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	return &r
+}
+
+// Start spawns the background goroutine.
+func (r *Reaper) Start() {
+	go r.run()
+}
+
+// run expires stale entries on every tick until the reaper is shut down.
+func (r *Reaper) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case now := <-ticker.C:
+			count, err := r.expirer.ExpireStale(now)
+			if err != nil {
+				r.logger.Error(fmt.Sprintf("reaper failed to expire some entries: %s", err), log.Field("type", "reaper"))
+			}
+			if count != 0 {
+				r.logger.Debug(fmt.Sprintf("reaper expired %d entries", count), log.Field("type", "reaper"))
+			}
+		}
+	}
+}
+
+// ShutDown stops the background goroutine and waits for it to return.
+func (r *Reaper) ShutDown(ctx context.Context) error {
+	close(r.quit)
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
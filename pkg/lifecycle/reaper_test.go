@@ -0,0 +1,50 @@
+package lifecycle_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/lifecycle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExpirer counts the sweeps made by the reaper.
+type fakeExpirer struct {
+	mu     sync.Mutex
+	sweeps int
+}
+
+func (fe *fakeExpirer) ExpireStale(now time.Time) (count int, err error) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	fe.sweeps++
+	return 1, nil
+}
+
+func (fe *fakeExpirer) Sweeps() int {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return fe.sweeps
+}
+
+func TestReaper(t *testing.T) {
+	expirer := &fakeExpirer{}
+	reaper := lifecycle.NewReaper(log.NullLogger{}, expirer, 5*time.Millisecond)
+	reaper.Start()
+
+	// Runs on every tick
+	require.Eventually(t, func() bool { return expirer.Sweeps() >= 3 }, 5*time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, reaper.ShutDown(ctx))
+
+	// No more sweeps once shut down
+	sweeps := expirer.Sweeps()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, sweeps, expirer.Sweeps())
+}
@@ -0,0 +1,119 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+)
+
+// SettlementScheduler periodically settles the captures pending settlement once they are due, in the background.
+//
+// Settlements are checked against the credit cards like any other operation: a declined settlement fails with
+// the decline reason, and any other outcome settles normally. The notifier, if any, is notified of every settlement.
+type SettlementScheduler struct {
+	logger   log.Logger
+	settler  core.Settler
+	checker  core.CreditCardChecker
+	notifier core.Notifier
+	interval time.Duration
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewSettlementScheduler creates a new SettlementScheduler. The notifier is optional.
+func NewSettlementScheduler(logger log.Logger, settler core.Settler, checker core.CreditCardChecker,
+	notifier core.Notifier, interval time.Duration) *SettlementScheduler {
+	ss := SettlementScheduler{
+		logger:   logger,
+		settler:  settler,
+		checker:  checker,
+		notifier: notifier,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	return &ss
+}
+
+// Start spawns the background goroutine.
+func (ss *SettlementScheduler) Start() {
+	go ss.run()
+}
+
+// run settles the due captures on every tick until the scheduler is shut down.
+func (ss *SettlementScheduler) run() {
+	defer close(ss.done)
+
+	ticker := time.NewTicker(ss.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ss.quit:
+			return
+		case now := <-ticker.C:
+			ss.settleDue(now)
+		}
+	}
+}
+
+// settleDue settles or fails every capture due at the provided time.
+func (ss *SettlementScheduler) settleDue(now time.Time) {
+	due, err := ss.settler.DueSettlements(now)
+	if err != nil {
+		ss.logger.Error(fmt.Sprintf("failed to read due settlements: %s", err), log.Field("type", "settlement"))
+		return
+	}
+
+	settled, failed := 0, 0
+	for _, pending := range due {
+		request := core.OperationRequest{Operation: core.CCFailReason_Settle, Card: pending.Card(), Amount: pending.CapturedAmount}
+		outcome, _ := ss.checker.Evaluate(request)
+
+		var tx core.Transaction
+		if outcome.Action == core.OutcomeAction_Decline {
+			tx, err = ss.settler.FailSettlement(pending.UID, outcome.Reason)
+		} else {
+			tx, err = ss.settler.Settle(pending.UID)
+		}
+		// The transaction may have gone in the meantime, e.g. reaped
+		if errors.Is(err, core.ErrAuthorisationNotFound) || errors.Is(err, core.ErrTransactionNotPendingSettlement) {
+			continue
+		}
+		if err != nil {
+			ss.logger.Error(fmt.Sprintf("failed to settle authorisation %s: %s", pending.UID, err), log.Field("type", "settlement"))
+			continue
+		}
+
+		if tx.State == core.TransactionState_SettlementFailed {
+			failed++
+		} else {
+			settled++
+		}
+		if ss.notifier != nil {
+			ss.notifier.Notify(pending.UID, tx, tx.Events[len(tx.Events)-1])
+		}
+	}
+
+	if settled+failed != 0 {
+		ss.logger.Debug(fmt.Sprintf("settlement batch: %d settled, %d failed", settled, failed), log.Field("type", "settlement"))
+	}
+}
+
+// ShutDown stops the background goroutine and waits for it to return.
+// Captures still pending settlement are settled on the next start, if the authorisations survive restarts.
+func (ss *SettlementScheduler) ShutDown(ctx context.Context) error {
+	close(ss.quit)
+
+	select {
+	case <-ss.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,135 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+)
+
+// FileWatcher reloads a file in the background whenever it changes, and on SIGHUP.
+// Changes are detected by checking the modification time and size of the file periodically.
+// Invalid files are logged and ignored, so the loader keeps what it held.
+type FileWatcher struct {
+	logger   log.Logger
+	filename string
+	loader   core.Loader
+	interval time.Duration
+
+	// modTime and size are the ones of the file last loaded
+	modTime time.Time
+	size    int64
+
+	hangup chan os.Signal
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// NewFileWatcher creates a new FileWatcher.
+// An interval of zero disables checking the file for changes, so it's only reloaded on SIGHUP.
+func NewFileWatcher(logger log.Logger, filename string, loader core.Loader, interval time.Duration) *FileWatcher {
+	w := FileWatcher{
+		logger:   logger,
+		filename: filename,
+		loader:   loader,
+		interval: interval,
+		hangup:   make(chan os.Signal, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	return &w
+}
+
+// Load loads the file for the first time, and must be called before Start.
+// The modification time and size are recorded before reading the file, so changes made
+// in between are reloaded once started rather than missed.
+func (w *FileWatcher) Load() error {
+	return w.load()
+}
+
+// Start spawns the background goroutine.
+// Only the changes made since the file was loaded are reloaded.
+func (w *FileWatcher) Start() {
+	signal.Notify(w.hangup, syscall.SIGHUP)
+	go w.run()
+}
+
+// run reloads the file when it changes or on SIGHUP, until the watcher is shut down.
+func (w *FileWatcher) run() {
+	defer close(w.done)
+
+	// A nil channel never fires, when checking for changes is disabled
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-w.hangup:
+			w.logger.Info("reloading file on SIGHUP", log.Field("type", "reload"), log.Field("filename", w.filename))
+			w.reload()
+		case <-tick:
+			info, err := os.Stat(w.filename)
+			if err != nil {
+				w.logger.Error(fmt.Sprintf("failed to check file for changes: %s", err.Error()), log.Field("type", "reload"))
+				continue
+			}
+			if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+				continue
+			}
+			w.logger.Info("reloading changed file", log.Field("type", "reload"), log.Field("filename", w.filename))
+			w.reload()
+		}
+	}
+}
+
+// reload loads the file, keeping what the loader held if the file can't be read or is invalid.
+func (w *FileWatcher) reload() {
+	if err := w.load(); err != nil {
+		w.logger.Error(fmt.Sprintf("failed to reload file, keeping the previous one: %s", err.Error()), log.Field("type", "reload"))
+		return
+	}
+
+	w.logger.Info("file reloaded", log.Field("type", "reload"), log.Field("filename", w.filename))
+}
+
+// load reads the file into the loader, recording its modification time and size first.
+func (w *FileWatcher) load() error {
+	info, err := os.Stat(w.filename)
+	if err != nil {
+		return err
+	}
+	// Don't retry the same content over and over if it's invalid
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	data, err := ioutil.ReadFile(w.filename)
+	if err != nil {
+		return err
+	}
+
+	return w.loader.Load(data)
+}
+
+// ShutDown stops the background goroutine and waits for it to return.
+func (w *FileWatcher) ShutDown(ctx context.Context) error {
+	signal.Stop(w.hangup)
+	close(w.quit)
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,167 @@
+package lifecycle_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/repository"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/lifecycle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Credit cards files of the same size, failing authorisations on different cards.
+const (
+	cardsFile      = "creditCards:\n  4000000000000119: \"authorise fail\"\n"
+	otherCardsFile = "creditCards:\n  4000000000000259: \"authorise fail\"\n"
+)
+
+// writeFile replaces the file atomically, so the watcher never reads it half written.
+func writeFile(t *testing.T, filename string, content string) {
+	t.Helper()
+	require.NoError(t, ioutil.WriteFile(filename+".tmp", []byte(content), 0600))
+	require.NoError(t, os.Rename(filename+".tmp", filename))
+}
+
+// signallingLoader reports the result of every load, so tests can wait for reload attempts.
+type signallingLoader struct {
+	core.Loader
+	loads chan error
+}
+
+func (sl signallingLoader) Load(data []byte) error {
+	err := sl.Loader.Load(data)
+	sl.loads <- err
+	return err
+}
+
+// waitLoad waits for the next load and returns its result.
+func waitLoad(t *testing.T, loads <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-loads:
+		return err
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "file not reloaded")
+		return nil
+	}
+}
+
+// newWatcher creates a watcher loading the file in a new credit cards checker, and loads it.
+func newWatcher(t *testing.T, filename string, interval time.Duration) (*lifecycle.FileWatcher, *repository.CreditCardFileChecker, <-chan error) {
+	t.Helper()
+	ccfc := repository.NewCreditCardFileChecker()
+	loads := make(chan error, 10)
+	watcher := lifecycle.NewFileWatcher(log.NullLogger{}, filename, signallingLoader{Loader: ccfc, loads: loads}, interval)
+	require.NoError(t, watcher.Load())
+	require.NoError(t, <-loads)
+	return watcher, ccfc, loads
+}
+
+// start starts the watcher, shutting it down at the end of the test.
+func start(t *testing.T, watcher *lifecycle.FileWatcher) {
+	t.Helper()
+	watcher.Start()
+	t.Cleanup(func() {
+		require.NoError(t, watcher.ShutDown(context.Background()))
+	})
+}
+
+// startWatcher loads the file in a new credit cards checker and starts watching it.
+func startWatcher(t *testing.T, filename string, interval time.Duration) (*repository.CreditCardFileChecker, <-chan error) {
+	t.Helper()
+	watcher, ccfc, loads := newWatcher(t, filename, interval)
+	start(t, watcher)
+	return ccfc, loads
+}
+
+// failsAuthorisation returns true if authorisations fail for the card.
+func failsAuthorisation(ccfc *repository.CreditCardFileChecker, number int64) bool {
+	_, ok := ccfc.ShouldFail(number, core.CCFailReason_Authorise)
+	return ok
+}
+
+func TestFileWatcherReloadsChangedFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cards.yaml")
+	writeFile(t, filename, cardsFile)
+	ccfc, loads := startWatcher(t, filename, 5*time.Millisecond)
+	require.True(t, failsAuthorisation(ccfc, 4000000000000119))
+
+	// Size changed
+	writeFile(t, filename, cardsFile+"  4000000000000259: \"authorise fail\"\n")
+	require.NoError(t, waitLoad(t, loads))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000259))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000119))
+}
+
+func TestFileWatcherReloadsTouchedFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cards.yaml")
+	writeFile(t, filename, cardsFile)
+	ccfc, loads := startWatcher(t, filename, 5*time.Millisecond)
+
+	// Same size, only the modification time tells them apart
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	writeFile(t, filename, otherCardsFile)
+	modTime := info.ModTime().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filename, modTime, modTime))
+
+	require.NoError(t, waitLoad(t, loads))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000259))
+	assert.False(t, failsAuthorisation(ccfc, 4000000000000119))
+}
+
+func TestFileWatcherReloadsFileChangedBeforeStart(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cards.yaml")
+	writeFile(t, filename, cardsFile)
+	watcher, ccfc, loads := newWatcher(t, filename, 5*time.Millisecond)
+
+	// Changed between the first load and the start
+	writeFile(t, filename, cardsFile+"  4000000000000259: \"authorise fail\"\n")
+	start(t, watcher)
+
+	require.NoError(t, waitLoad(t, loads))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000259))
+}
+
+func TestFileWatcherKeepsPreviousCardsOnInvalidFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cards.yaml")
+	writeFile(t, filename, cardsFile)
+	ccfc, loads := startWatcher(t, filename, 5*time.Millisecond)
+
+	writeFile(t, filename, "creditCards: [")
+	require.Error(t, waitLoad(t, loads))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000119))
+
+	// Still watching once the file is fixed
+	writeFile(t, filename, otherCardsFile)
+	require.NoError(t, waitLoad(t, loads))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000259))
+	assert.False(t, failsAuthorisation(ccfc, 4000000000000119))
+}
+
+func TestFileWatcherReloadsOnSIGHUP(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cards.yaml")
+	writeFile(t, filename, cardsFile)
+	// Changes are only picked up on SIGHUP
+	ccfc, loads := startWatcher(t, filename, 0)
+
+	writeFile(t, filename, otherCardsFile)
+	assert.Empty(t, loads)
+	require.True(t, failsAuthorisation(ccfc, 4000000000000119))
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(syscall.SIGHUP))
+
+	require.NoError(t, waitLoad(t, loads))
+	assert.True(t, failsAuthorisation(ccfc, 4000000000000259))
+	assert.False(t, failsAuthorisation(ccfc, 4000000000000119))
+}
@@ -0,0 +1,193 @@
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core"
+	"github.com/gustavooferreira/pgw-payment-processor-service/pkg/core/log"
+)
+
+// webhookPollInterval is how often the queue is checked for deliveries due for a retry.
+const webhookPollInterval = time.Second
+
+// webhookTimeout is how long an endpoint has to acknowledge a webhook.
+const webhookTimeout = 10 * time.Second
+
+// WebhookDispatcher notifies the configured endpoints of the operations changing a transaction.
+//
+// Notifications are queued in the store, one delivery per endpoint, and delivered in the background
+// so the API never waits on the endpoints. Failed deliveries are retried with an exponential backoff.
+type WebhookDispatcher struct {
+	logger      log.Logger
+	store       core.WebhookStore
+	urls        []string
+	secret      []byte
+	maxAttempts int
+	backoff     time.Duration
+	client      *http.Client
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWebhookDispatcher creates a new WebhookDispatcher.
+func NewWebhookDispatcher(logger log.Logger, store core.WebhookStore, urls []string, secret string,
+	maxAttempts int, backoff time.Duration) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	wd := WebhookDispatcher{
+		logger:      logger,
+		store:       store,
+		urls:        urls,
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		client:      &http.Client{Timeout: webhookTimeout},
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	return &wd
+}
+
+// Notify queues a delivery of the event to every endpoint.
+// Failing to queue them is logged, the operation on the transaction having already happened.
+func (wd *WebhookDispatcher) Notify(uid string, tx core.Transaction, event core.TransactionEvent) {
+	now := time.Now()
+	payload := core.NewWebhookPayload(uuid.NewString(), uid, tx, event)
+
+	deliveries := make([]core.WebhookDelivery, 0, len(wd.urls))
+	for _, url := range wd.urls {
+		delivery, err := core.NewWebhookDelivery(uuid.NewString(), url, payload, now)
+		if err != nil {
+			wd.logger.Error(fmt.Sprintf("failed to create webhook delivery: %s", err), log.Field("type", "webhook"))
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	err := wd.store.Add(deliveries)
+	if err != nil {
+		wd.logger.Error(fmt.Sprintf("failed to queue webhook deliveries: %s", err), log.Field("type", "webhook"))
+		return
+	}
+
+	select {
+	case wd.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ListDeliveries returns the deliveries in the store, the newest first.
+func (wd *WebhookDispatcher) ListDeliveries() ([]core.WebhookDelivery, error) {
+	return wd.store.ListDeliveries()
+}
+
+// Start spawns the background goroutine.
+func (wd *WebhookDispatcher) Start() {
+	go wd.run()
+}
+
+// run delivers the due deliveries whenever new ones are queued and on every tick,
+// until the dispatcher is shut down.
+func (wd *WebhookDispatcher) run() {
+	defer close(wd.done)
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wd.ctx.Done():
+			return
+		case <-wd.wake:
+		case <-ticker.C:
+		}
+		wd.deliverDue()
+	}
+}
+
+// deliverDue attempts every delivery due now, one at a time.
+func (wd *WebhookDispatcher) deliverDue() {
+	due, err := wd.store.Due(time.Now())
+	if err != nil {
+		wd.logger.Error(fmt.Sprintf("failed to read webhook queue: %s", err), log.Field("type", "webhook"))
+		return
+	}
+
+	for _, delivery := range due {
+		attempt := wd.send(delivery)
+		// An attempt interrupted by the shutdown isn't recorded, it's made again on the next start
+		if wd.ctx.Err() != nil {
+			return
+		}
+
+		delivery.RecordAttempt(attempt, wd.maxAttempts, wd.backoff)
+		err := wd.store.Update(delivery)
+		if err != nil {
+			wd.logger.Error(fmt.Sprintf("failed to update webhook delivery: %s", err), log.Field("type", "webhook"))
+			continue
+		}
+
+		switch delivery.Status {
+		case core.WebhookDeliveryStatus_Delivered:
+			wd.logger.Debug(fmt.Sprintf("webhook %s delivered to %s", delivery.EventID, delivery.URL), log.Field("type", "webhook"))
+		case core.WebhookDeliveryStatus_Failed:
+			wd.logger.Error(fmt.Sprintf("webhook %s to %s failed after %d attempts", delivery.EventID, delivery.URL, len(delivery.Attempts)),
+				log.Field("type", "webhook"))
+		}
+	}
+}
+
+// send posts the signed payload to the endpoint of the delivery.
+func (wd *WebhookDispatcher) send(delivery core.WebhookDelivery) core.WebhookAttempt {
+	now := time.Now()
+	attempt := core.WebhookAttempt{At: now}
+
+	req, err := http.NewRequestWithContext(wd.ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	signature := core.WebhookSignature(wd.secret, now.Unix(), delivery.Payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(core.WebhookIDHeader, delivery.EventID)
+	req.Header.Set(core.WebhookEventHeader, delivery.EventType)
+	req.Header.Set(core.WebhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", now.Unix(), signature))
+
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	attempt.StatusCode = resp.StatusCode
+	return attempt
+}
+
+// ShutDown stops the background goroutine, interrupting any delivery in progress, and waits for it to return.
+// Pending deliveries stay in the store.
+func (wd *WebhookDispatcher) ShutDown(ctx context.Context) error {
+	wd.cancel()
+
+	select {
+	case <-wd.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}